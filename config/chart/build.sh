@@ -20,9 +20,17 @@ templatizeDeployment() {
         sed 's/VERSION/"{{ .Chart.AppVersion }}"/g' | \
         sed 's/IMG:TAG/"{{ .Values.redskyImage }}:{{ .Values.redskyTag }}"/g' | \
         sed 's/PULL_POLICY/{{ .Values.redskyImagePullPolicy }}/g' | \
+        sed 's/redsky-webhook-service\.redsky-system\.svc/{{ .Release.Name }}-webhook-service.{{ .Release.Namespace }}.svc/g' | \
         sed 's/name: redsky-\(.*\)$/name: "{{ .Release.Name }}-\1"/g'
 }
 
+# Post process the CRD manifest
+templatizeCRD() {
+    sed 's/namespace: system$/namespace: {{ .Release.Namespace | quote }}/g' | \
+        sed 's/name: webhook-service$/name: "{{ .Release.Name }}-webhook-service"/g' | \
+        sed 's|$(CERTIFICATE_NAMESPACE)/$(CERTIFICATE_NAME)|{{ .Release.Namespace }}/{{ .Release.Name }}-serving-cert|g'
+}
+
 # Post process the RBAC manifest
 templatizeRBAC() {
     sed 's/namespace: redsky-system/namespace: {{ .Release.Namespace | quote }}/g' | \
@@ -89,7 +97,7 @@ kustomize edit set nameprefix "redsky-"
 
 # Build the templates for the chart
 cd "$WORKSPACE"
-kustomize build crd | label > "$WORKSPACE/chart/redskyops/templates/crds.yaml"
+kustomize build crd | templatizeCRD | label > "$WORKSPACE/chart/redskyops/templates/crds.yaml"
 kustomize build rbac | templatizeRBAC | label > "$WORKSPACE/chart/redskyops/templates/rbac.yaml"
 kustomize build chart | templatizeDeployment | label > "$WORKSPACE/chart/redskyops/templates/deployment.yaml"
 
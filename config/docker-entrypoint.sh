@@ -22,6 +22,51 @@ kustomize create --namespace "$NAMESPACE"
 find . -type f -name "*.yaml" ! -name "kustomization.yaml" -exec kustomize edit add resource {} +
 
 
+# Add plain manifests, either rendered by the controller or fetched from a URL
+if [ -n "$MANIFESTS_PATH" ] ; then
+    mkdir manifests
+    for f in "$MANIFESTS_PATH"/* ; do
+        if [ -f "$f" ] ; then
+            cp "$f" manifests/
+        fi
+    done
+    # The rendered manifests may already be gone when deleting
+    if [ -z "$(ls manifests)" ] ; then
+        rmdir manifests
+        MANIFESTS_MISSING=true
+    fi
+elif [ -n "$MANIFESTS_URL" ] ; then
+    case "$MANIFESTS_URL" in
+    oci://*)
+        mkdir manifests
+        oras pull "${MANIFESTS_URL#oci://}" --output manifests
+        ;;
+    *)
+        kustomize edit add resource "$MANIFESTS_URL"
+        ;;
+    esac
+fi
+if [ -d manifests ] ; then
+    if [ ! -f manifests/kustomization.yaml ] ; then
+        (cd manifests && kustomize create --autodetect)
+    fi
+    kustomize edit add resource manifests
+fi
+
+
+# Add a private Helm repository, e.g. using credentials from a secret referenced by "envFrom"
+if [ -n "$HELM_REPO_URL" ] ; then
+    if [ -n "$HELM_REPO_PASSWORD" ] ; then
+        # Keep the password off the command line so it is not visible in the process list
+        printf '%s' "$HELM_REPO_PASSWORD" | helm repo add "${HELM_REPO_NAME:-private}" "$HELM_REPO_URL" \
+            ${HELM_REPO_USERNAME:+--username "$HELM_REPO_USERNAME"} --password-stdin
+    else
+        helm repo add "${HELM_REPO_NAME:-private}" "$HELM_REPO_URL" \
+            ${HELM_REPO_USERNAME:+--username "$HELM_REPO_USERNAME"}
+    fi
+fi
+
+
 # Add Helm configuration
 if [ -n "$HELM_CONFIG" ] ; then
     echo "$HELM_CONFIG" | base64 -d > helm.yaml
@@ -48,8 +93,26 @@ fi
 while [ "$#" != "0" ] ; do
     case "$1" in
     create)
+        if [ -n "$MANIFESTS_MISSING" ] ; then
+            echo "missing rendered manifests in $MANIFESTS_PATH"
+            exit 1
+        fi
+        # Shared objects may be left behind by a failed attempt, in that case they are applied instead
+        if [ -n "$APPLY" ] ; then
+            verb=apply
+        else
+            verb=create
+        fi
         handle () {
-            kubectl create -f -
+            if [ -n "$WAIT" ] ; then
+                # Other setup tasks depend on this one, wait for the created objects to be ready
+                kubectl "$verb" -f - -o name > created.txt
+                cat created.txt
+                grep -E '^(deployment|statefulset|daemonset)\.' created.txt | xargs -r -n 1 kubectl rollout status --namespace "$NAMESPACE"
+                grep -E '^job\.' created.txt | xargs -r -n 1 kubectl wait --for=condition=complete --namespace "$NAMESPACE"
+            else
+                kubectl "$verb" -f -
+            fi
             #if [ -n "$TRIAL" ] && [ -n "$NAMESPACE" ] ; then
             #    kubectl get sts,deploy,ds --namespace "$NAMESPACE" --selector "redskyops.dev/trial=$TRIAL,redskyops.dev/trial-role=trialResource" -o name | xargs -n 1 kubectl rollout status --namespace "$NAMESPACE"
             #fi
@@ -57,10 +120,34 @@ while [ "$#" != "0" ] ; do
         shift
         ;;
     delete)
-        handle () {
-            kubectl delete -f -
+        # NOTE: "set -e" does not apply when this is called as a condition, every failure must be returned explicitly
+        delete_resources () {
+            cat > resources.yaml || return 1
+            if [ -s resources.yaml ] ; then
+                kubectl delete -f resources.yaml --ignore-not-found || return 1
+            fi
+            if [ -n "$MANIFESTS_MISSING" ] && [ -n "$TRIAL" ] ; then
+                # Without the manifests, fall back to deleting everything labeled for the trial
+                kubectl delete "$(kubectl api-resources --verbs=list,delete --namespaced -o name | tr '\n' ',' | sed 's/,$//')" \
+                    --ignore-not-found --namespace "$NAMESPACE" --selector "redskyops.dev/trial=$TRIAL,redskyops.dev/trial-role=trialResource" || return 1
+            fi
             if [ -n "$TRIAL" ] && [ -n "$NAMESPACE" ] ; then
-                kubectl wait pods --for=delete --namespace "$NAMESPACE" --selector "redskyops.dev/trial=$TRIAL,redskyops.dev/trial-role=trialResource"
+                kubectl wait pods --for=delete --namespace "$NAMESPACE" --selector "redskyops.dev/trial=$TRIAL,redskyops.dev/trial-role=trialResource" || return 1
+            fi
+        }
+        handle () {
+            if ! delete_resources ; then
+                if [ -n "$CONTINUE_ON_ERROR" ] ; then
+                    # Record the failure and let the remaining setup tasks delete their objects
+                    echo "failed to delete $NAME, continuing"
+                    touch "$DELETE_ERRORS_PATH/$NAME"
+                    return 0
+                fi
+                return 1
+            fi
+            if [ -n "$DELETE_ERRORS_PATH" ] && [ -z "$CONTINUE_ON_ERROR" ] && [ -n "$(ls "$DELETE_ERRORS_PATH")" ] ; then
+                echo "failed to delete:" $(ls "$DELETE_ERRORS_PATH")
+                return 1
             fi
         }
         shift
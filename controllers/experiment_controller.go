@@ -18,15 +18,22 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
+	"sort"
 
 	"github.com/go-logr/logr"
 	"github.com/redskyops/redskyops-controller/internal/controller"
 	"github.com/redskyops/redskyops-controller/internal/experiment"
 	"github.com/redskyops/redskyops-controller/internal/meta"
+	"github.com/redskyops/redskyops-controller/internal/patch"
+	"github.com/redskyops/redskyops-controller/internal/setup"
 	"github.com/redskyops/redskyops-controller/internal/trial"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -38,10 +45,16 @@ import (
 type ExperimentReconciler struct {
 	client.Client
 	Log logr.Logger
+
+	// Keep the raw API reader for reading the config maps used to render the shared setup tasks and restore patches
+	apiReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=redskyops.dev,resources=experiments,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups=redskyops.dev,resources=trials,verbs=list;watch;update;delete
+// +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;delete
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get
 
 func (r *ExperimentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -64,14 +77,23 @@ func (r *ExperimentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 		return *result, err
 	}
 
+	if result, err := r.restorePatches(ctx, exp, trialList); result != nil {
+		return *result, err
+	}
+
 	if result, err := r.cleanupTrials(ctx, exp, trialList); result != nil {
 		return *result, err
 	}
 
+	if result, err := r.deleteSharedSetup(ctx, exp, trialList); result != nil {
+		return *result, err
+	}
+
 	return ctrl.Result{}, nil
 }
 
 func (r *ExperimentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("experiment").
 		For(&redskyv1alpha1.Experiment{}).
@@ -137,6 +159,86 @@ func (r *ExperimentReconciler) updateTrialStatus(ctx context.Context, trialList
 	return nil, nil
 }
 
+// restorePatches will put the patched objects back to their original state once the experiment is finished
+func (r *ExperimentReconciler) restorePatches(ctx context.Context, exp *redskyv1alpha1.Experiment, trialList *redskyv1alpha1.TrialList) (*ctrl.Result, error) {
+	// Individual trials restore their own patches unless the restore is deferred until the experiment finishes
+	if exp.Spec.Restore != redskyv1alpha1.RestoreOnFinish {
+		if meta.RemoveFinalizer(exp, patch.RestoreFinalizer) {
+			err := r.Update(ctx, exp)
+			return controller.RequeueConflict(err)
+		}
+		return nil, nil
+	}
+
+	// Make sure the experiment is not deleted before the patched objects are restored
+	if exp.Status.Phase != experiment.PhaseCompleted && exp.GetDeletionTimestamp().IsZero() {
+		if meta.AddFinalizer(exp, patch.RestoreFinalizer) {
+			err := r.Update(ctx, exp)
+			return controller.RequeueConflict(err)
+		}
+		return nil, nil
+	}
+
+	// Wait for all of the trials to finish, we will be reconciled again when they change
+	for i := range trialList.Items {
+		if trial.IsActive(&trialList.Items[i]) {
+			return nil, nil
+		}
+	}
+
+	// The patch controller records the original state of each patched object in the restore config map
+	cm := &corev1.ConfigMap{}
+	cmKey := client.ObjectKey{Namespace: exp.Namespace, Name: patch.RestoreConfigMapName(exp.Name)}
+	if err := r.apiReader.Get(ctx, cmKey, cm); controller.IgnoreNotFound(err) != nil {
+		return &ctrl.Result{}, err
+	}
+
+	if len(cm.Data) > 0 {
+		keys := make([]string, 0, len(cm.Data))
+		for k := range cm.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pos := make([]redskyv1alpha1.PatchOperation, len(keys))
+		for i, k := range keys {
+			if err := json.Unmarshal([]byte(cm.Data[k]), &pos[i]); err != nil {
+				return &ctrl.Result{}, err
+			}
+		}
+		restoreErr := restorePatchOperations(ctx, r, pos)
+
+		// Record the outcome on the trials which were waiting for the experiment to finish
+		now := metav1.Now()
+		for i := range trialList.Items {
+			t := &trialList.Items[i]
+			if !trial.CheckCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionUnknown) {
+				continue
+			}
+			if restoreErr != nil {
+				trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionTrue, "RestoreFailed", restoreErr.Error(), &now)
+			} else {
+				trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionTrue, "", "", &now)
+			}
+			if err := r.Update(ctx, t); err != nil {
+				return controller.RequeueConflict(err)
+			}
+		}
+	}
+
+	// Remove the config map so the original state is captured again if the experiment is resumed
+	if !cm.CreationTimestamp.IsZero() {
+		if err := r.Delete(ctx, cm); controller.IgnoreNotFound(err) != nil {
+			return &ctrl.Result{}, err
+		}
+	}
+
+	if meta.RemoveFinalizer(exp, patch.RestoreFinalizer) {
+		err := r.Update(ctx, exp)
+		return controller.RequeueConflict(err)
+	}
+	return nil, nil
+}
+
 // cleanupTrials will delete any trials whose TTL has expired or are active past
 func (r *ExperimentReconciler) cleanupTrials(ctx context.Context, exp *redskyv1alpha1.Experiment, trialList *redskyv1alpha1.TrialList) (*ctrl.Result, error) {
 	for i := range trialList.Items {
@@ -158,6 +260,152 @@ func (r *ExperimentReconciler) cleanupTrials(ctx context.Context, exp *redskyv1a
 	return nil, nil
 }
 
+// deleteSharedSetup will delete the experiment scoped setup tasks from every namespace once the experiment is finished
+func (r *ExperimentReconciler) deleteSharedSetup(ctx context.Context, exp *redskyv1alpha1.Experiment, trialList *redskyv1alpha1.TrialList) (*ctrl.Result, error) {
+	// Use a trial from the template to determine which setup tasks are shared
+	t := &redskyv1alpha1.Trial{}
+	experiment.PopulateTrialFromTemplate(exp, t)
+	if !setup.HasTasks(t, redskyv1alpha1.SetupScopeExperiment, setup.ModeCreate) {
+		if meta.RemoveFinalizer(exp, experiment.SharedSetupFinalizer) {
+			err := r.Update(ctx, exp)
+			return controller.RequeueConflict(err)
+		}
+		return nil, nil
+	}
+
+	// Make sure the experiment is not deleted before the shared setup tasks are
+	finished := exp.Status.Phase == experiment.PhaseCompleted || !exp.GetDeletionTimestamp().IsZero()
+	if !finished && meta.AddFinalizer(exp, experiment.SharedSetupFinalizer) {
+		err := r.Update(ctx, exp)
+		return controller.RequeueConflict(err)
+	}
+
+	// Index the shared setup jobs by namespace
+	jobList := &batchv1.JobList{}
+	if err := r.List(ctx, jobList, client.MatchingLabels{redskyv1alpha1.LabelExperiment: exp.Name, redskyv1alpha1.LabelTrialRole: "experimentSetup"}); err != nil {
+		return &ctrl.Result{}, err
+	}
+	jobs := make(map[string]map[string]*batchv1.Job)
+	for i := range jobList.Items {
+		job := &jobList.Items[i]
+		if jobs[job.Namespace] == nil {
+			jobs[job.Namespace] = make(map[string]*batchv1.Job, 2)
+		}
+		jobs[job.Namespace][job.Name] = job
+	}
+
+	// Find the namespaces where trials are still running
+	activeNamespaces := make(map[string]bool, len(trialList.Items))
+	for i := range trialList.Items {
+		if t := &trialList.Items[i]; trial.IsActive(t) {
+			activeNamespaces[t.Namespace] = true
+		}
+	}
+
+	// Delete the shared setup from each namespace once the experiment is finished or the namespace is released
+	var pending, waiting bool
+	createJobName := setup.ExperimentJobName(exp.Name, setup.ModeCreate)
+	deleteJobName := setup.ExperimentJobName(exp.Name, setup.ModeDelete)
+	for namespace, nsJobs := range jobs {
+		// Wait for the trials to be torn down, we will be reconciled again when they change
+		if activeNamespaces[namespace] {
+			waiting = true
+			continue
+		}
+
+		if !finished {
+			released, err := r.namespaceReleased(ctx, exp, namespace)
+			if err != nil {
+				return &ctrl.Result{}, err
+			}
+			if !released {
+				continue
+			}
+		}
+
+		deleteJob := nsJobs[deleteJobName]
+
+		// Create the delete job
+		if deleteJob == nil && nsJobs[createJobName] != nil && setup.HasTasks(t, redskyv1alpha1.SetupScopeExperiment, setup.ModeDelete) {
+			te, err := templateEngine(ctx, r.apiReader, exp)
+			if err != nil {
+				return &ctrl.Result{}, err
+			}
+			t.Namespace = namespace
+			job, err := setup.NewExperimentJob(t, setup.ModeDelete, te)
+			if err != nil {
+				return &ctrl.Result{}, err
+			}
+			err = r.Create(ctx, job)
+			if controller.IgnoreAlreadyExists(err) == nil {
+				pending = true
+				continue
+			}
+
+			// Forbidden indicates that namespace was probably deleted, there is nothing left to tear down
+			if !apierrs.IsForbidden(err) {
+				return &ctrl.Result{}, err
+			}
+		}
+
+		// Wait for the delete job to finish (regardless of the outcome)
+		if deleteJob != nil {
+			if conditionStatus, _ := setup.GetConditionStatus(deleteJob); conditionStatus != corev1.ConditionTrue {
+				pending = true
+				continue
+			}
+		}
+
+		// Remove the jobs so the shared setup is created again if the experiment is resumed or the namespace reused
+		for _, job := range nsJobs {
+			if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); controller.IgnoreNotFound(err) != nil {
+				return &ctrl.Result{}, err
+			}
+		}
+		for _, name := range setup.ExperimentManifestsNames(t) {
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+			if err := r.Delete(ctx, cm); controller.IgnoreNotFound(err) != nil {
+				return &ctrl.Result{}, err
+			}
+		}
+	}
+	if pending {
+		return &ctrl.Result{RequeueAfter: setupPollInterval}, nil
+	}
+
+	if finished && !waiting && meta.RemoveFinalizer(exp, experiment.SharedSetupFinalizer) {
+		err := r.Update(ctx, exp)
+		return controller.RequeueConflict(err)
+	}
+	return nil, nil
+}
+
+// namespaceReleased checks if a namespace with shared setup can no longer be used by the trials of an experiment
+func (r *ExperimentReconciler) namespaceReleased(ctx context.Context, exp *redskyv1alpha1.Experiment, name string) (bool, error) {
+	ns := &corev1.Namespace{}
+	if err := r.apiReader.Get(ctx, client.ObjectKey{Name: name}, ns); err != nil {
+		if apierrs.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	// Namespaces which are being deleted are gone for good
+	if !ns.DeletionTimestamp.IsZero() {
+		return true, nil
+	}
+
+	// Namespaces are only released by the namespace selector if there isn't an explicit namespace
+	if exp.Spec.Template.Namespace != "" || exp.Spec.NamespaceSelector == nil {
+		return false, nil
+	}
+	sel, err := metav1.LabelSelectorAsSelector(exp.Spec.NamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+	return !sel.Matches(labels.Set(ns.Labels)), nil
+}
+
 // listTrials retrieves the list of trial objects matching the specified selector
 func (r *ExperimentReconciler) listTrials(ctx context.Context, trialList *redskyv1alpha1.TrialList, selector *metav1.LabelSelector) error {
 	matchingSelector, err := meta.MatchingSelector(selector)
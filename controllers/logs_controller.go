@@ -0,0 +1,163 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/redskyops/redskyops-controller/internal/controller"
+	"github.com/redskyops/redskyops-controller/internal/trial"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// LogsReconciler archives the logs of the trial run and setup pods once a trial is finished
+type LogsReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// Keep the raw API reader for checking if the logs were already archived, we do not want to cache config maps
+	apiReader client.Reader
+	// The controller-runtime client cannot read pod logs, we need a typed client for that
+	pods corev1client.PodsGetter
+}
+
+// +kubebuilder:rbac:groups=redskyops.dev,resources=experiments,verbs=get;list;watch
+// +kubebuilder:rbac:groups=redskyops.dev,resources=trials,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=list
+// +kubebuilder:rbac:groups="",resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create
+
+// Reconcile collects the logs of the pods labeled with the trial name and a trial role of either "trialRun" or
+// "trialSetup" and stores them in a config map. The config map is owned by the experiment (when it is in the same
+// namespace as the trial) so the logs remain available after the trial is cleaned up.
+func (r *LogsReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+
+	t := &redskyv1alpha1.Trial{}
+	if err := r.Get(ctx, req.NamespacedName, t); err != nil || r.ignoreTrial(t) {
+		return ctrl.Result{}, controller.IgnoreNotFound(err)
+	}
+
+	// Check if the logs were already archived
+	cm := &corev1.ConfigMap{}
+	if err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: t.Namespace, Name: trial.LogsConfigMapName(t)}, cm); err == nil {
+		return ctrl.Result{}, nil
+	} else if !apierrs.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	logs, err := r.collectLogs(ctx, t)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	cm = trial.NewLogsConfigMap(t, logs, trial.MaxLogsSize)
+
+	// Prefer the experiment as the owner so the logs outlive the trial (which may be deleted as soon as it finishes),
+	// owner references cannot cross namespaces so the trial is used when the experiment is in another namespace
+	var owner metav1.Object = t
+	exp := &redskyv1alpha1.Experiment{}
+	if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); controller.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	} else if err == nil && exp.Namespace == t.Namespace {
+		owner = exp
+	}
+	if err := controllerutil.SetControllerReference(owner, cm, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.Create(ctx, cm); err != nil && !apierrs.IsAlreadyExists(err) {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager registers a new logs reconciler with the supplied manager
+func (r *LogsReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
+	cs, err := corev1client.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	r.pods = cs
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("logs").
+		For(&redskyv1alpha1.Trial{}).
+		Complete(r)
+}
+
+// ignoreTrial determines which trial objects can be ignored by this reconciler
+func (r *LogsReconciler) ignoreTrial(t *redskyv1alpha1.Trial) bool {
+	// Ignore deleted trials, the pods may already be gone
+	if !t.DeletionTimestamp.IsZero() {
+		return true
+	}
+
+	// Ignore trials that are still running
+	if !trial.IsFinished(t) {
+		return true
+	}
+
+	// Ignore trials whose setup delete job has not finished yet
+	if trial.CheckCondition(&t.Status, redskyv1alpha1.TrialSetupDeleted, corev1.ConditionUnknown) ||
+		trial.CheckCondition(&t.Status, redskyv1alpha1.TrialSetupDeleted, corev1.ConditionFalse) {
+		return true
+	}
+
+	// Reconcile everything else
+	return false
+}
+
+// collectLogs returns the tail of the logs of every container in the trial run and setup pods
+func (r *LogsReconciler) collectLogs(ctx context.Context, t *redskyv1alpha1.Trial) (map[string][]byte, error) {
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(t.Namespace), client.MatchingLabels{redskyv1alpha1.LabelTrial: t.Name}); err != nil {
+		return nil, err
+	}
+
+	logs := make(map[string][]byte)
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if role := pod.Labels[redskyv1alpha1.LabelTrialRole]; role != "trialRun" && role != "trialSetup" {
+			continue
+		}
+
+		var containers []corev1.Container
+		containers = append(containers, pod.Spec.InitContainers...)
+		containers = append(containers, pod.Spec.Containers...)
+		for _, c := range containers {
+			tailLines := int64(trial.LogsTailLines)
+			data, err := r.pods.Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: c.Name, TailLines: &tailLines}).Context(ctx).DoRaw()
+			if err != nil {
+				// Containers that never started do not have logs, record the failure instead
+				data = []byte(fmt.Sprintf("unable to get logs: %v\n", err))
+			}
+			logs[trial.LogsKey(pod.Name, c.Name)] = data
+		}
+	}
+	return logs, nil
+}
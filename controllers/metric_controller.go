@@ -39,6 +39,9 @@ type MetricReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Keep the raw API reader for reading the config maps used by the metric queries
+	apiReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=redskyops.dev,resources=experiments,verbs=get;list;watch
@@ -55,6 +58,10 @@ func (r *MetricReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, controller.IgnoreNotFound(err)
 	}
 
+	if result, err := r.measureTrial(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
 	if result, err := r.evaluateMetrics(ctx, t, &now); result != nil {
 		return *result, err
 	}
@@ -67,6 +74,7 @@ func (r *MetricReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 }
 
 func (r *MetricReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("metric").
 		For(&redskyv1alpha1.Trial{}).
@@ -106,6 +114,25 @@ func (r *MetricReconciler) ignoreTrial(t *redskyv1alpha1.Trial) bool {
 	return true
 }
 
+func (r *MetricReconciler) measureTrial(ctx context.Context, t *redskyv1alpha1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Only record the measurement window once
+	if t.Status.MeasurementStartTime != nil && t.Status.MeasurementCompletionTime != nil {
+		return nil, nil
+	}
+
+	// Record the measurement window so it is visible in the trial status, fail the trial if the window does not fit
+	startTime, completionTime, err := trial.MeasurementWindow(t)
+	if err != nil {
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, "InvalidMeasurementWindow", err.Error(), probeTime)
+	} else {
+		t.Status.MeasurementStartTime = startTime
+		t.Status.MeasurementCompletionTime = completionTime
+	}
+
+	err = r.Update(ctx, t)
+	return controller.RequeueConflict(err)
+}
+
 func (r *MetricReconciler) evaluateMetrics(ctx context.Context, t *redskyv1alpha1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
 	// TODO This check precludes manual additions of Values
 	if len(t.Spec.Values) > 0 {
@@ -146,6 +173,10 @@ func (r *MetricReconciler) collectMetrics(ctx context.Context, t *redskyv1alpha1
 	for i := range exp.Spec.Metrics {
 		metrics[exp.Spec.Metrics[i].Name] = &exp.Spec.Metrics[i]
 	}
+	te, err := templateEngine(ctx, r.apiReader, exp)
+	if err != nil {
+		return &ctrl.Result{}, err
+	}
 
 	// Iterate over the metric values, looking for remaining attempts
 	log := r.Log.WithValues("trial", fmt.Sprintf("%s/%s", t.Namespace, t.Name))
@@ -157,9 +188,9 @@ func (r *MetricReconciler) collectMetrics(ctx context.Context, t *redskyv1alpha1
 
 		// Capture the metric
 		var captureError error
-		if target, err := r.target(ctx, t.Namespace, metrics[v.Name]); err != nil {
+		if target, err := metricTarget(ctx, r, t.Namespace, metrics[v.Name]); err != nil {
 			captureError = err
-		} else if value, stddev, err := metric.CaptureMetric(metrics[v.Name], t, target); err != nil {
+		} else if value, stddev, err := metric.CaptureMetric(te, metrics[v.Name], t, target); err != nil {
 			if merr, ok := err.(*metric.CaptureError); ok && merr.RetryAfter > 0 {
 				// Do not count retries against the remaining attempts
 				return &ctrl.Result{RequeueAfter: merr.RetryAfter}, nil
@@ -193,11 +224,12 @@ func (r *MetricReconciler) collectMetrics(ctx context.Context, t *redskyv1alpha1
 
 	// We made it through all of the metrics without needing additional changes
 	trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialObserved, corev1.ConditionTrue, "", "", probeTime)
-	err := r.Update(ctx, t)
+	err = r.Update(ctx, t)
 	return controller.RequeueConflict(err)
 }
 
-func (r *MetricReconciler) target(ctx context.Context, namespace string, m *redskyv1alpha1.Metric) (runtime.Object, error) {
+// metricTarget returns the object (typically a list of services or pods) that a metric should be collected from
+func metricTarget(ctx context.Context, r client.Reader, namespace string, m *redskyv1alpha1.Metric) (runtime.Object, error) {
 	switch m.Type {
 	case redskyv1alpha1.MetricPods:
 		// Use the selector to get a list of pods
@@ -17,24 +17,30 @@ limitations under the License.
 package controllers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/redskyops/redskyops-controller/internal/controller"
+	"github.com/redskyops/redskyops-controller/internal/meta"
+	"github.com/redskyops/redskyops-controller/internal/patch"
 	"github.com/redskyops/redskyops-controller/internal/ready"
 	"github.com/redskyops/redskyops-controller/internal/template"
 	"github.com/redskyops/redskyops-controller/internal/trial"
 	"github.com/redskyops/redskyops-controller/internal/validation"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 // PatchReconciler reconciles the patches on a Trial object
@@ -42,25 +48,39 @@ type PatchReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Keep the raw API reader for fetching the state of patch targets and config maps used by the patch templates,
+	// we may only have get permission on those objects and the caching reader would require list/watch.
+	apiReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=redskyops.dev,resources=experiments,verbs=get;list;watch
 // +kubebuilder:rbac:groups=redskyops.dev,resources=trials,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
 
 // Reconcile inspects a trial to see if patches need to be applied. The "trial patched" status condition
 // is used to control what actions need to be taken. If the status is "unknown" then the experiment is fetched
 // and the patch templates will be rendered into the list of patch operations on the trial; once the patches
 // are evaluated the status will be "false". If the status is "false" then patch operations will be applied
-// to the cluster; once all the patches are applied the status will be "true".
+// to the cluster; once all the patches are applied the status will be "true". If the "trial restored" status
+// is "false" when the trial finishes, the original state of the patched objects will be restored.
 func (r *PatchReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
 	now := metav1.Now()
 
 	t := &redskyv1alpha1.Trial{}
-	if err := r.Get(ctx, req.NamespacedName, t); err != nil || r.ignoreTrial(t) {
+	if err := r.Get(ctx, req.NamespacedName, t); err != nil {
 		return ctrl.Result{}, controller.IgnoreNotFound(err)
 	}
 
+	if result, err := r.restorePatches(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
+	if r.ignoreTrial(t) {
+		return ctrl.Result{}, nil
+	}
+
 	if result, err := r.evaluatePatchOperations(ctx, t, &now); result != nil {
 		return *result, err
 	}
@@ -74,6 +94,7 @@ func (r *PatchReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 // SetupWithManager registers a new patch reconciler with the supplied manager
 func (r *PatchReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("patch").
 		For(&redskyv1alpha1.Trial{}).
@@ -130,42 +151,84 @@ func (r *PatchReconciler) evaluatePatchOperations(ctx context.Context, t *redsky
 		return &ctrl.Result{}, err
 	}
 
+	// Fetch the current state of the patch targets, expanding the selectors (if present)
+	targets := make([][]*unstructured.Unstructured, len(exp.Spec.Patches))
+	for i := range exp.Spec.Patches {
+		p := &exp.Spec.Patches[i]
+		pt, err := r.patchTargets(ctx, t, p)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+		if len(pt) == 0 {
+			// Waiting will not help if the selector does not match anything, fail the trial
+			msg := fmt.Sprintf("no %s matched the patch selector %q", strings.ToLower(p.TargetRef.Kind), metav1.FormatLabelSelector(p.Selector))
+			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, "PatchTargetNotFound", msg, probeTime)
+			err := r.Update(ctx, t)
+			return controller.RequeueConflict(err)
+		}
+		targets[i] = pt
+	}
+
 	// Readiness checks from patches should always be applied first
 	readinessChecks := t.Spec.ReadinessChecks
 	t.Spec.ReadinessChecks = nil
 
 	// Evaluate the patches
-	te := template.New()
+	te, err := templateEngine(ctx, r.apiReader, exp)
+	if err != nil {
+		return &ctrl.Result{}, err
+	}
 	for i := range exp.Spec.Patches {
 		p := &exp.Spec.Patches[i]
+		for _, target := range targets[i] {
+			// Render the patch template
+			ref, data, err := patch.RenderTemplate(te, exp, t, p, target)
+			if err != nil {
+				return &ctrl.Result{}, err
+			}
 
-		// Render the patch template
-		ref, data, err := r.renderTemplate(te, t, p)
-		if err != nil {
-			return &ctrl.Result{}, err
-		}
-
-		// Add a patch operation if necessary
-		if po, err := r.createPatchOperation(t, p, ref, data); err != nil {
-			return &ctrl.Result{}, err
-		} else if po != nil {
-			t.Spec.PatchOperations = append(t.Spec.PatchOperations, *po)
-		}
+			// Add a patch operation if necessary
+			if po, err := patch.CreatePatchOperation(t, p, ref, data); err != nil {
+				return &ctrl.Result{}, err
+			} else if po != nil {
+				t.Spec.PatchOperations = append(t.Spec.PatchOperations, *po)
+			}
 
-		// Add a readiness check if necessary
-		if rc, err := r.createReadinessCheck(p, ref); err != nil {
-			return &ctrl.Result{}, err
-		} else if rc != nil {
-			t.Spec.ReadinessChecks = append(t.Spec.ReadinessChecks, *rc)
+			// Add a readiness check if necessary
+			if rc, err := r.createReadinessCheck(p, ref); err != nil {
+				return &ctrl.Result{}, err
+			} else if rc != nil {
+				t.Spec.ReadinessChecks = append(t.Spec.ReadinessChecks, *rc)
+			}
 		}
 	}
 
 	// Add back any pre-existing readiness checks
 	t.Spec.ReadinessChecks = append(t.Spec.ReadinessChecks, readinessChecks...)
 
+	// Warn about patches whose result depends on the order they are applied in
+	overlaps, err := patch.FindOverlaps(t.Spec.PatchOperations)
+	if err != nil {
+		return &ctrl.Result{}, err
+	}
+	for _, o := range overlaps {
+		ref := &t.Spec.PatchOperations[o.First].TargetRef
+		t.Status.Warnings = append(t.Status.Warnings, fmt.Sprintf("Patches to %s %q overwrite each other: %s", strings.ToLower(ref.Kind), ref.Name, o.String()))
+	}
+
+	// Record the need to capture the original state of the patched objects
+	switch exp.Spec.Restore {
+	case redskyv1alpha1.RestoreAlways:
+		// Make sure the trial is not deleted before the patched objects are restored
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionFalse, "", "", probeTime)
+		meta.AddFinalizer(t, patch.RestoreFinalizer)
+	case redskyv1alpha1.RestoreOnFinish:
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionUnknown, "", "", probeTime)
+	}
+
 	// Update the status to indicate that patches are evaluated
 	trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialPatched, corev1.ConditionFalse, "", "", probeTime)
-	err := r.Update(ctx, t)
+	err = r.Update(ctx, t)
 	return controller.RequeueConflict(err)
 }
 
@@ -189,7 +252,20 @@ func (r *PatchReconciler) applyPatches(ctx context.Context, t *redskyv1alpha1.Tr
 		u.SetName(p.TargetRef.Name)
 		u.SetNamespace(p.TargetRef.Namespace)
 		u.SetGroupVersionKind(p.TargetRef.GroupVersionKind())
-		if err := r.Patch(ctx, u, client.RawPatch(p.PatchType, p.Data)); err != nil {
+		err := r.snapshot(ctx, t, p, u)
+		if apierrs.IsConflict(err) || apierrs.IsAlreadyExists(err) {
+			// Another trial recorded the original state at the same time, try again with the latest copy
+			return &ctrl.Result{Requeue: true}, nil
+		}
+		if err == nil {
+			err = r.Patch(ctx, u, client.RawPatch(p.PatchType, p.Data), client.FieldOwner(patch.FieldManager))
+		}
+		if p.PatchType == types.ApplyPatchType && apierrs.IsConflict(err) {
+			// Field ownership conflicts are not going to resolve themselves, fail the trial immediately
+			p.AttemptsRemaining = 0
+			msg := fmt.Sprintf("Server-side apply of %s %q conflicts with another field manager: %v", strings.ToLower(p.TargetRef.Kind), p.TargetRef.Name, err)
+			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, "PatchConflict", msg, probeTime)
+		} else if err != nil {
 			p.AttemptsRemaining = p.AttemptsRemaining - 1
 			if p.AttemptsRemaining == 0 {
 				// There are no remaining patch attempts remaining, fail the trial
@@ -200,6 +276,15 @@ func (r *PatchReconciler) applyPatches(ctx context.Context, t *redskyv1alpha1.Tr
 		}
 
 		// Update the patch operation status
+		err = r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
+
+	// Make sure the patched values were not changed (e.g. by an admission controller) before checking for readiness
+	if msg, err := r.verifyPatches(ctx, t); err != nil {
+		return &ctrl.Result{}, err
+	} else if msg != "" {
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, "PatchOverridden", msg, probeTime)
 		err := r.Update(ctx, t)
 		return controller.RequeueConflict(err)
 	}
@@ -210,78 +295,221 @@ func (r *PatchReconciler) applyPatches(ctx context.Context, t *redskyv1alpha1.Tr
 	return controller.RequeueConflict(err)
 }
 
-// renderTemplate determines the patch target and renders the patch template
-func (r *PatchReconciler) renderTemplate(te *template.Engine, t *redskyv1alpha1.Trial, p *redskyv1alpha1.PatchTemplate) (*corev1.ObjectReference, []byte, error) {
-	// Render the actual patch data
-	data, err := te.RenderPatch(p, t)
+// verifyPatches re-reads the patched objects and returns a message describing any fields which no longer hold the
+// patched values, an empty message indicates all of the patches took effect
+func (r *PatchReconciler) verifyPatches(ctx context.Context, t *redskyv1alpha1.Trial) (string, error) {
+	var msgs []string
+	for i := range t.Spec.PatchOperations {
+		p := &t.Spec.PatchOperations[i]
+
+		// Patches to the trial job are applied when the job is created
+		if trial.IsTrialJobReference(t, &p.TargetRef) {
+			continue
+		}
+
+		// Use the API reader to avoid comparing against a stale copy of the object
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(p.TargetRef.GroupVersionKind())
+		if err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: p.TargetRef.Namespace, Name: p.TargetRef.Name}, u); err != nil {
+			return "", err
+		}
+		data, err := u.MarshalJSON()
+		if err != nil {
+			return "", err
+		}
+
+		// The Go type (only available for built-in kinds) describes how the lists of the object are merged
+		var dataStruct interface{}
+		if obj, err := r.Scheme.New(p.TargetRef.GroupVersionKind()); err == nil {
+			dataStruct = obj
+		}
+		changed, err := patch.Changed(t.Spec.PatchOperations, i, data, dataStruct)
+		if err != nil {
+			return "", err
+		}
+		if len(changed) > 0 {
+			msgs = append(msgs, fmt.Sprintf("Patched values of %s %q were changed: %s", strings.ToLower(p.TargetRef.Kind), p.TargetRef.Name, strings.Join(changed, ", ")))
+		}
+	}
+	return strings.Join(msgs, "; "), nil
+}
+
+// patchTargets returns the current state of all of the objects matching the patch template selector, or of the object
+// referenced by the patch template; a nil target is returned if the patch template does not explicitly reference an
+// existing object and no targets are returned if the selector does not match any objects
+func (r *PatchReconciler) patchTargets(ctx context.Context, t *redskyv1alpha1.Trial, p *redskyv1alpha1.PatchTemplate) ([]*unstructured.Unstructured, error) {
+	if p.TargetRef == nil {
+		return []*unstructured.Unstructured{nil}, nil
+	}
+
+	namespace := p.TargetRef.Namespace
+	if namespace == "" {
+		namespace = t.Namespace
+	}
+
+	// RBAC: Like "patch", we assume that we have "get" and "list" permission from a customer defined role
+	if p.Selector == nil {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(p.TargetRef.GroupVersionKind())
+		if err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: p.TargetRef.Name}, u); err != nil {
+			// Missing objects will be reported when the patch is applied
+			return []*unstructured.Unstructured{nil}, controller.IgnoreNotFound(err)
+		}
+		return []*unstructured.Unstructured{u}, nil
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(p.Selector)
 	if err != nil {
-		return nil, nil, err
-	}
-
-	// Determine the reference, possibly extracting it from the rendered data
-	ref := &corev1.ObjectReference{}
-	if p.TargetRef != nil {
-		p.TargetRef.DeepCopyInto(ref)
-	} else if p.Type == redskyv1alpha1.PatchStrategic || p.Type == "" {
-		m := &struct {
-			metav1.TypeMeta   `json:",inline"`
-			metav1.ObjectMeta `json:"metadata,omitempty"`
-		}{}
-		if err := json.Unmarshal(data, m); err == nil {
-			ref.APIVersion = m.APIVersion
-			ref.Kind = m.Kind
-			ref.Name = m.Name
-			ref.Namespace = m.Namespace
+		return nil, err
+	}
+
+	ul := &unstructured.UnstructuredList{}
+	ul.SetGroupVersionKind(p.TargetRef.GroupVersionKind())
+	if err := r.apiReader.List(ctx, ul, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: s}); err != nil {
+		return nil, err
+	}
+	targets := make([]*unstructured.Unstructured, 0, len(ul.Items))
+	for i := range ul.Items {
+		targets = append(targets, &ul.Items[i])
+	}
+	return targets, nil
+}
+
+// templateEngine returns a template engine for rendering the templates of an experiment, config maps (used for lookups
+// and named template definitions) are read using the supplied reader
+func templateEngine(ctx context.Context, r client.Reader, exp *redskyv1alpha1.Experiment) (*template.Engine, error) {
+	te := template.New()
+	te.ConfigMaps = func(namespace, name string) (map[string]string, error) {
+		cm := &corev1.ConfigMap{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, cm); err != nil {
+			return nil, err
 		}
+		return cm.Data, nil
 	}
+	if err := te.DefineExperimentTemplates(exp); err != nil {
+		return nil, err
+	}
+	return te, nil
+}
 
-	// Default the namespace to the trial namespace
-	if ref.Namespace == "" {
-		ref.Namespace = t.Namespace
+// snapshot records the state of the patch target prior to applying the patch, if necessary
+func (r *PatchReconciler) snapshot(ctx context.Context, t *redskyv1alpha1.Trial, p *redskyv1alpha1.PatchOperation, u *unstructured.Unstructured) error {
+	// Only capture the original state once, and only if it is going to be restored
+	if p.RestoreData != nil || !(trial.CheckCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionFalse) ||
+		trial.CheckCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionUnknown)) {
+		return nil
 	}
 
-	// Validate the reference
-	if ref.Name == "" || ref.Kind == "" {
-		return nil, nil, fmt.Errorf("invalid patch reference")
+	// RBAC: Restoring patched objects also requires "get" permission on the target
+	original := u.DeepCopy()
+	if err := r.Get(ctx, client.ObjectKey{Namespace: u.GetNamespace(), Name: u.GetName()}, original); err != nil {
+		return err
+	}
+	data, err := original.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	p.RestoreData, err = patch.RestoreData(data, p)
+	if err != nil {
+		return err
 	}
 
-	return ref, data, nil
+	// Trials are deleted before the experiment is finished, keep the original state with the experiment instead
+	if trial.CheckCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionUnknown) {
+		return r.recordOriginal(ctx, t, p)
+	}
+	return nil
 }
 
-// createPatchOperation creates a new patch operation from a patch template and it's (fully rendered) patch data
-func (r *PatchReconciler) createPatchOperation(t *redskyv1alpha1.Trial, p *redskyv1alpha1.PatchTemplate, ref *corev1.ObjectReference, data []byte) (*redskyv1alpha1.PatchOperation, error) {
-	po := &redskyv1alpha1.PatchOperation{
-		TargetRef:         *ref,
-		Data:              data,
-		AttemptsRemaining: 3,
+// recordOriginal adds the restore data of a patch operation to the experiment's restore config map, only the first
+// recorded state of each field is kept so the objects are restored to their state before the experiment started
+func (r *PatchReconciler) recordOriginal(ctx context.Context, t *redskyv1alpha1.Trial, p *redskyv1alpha1.PatchOperation) error {
+	exp := &redskyv1alpha1.Experiment{}
+	if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); err != nil {
+		return err
 	}
 
-	// If the patch is effectively null, we do not need to evaluate it
-	if len(po.Data) == 0 || string(po.Data) == "null" {
-		return nil, nil
+	cm := &corev1.ConfigMap{}
+	cm.Name = patch.RestoreConfigMapName(exp.Name)
+	cm.Namespace = exp.Namespace
+	if err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: cm.Namespace, Name: cm.Name}, cm); controller.IgnoreNotFound(err) != nil {
+		return err
 	}
 
-	// Determine the patch type
-	switch p.Type {
-	case redskyv1alpha1.PatchStrategic, "":
-		po.PatchType = types.StrategicMergePatchType
-	case redskyv1alpha1.PatchMerge:
-		po.PatchType = types.MergePatchType
-	case redskyv1alpha1.PatchJSON:
-		po.PatchType = types.JSONPatchType
-	default:
-		return nil, fmt.Errorf("unknown patch type: %s", p.Type)
+	key := patch.RestoreKey(&p.TargetRef)
+	po := redskyv1alpha1.PatchOperation{TargetRef: p.TargetRef, PatchType: patch.RestorePatchType(&p.TargetRef), RestoreData: p.RestoreData}
+	if value, ok := cm.Data[key]; ok {
+		existing := redskyv1alpha1.PatchOperation{}
+		if err := json.Unmarshal([]byte(value), &existing); err != nil {
+			return err
+		}
+		data, err := patch.MergeRestoreData(existing.RestoreData, p.RestoreData, &p.TargetRef)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(data, existing.RestoreData) {
+			return nil
+		}
+		po.RestoreData = data
+	}
+
+	value, err := json.Marshal(&po)
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
 	}
+	cm.Data[key] = string(value)
 
-	// If the patch is for the trial job itself, it cannot be applied (since the job won't exist until well after patches are applied)
-	if trial.IsTrialJobReference(t, &po.TargetRef) {
-		po.AttemptsRemaining = 0
-		if po.PatchType != types.StrategicMergePatchType {
-			return nil, fmt.Errorf("trial job patch must be a strategic merge patch")
+	if cm.CreationTimestamp.IsZero() {
+		if err := controllerutil.SetControllerReference(exp, cm, r.Scheme); err != nil {
+			return err
 		}
+		return r.Create(ctx, cm)
+	}
+	return r.Update(ctx, cm)
+}
+
+// restorePatches will put the patched objects back to their original state once the trial is finished
+func (r *PatchReconciler) restorePatches(ctx context.Context, t *redskyv1alpha1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Only restore patches if the "restored" status is "false"
+	if !trial.CheckCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionFalse) {
+		return nil, nil
+	}
+
+	// Wait for the trial to finish (or be deleted)
+	if !trial.IsFinished(t) && t.DeletionTimestamp.IsZero() {
+		return nil, nil
 	}
 
-	return po, nil
+	if err := restorePatchOperations(ctx, r, t.Spec.PatchOperations); err != nil {
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionTrue, "RestoreFailed", err.Error(), probeTime)
+	} else {
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialRestored, corev1.ConditionTrue, "", "", probeTime)
+	}
+	meta.RemoveFinalizer(t, patch.RestoreFinalizer)
+	err := r.Update(ctx, t)
+	return controller.RequeueConflict(err)
+}
+
+// restorePatchOperations applies the restore data of the supplied patch operations in reverse order
+func restorePatchOperations(ctx context.Context, w client.Writer, pos []redskyv1alpha1.PatchOperation) error {
+	for i := len(pos) - 1; i >= 0; i-- {
+		p := &pos[i]
+		if p.RestoreData == nil {
+			continue
+		}
+
+		u := &unstructured.Unstructured{}
+		u.SetName(p.TargetRef.Name)
+		u.SetNamespace(p.TargetRef.Namespace)
+		u.SetGroupVersionKind(p.TargetRef.GroupVersionKind())
+		if err := w.Patch(ctx, u, client.RawPatch(patch.RestorePatchType(&p.TargetRef), p.RestoreData)); controller.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // createReadinessCheck creates a readiness check for a patch operation
@@ -23,7 +23,9 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/redskyops/redskyops-controller/internal/controller"
+	"github.com/redskyops/redskyops-controller/internal/metric"
 	"github.com/redskyops/redskyops-controller/internal/ready"
+	"github.com/redskyops/redskyops-controller/internal/template"
 	"github.com/redskyops/redskyops-controller/internal/trial"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
@@ -48,8 +50,13 @@ type ReadyReconciler struct {
 	apiReader client.Reader
 }
 
+// +kubebuilder:rbac:groups=redskyops.dev,resources=experiments,verbs=get;list;watch
 // +kubebuilder:rbac:groups=redskyops.dev,resources=trials,verbs=get;list;watch;update
-// +kubebuilder:rbac:groups="",resources=pods,verbs=list
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+// +kubebuilder:rbac:groups="",resources=events,verbs=list;watch
+// +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=list;watch
+// +kubebuilder:rbac:groups=apps;extensions,resources=replicasets,verbs=get
+// +kubebuilder:rbac:groups="",resources=services,verbs=list
 
 // Reconcile inspects a trial to see if the patched objects are ready for the trial job to start
 func (r *ReadyReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
@@ -65,6 +72,10 @@ func (r *ReadyReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return *result, err
 	}
 
+	if result, err := r.checkEvents(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
 	if result, err := r.checkReadiness(ctx, t, &now); result != nil {
 		return *result, err
 	}
@@ -126,11 +137,14 @@ func (r *ReadyReconciler) evaluateReadinessChecks(ctx context.Context, t *redsky
 				Name:       c.Name,
 				APIVersion: c.APIVersion,
 			},
-			Selector:            c.Selector,
-			ConditionTypes:      c.ConditionTypes,
-			InitialDelaySeconds: c.InitialDelaySeconds,
-			PeriodSeconds:       c.PeriodSeconds,
-			AttemptsRemaining:   c.FailureThreshold,
+			Selector:             c.Selector,
+			ConditionTypes:       c.ConditionTypes,
+			InitialDelaySeconds:  c.InitialDelaySeconds,
+			PeriodSeconds:        c.PeriodSeconds,
+			AttemptsRemaining:    c.FailureThreshold,
+			HTTPGet:              c.HTTPGet,
+			Metric:               c.Metric,
+			StabilizationSeconds: c.StabilizationSeconds,
 		}
 
 		// Adjust for defaults/minimums
@@ -154,6 +168,50 @@ func (r *ReadyReconciler) evaluateReadinessChecks(ctx context.Context, t *redsky
 	return controller.RequeueConflict(err)
 }
 
+// checkEvents will fail the trial if there are any fatal events for the targets of the incomplete readiness checks
+func (r *ReadyReconciler) checkEvents(ctx context.Context, t *redskyv1alpha1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Only check events if the "ready" status is "false"
+	if !trial.CheckCondition(&t.Status, redskyv1alpha1.TrialReady, corev1.ConditionFalse) {
+		return nil, nil
+	}
+
+	checker := newReadinessChecker(r.Client, r.apiReader, t)
+	for i := range t.Spec.ReadinessChecks {
+		c := &t.Spec.ReadinessChecks[i]
+		if c.AttemptsRemaining <= 0 {
+			continue
+		}
+
+		ul, err := r.getCheckTargets(ctx, c)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		for j := range ul.Items {
+			if err := checker.checker.CheckEvents(ctx, &ul.Items[j], checker.epoch.Time); err != nil {
+				reason, msg := failureReason(err, "ReadinessCheckFailed", err.Error())
+				trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, reason, msg, probeTime)
+				err := r.Update(ctx, t)
+				return controller.RequeueConflict(err)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// failureReason returns the reason and message describing a failure, using the supplied defaults unless the error
+// is a specific readiness failure
+func failureReason(err error, reason, message string) (string, string) {
+	if ferr, ok := err.(*ready.FailureError); ok {
+		if ferr.Message != "" {
+			return ferr.Reason, ferr.Message
+		}
+		return ferr.Reason, message
+	}
+	return reason, message
+}
+
 // checkReadiness will evaluate the readiness checks for the trial
 func (r *ReadyReconciler) checkReadiness(ctx context.Context, t *redskyv1alpha1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
 	// Only check readiness checks if the "ready" status is "false"
@@ -162,7 +220,7 @@ func (r *ReadyReconciler) checkReadiness(ctx context.Context, t *redskyv1alpha1.
 	}
 
 	// Create a new "checker" to maintain state while looping over the readiness checks
-	checker := newReadinessChecker(r.Client, t)
+	checker := newReadinessChecker(r.Client, r.apiReader, t)
 	for i := range t.Spec.ReadinessChecks {
 		c := &t.Spec.ReadinessChecks[i]
 		if checker.skipCheck(c, probeTime) {
@@ -179,7 +237,8 @@ func (r *ReadyReconciler) checkReadiness(ctx context.Context, t *redskyv1alpha1.
 
 		// Check for readiness
 		if msg, ok, err := checker.check(ctx, c, ul, probeTime); err != nil {
-			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, "ReadinessCheckFailed", err.Error(), probeTime)
+			reason, msg := failureReason(err, "ReadinessCheckFailed", err.Error())
+			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, reason, msg, probeTime)
 			err := r.Update(ctx, t)
 			return controller.RequeueConflict(err)
 		} else if !ok {
@@ -239,6 +298,12 @@ func (r *ReadyReconciler) getCheckTargets(ctx context.Context, rc *redskyv1alpha
 type readinessChecker struct {
 	// checker is used to evaluate the conditions of a target
 	checker ready.ReadinessChecker
+	// trial is the trial being checked, it is used to collect stabilization metrics
+	trial *redskyv1alpha1.Trial
+	// apiReader is used to read the config maps referenced by the stabilization metric queries
+	apiReader client.Reader
+	// te is used to render the stabilization metric queries, it is created on demand
+	te *template.Engine
 	// epoch is the time at which readiness checks can be evaluated (i.e. when the trial transitioned into a "patched" status)
 	epoch metav1.Time
 	// ready is a flag indicating that all of readiness checks have been evaluated
@@ -250,15 +315,15 @@ type readinessChecker struct {
 }
 
 // newReadinessChecker returns a new checker for the supplied trial
-func newReadinessChecker(reader client.Reader, t *redskyv1alpha1.Trial) *readinessChecker {
-	checker := ready.ReadinessChecker{Reader: reader}
+func newReadinessChecker(reader, apiReader client.Reader, t *redskyv1alpha1.Trial) *readinessChecker {
+	checker := ready.ReadinessChecker{Reader: reader, EventReader: apiReader}
 	epoch := t.GetCreationTimestamp()
 	for i := range t.Status.Conditions {
 		if t.Status.Conditions[i].Type == redskyv1alpha1.TrialPatched {
 			epoch = t.Status.Conditions[i].LastTransitionTime
 		}
 	}
-	return &readinessChecker{checker: checker, epoch: epoch, ready: true, requeue: true}
+	return &readinessChecker{checker: checker, apiReader: apiReader, trial: t, epoch: epoch, ready: true, requeue: true}
 }
 
 // skipCheck determines if a check should be evaluated, recording the results internally
@@ -288,8 +353,10 @@ func (rc *readinessChecker) check(ctx context.Context, c *redskyv1alpha1.Readine
 	var msg string // TODO Default the message to "target not found" or something to indicate the check isn't ready because there are no objects
 	var ok bool
 	var err error
+	checker := rc.checker
+	checker.HTTPGet = c.HTTPGet
 	for i := range ul.Items {
-		msg, ok, err = rc.checker.CheckConditions(ctx, &ul.Items[i], c.ConditionTypes)
+		msg, ok, err = checker.CheckConditions(ctx, &ul.Items[i], c.ConditionTypes)
 		if !ok || err != nil {
 			break
 		}
@@ -300,6 +367,29 @@ func (rc *readinessChecker) check(ctx context.Context, c *redskyv1alpha1.Readine
 		ok = true
 	}
 
+	// Stabilization metrics are only collected once the conditions are satisfied
+	if ok && err == nil && c.Metric != nil {
+		var stable bool
+		if msg, stable, err = rc.checkMetric(ctx, c, now); err != nil {
+			return "", false, err
+		} else if !stable {
+			return msg, false, nil
+		}
+	} else if c.Metric != nil {
+		c.StableTime = nil
+	}
+
+	// Some checks require multiple consecutive successes, these do not count against the failure threshold
+	if ok && err == nil && c.HTTPGet != nil && c.HTTPGet.SuccessThreshold > 1 {
+		c.ConsecutiveSuccesses++
+		if c.ConsecutiveSuccesses < c.HTTPGet.SuccessThreshold {
+			c.LastCheckTime = now
+			return fmt.Sprintf("Waiting for %d consecutive successful checks (%d so far)", c.HTTPGet.SuccessThreshold, c.ConsecutiveSuccesses), false, nil
+		}
+	} else {
+		c.ConsecutiveSuccesses = 0
+	}
+
 	// Check is done, it is either ok or had a hard failure
 	if ok || err != nil {
 		c.AttemptsRemaining = 0
@@ -318,6 +408,62 @@ func (rc *readinessChecker) check(ctx context.Context, c *redskyv1alpha1.Readine
 	return msg, false, nil
 }
 
+// checkMetric collects the stabilization metric for a readiness check, returning true only if the check is complete;
+// a zero metric value counts as a failed attempt
+func (rc *readinessChecker) checkMetric(ctx context.Context, c *redskyv1alpha1.ReadinessCheck, now *metav1.Time) (string, bool, error) {
+	// Metric collection is anchored to the previous attempt to ensure there was an opportunity for collection
+	t := rc.trial.DeepCopy()
+	t.Status.StartTime = rc.epoch.DeepCopy()
+	t.Status.CompletionTime = c.LastCheckTime
+	if t.Status.CompletionTime == nil {
+		t.Status.CompletionTime = rc.epoch.DeepCopy()
+	}
+
+	// Capture the current value of the metric
+	var value float64
+	te, err := rc.templateEngine(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	target, err := metricTarget(ctx, rc.checker.Reader, rc.trial.Namespace, c.Metric)
+	if err == nil {
+		value, _, err = metric.CaptureMetric(te, c.Metric, t, target)
+	}
+	if merr, ok := err.(*metric.CaptureError); ok && merr.RetryAfter > 0 {
+		// Do not count retries against the remaining attempts
+		c.LastCheckTime = now
+		return fmt.Sprintf("Waiting for metric %q to be collected", c.Metric.Name), false, nil
+	}
+
+	// An unavailable or zero valued metric is not stable
+	var msg string
+	if err != nil {
+		msg = fmt.Sprintf("Failed to collect metric %q: %v", c.Metric.Name, err)
+	} else if value == 0 {
+		msg = fmt.Sprintf("Waiting for metric %q to be non-zero", c.Metric.Name)
+	}
+	if msg != "" {
+		c.StableTime = nil
+		c.AttemptsRemaining--
+		if c.AttemptsRemaining <= 0 {
+			return "", false, fmt.Errorf("%s", msg)
+		}
+		c.LastCheckTime = now
+		return msg, false, nil
+	}
+
+	// Wait for the stabilization window to elapse
+	if c.StableTime == nil {
+		c.StableTime = now
+	}
+	window := time.Duration(c.StabilizationSeconds) * time.Second
+	if elapsed := now.Sub(c.StableTime.Time); elapsed < window {
+		c.LastCheckTime = now
+		return fmt.Sprintf("Waiting for metric %q to be stable (%s remaining)", c.Metric.Name, (window - elapsed).Round(time.Second)), false, nil
+	}
+	return "", true, nil
+}
+
 // nextCheckTime returns the approximate time that an attempt should be made to evaluate a check
 func (rc *readinessChecker) nextCheckTime(c *redskyv1alpha1.ReadinessCheck) *metav1.Time {
 	if c.LastCheckTime != nil {
@@ -330,3 +476,23 @@ func (rc *readinessChecker) nextCheckTime(c *redskyv1alpha1.ReadinessCheck) *met
 
 	return &metav1.Time{Time: rc.epoch.Add(time.Duration(c.InitialDelaySeconds) * time.Second)}
 }
+
+// templateEngine returns the template engine used to render stabilization metric queries, the named templates of the
+// experiment are only available if the experiment exists
+func (rc *readinessChecker) templateEngine(ctx context.Context) (*template.Engine, error) {
+	if rc.te != nil {
+		return rc.te, nil
+	}
+
+	exp := &redskyv1alpha1.Experiment{}
+	if err := rc.checker.Reader.Get(ctx, rc.trial.ExperimentNamespacedName(), exp); controller.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+
+	te, err := templateEngine(ctx, rc.apiReader, exp)
+	if err != nil {
+		return nil, err
+	}
+	rc.te = te
+	return te, nil
+}
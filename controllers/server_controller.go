@@ -32,10 +32,12 @@ import (
 	redskyapi "github.com/redskyops/redskyops-controller/redskyapi/experiments/v1alpha1"
 	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 )
 
@@ -92,7 +94,12 @@ func (r *ServerReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		// Trials that have the server finalizer may need to be reported
 		if meta.HasFinalizer(t, server.Finalizer) {
 			// TODO Combine report and abandon into one function
-			if trial.IsFinished(t) {
+			if trial.IsFinished(t) && trial.ShouldRetry(t) && exp.DeletionTimestamp.IsZero() {
+				if result, err := r.retryTrial(ctx, tlog, exp, t, trialList); result != nil {
+					return *result, err
+				}
+				trialHasFinalizer = true
+			} else if trial.IsFinished(t) {
 				if result, err := r.reportTrial(ctx, tlog, t); result != nil {
 					return *result, err
 				}
@@ -261,8 +268,12 @@ func (r *ServerReconciler) nextTrial(ctx context.Context, log logr.Logger, exp *
 	t.Namespace = namespace
 	server.ToClusterTrial(t, &suggestion)
 
-	// Create the trial
-	if err := r.Create(ctx, t); err != nil {
+	// Compute the derived assignments and create the trial
+	err = experiment.PopulateDerivedAssignments(exp, t)
+	if err == nil {
+		err = r.Create(ctx, t)
+	}
+	if err != nil {
 		// If creation fails, abandon the suggestion (ignoring those errors)
 		if url := t.GetAnnotations()[redskyv1alpha1.AnnotationReportTrialURL]; url != "" {
 			_ = r.RedSkyAPI.AbandonRunningTrial(ctx, url)
@@ -274,6 +285,44 @@ func (r *ServerReconciler) nextTrial(ctx context.Context, log logr.Logger, exp *
 	return nil, nil
 }
 
+// retryTrial will re-run the assignments of a trial which failed for infrastructure reasons in a new trial, the failed
+// trial is not reported and the new trial will report using the same URL; the new trial is not created until the
+// failed trial is torn down
+func (r *ServerReconciler) retryTrial(ctx context.Context, log logr.Logger, exp *redskyv1alpha1.Experiment, t *redskyv1alpha1.Trial, trialList *redskyv1alpha1.TrialList) (*ctrl.Result, error) {
+	// Wait for setup delete tasks and the restoration of patched objects
+	if trial.IsActive(t) {
+		return nil, nil
+	}
+
+	// Determine the namespace (if any) to use for the trial
+	namespace, err := experiment.NextTrialNamespace(ctx, r, exp, trialList)
+	if err != nil {
+		return &ctrl.Result{}, err
+	}
+	if namespace == "" {
+		return nil, nil
+	}
+
+	// Generate a new trial from the template on the experiment using the assignments of the failed trial
+	retry := &redskyv1alpha1.Trial{}
+	experiment.PopulateTrialFromTemplate(exp, retry)
+	retry.Namespace = namespace
+	trial.PopulateRetry(t, retry)
+	controllerutil.AddFinalizer(retry, server.Finalizer)
+	if err := r.Create(ctx, retry); err != nil && !apierrs.IsAlreadyExists(err) {
+		return &ctrl.Result{}, err
+	}
+
+	// The failed trial no longer needs to be reported
+	meta.RemoveFinalizer(t, server.Finalizer)
+	if err := r.Update(ctx, t); err != nil {
+		return controller.RequeueConflict(err)
+	}
+
+	log.Info("Retrying trial", "retryTrial", retry.Namespace+"/"+retry.Name, "retries", trial.Retries(retry))
+	return &ctrl.Result{}, nil
+}
+
 // reportTrial will report the values from a finished in cluster trial back to the server
 func (r *ServerReconciler) reportTrial(ctx context.Context, log logr.Logger, t *redskyv1alpha1.Trial) (*ctrl.Result, error) {
 	if !meta.RemoveFinalizer(t, server.Finalizer) {
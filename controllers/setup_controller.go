@@ -19,11 +19,13 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/redskyops/redskyops-controller/internal/controller"
 	"github.com/redskyops/redskyops-controller/internal/meta"
+	"github.com/redskyops/redskyops-controller/internal/ready"
 	"github.com/redskyops/redskyops-controller/internal/setup"
 	"github.com/redskyops/redskyops-controller/internal/trial"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
@@ -37,16 +39,24 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
+// setupPollInterval is the amount of time between checks of the shared setup job
+const setupPollInterval = 5 * time.Second
+
 // SetupReconciler reconciles a Trial object for setup tasks
 type SetupReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Keep the raw API reader for reading the config maps used by the Helm values and manifests
+	apiReader client.Reader
 }
 
+// +kubebuilder:rbac:groups=redskyops.dev,resources=experiments,verbs=get;list;watch
 // +kubebuilder:rbac:groups=redskyops.dev,resources=trials,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups="",resources=pods,verbs=list
-// +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=list;watch;create
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create
+// +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=get;list;watch;create;delete
 
 func (r *SetupReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -81,6 +91,7 @@ func (r *SetupReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 }
 
 func (r *SetupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
 	// TODO Have some type of setting to by-pass this
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("setup").
@@ -102,8 +113,13 @@ func (r *SetupReconciler) inspectSetupJobs(ctx context.Context, t *redskyv1alpha
 	if len(list.Items) == 0 {
 		if t.DeletionTimestamp.IsZero() {
 			// Normally if the trial hasn't been deleted and there are no jobs, the status will already be unknown
-			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialSetupCreated, corev1.ConditionUnknown, "", "", probeTime)
-			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialSetupDeleted, corev1.ConditionUnknown, "", "", probeTime)
+			// (trials with only experiment scoped setup tasks never have their own jobs)
+			if setup.HasTasks(t, redskyv1alpha1.SetupScopeTrial, setup.ModeCreate) {
+				trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialSetupCreated, corev1.ConditionUnknown, "", "", probeTime)
+			}
+			if setup.HasTasks(t, redskyv1alpha1.SetupScopeTrial, setup.ModeDelete) {
+				trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialSetupDeleted, corev1.ConditionUnknown, "", "", probeTime)
+			}
 		} else if trial.CheckCondition(&t.Status, redskyv1alpha1.TrialSetupDeleted, corev1.ConditionFalse) {
 			// We only need this for the delete job (the corresponding failure for the create job is handled when creating the jobs):
 			// If "setup deleted" condition is "false" then we must have started job, but if the list is empty someone
@@ -113,7 +129,14 @@ func (r *SetupReconciler) inspectSetupJobs(ctx context.Context, t *redskyv1alpha
 		}
 	}
 
+	// Inspect the create job before the delete job so the status of the setup tasks reflects the latest job
+	sort.SliceStable(list.Items, func(i, j int) bool {
+		create := t.Name + "-" + setup.ModeCreate
+		return list.Items[i].Name == create && list.Items[j].Name != create
+	})
+
 	// Update the conditions based on existing jobs
+	var dirty bool
 	for i := range list.Items {
 		job := &list.Items[i]
 
@@ -123,17 +146,26 @@ func (r *SetupReconciler) inspectSetupJobs(ctx context.Context, t *redskyv1alpha
 			return &ctrl.Result{}, err
 		}
 
+		// Record the status of the individual setup tasks
+		pods := r.listSetupJobPods(ctx, job)
+		taskStatus := setup.GetTaskStatus(job, pods)
+		dirty = setup.ApplyTaskStatus(&t.Status, taskStatus) || dirty
+
 		// Determine if the job is finished (i.e. completed or failed)
 		conditionStatus, failureMessage := setup.GetConditionStatus(job)
 		if conditionStatus == corev1.ConditionFalse {
-			conditionStatus, failureMessage = r.inspectSetupJobPods(ctx, job)
+			conditionStatus, failureMessage = inspectSetupTasks(taskStatus)
+		} else if failureMessage != "" {
+			if _, taskFailure := inspectSetupTasks(taskStatus); taskFailure != "" {
+				failureMessage = taskFailure
+			}
 		}
 		trial.ApplyCondition(&t.Status, conditionType, conditionStatus, "", "", probeTime)
 
 		// Only fail the trial itself if it isn't already finished; both to prevent overwriting an existing success
 		// or failure status and to avoid updating the probe time (which would get us stuck in a busy loop)
 		if failureMessage != "" && !trial.IsFinished(t) {
-			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, "SetupJobFailed", failureMessage, probeTime)
+			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, setupFailureReason(pods), failureMessage, probeTime)
 		}
 	}
 
@@ -142,46 +174,74 @@ func (r *SetupReconciler) inspectSetupJobs(ctx context.Context, t *redskyv1alpha
 	// TODO Can we use pointer equivalence on probeTime to help mitigate that problem?
 	for i := range t.Status.Conditions {
 		if t.Status.Conditions[i].LastTransitionTime.Equal(probeTime) {
-			err := r.Update(ctx, t)
-			return controller.RequeueConflict(err)
+			dirty = true
 		}
 	}
+	if dirty {
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
 	return nil, nil
 }
 
-// inspectSetupJobPods will do further inspection on a job's pods to determine its current state
-func (r *SetupReconciler) inspectSetupJobPods(ctx context.Context, j *batchv1.Job) (corev1.ConditionStatus, string) {
+// listSetupJobPods returns the pods of a setup job
+func (r *SetupReconciler) listSetupJobPods(ctx context.Context, j *batchv1.Job) []corev1.Pod {
 	list := &corev1.PodList{}
 	if matchingSelector, err := meta.MatchingSelector(j.Spec.Selector); err == nil {
 		_ = r.List(ctx, list, client.InNamespace(j.Namespace), matchingSelector)
 	}
+	return list.Items
+}
 
-	for i := range list.Items {
-		for _, cs := range list.Items[i].Status.ContainerStatuses {
-			if !cs.Ready && cs.State.Terminated != nil && cs.State.Terminated.ExitCode != 0 {
-				return corev1.ConditionTrue, fmt.Sprintf("Setup job has a failed container")
-			}
+// inspectSetupTasks will do further inspection on the status of a job's setup tasks to determine its current state
+func inspectSetupTasks(taskStatus []redskyv1alpha1.SetupTaskStatus) (corev1.ConditionStatus, string) {
+	for _, ts := range taskStatus {
+		if ts.Phase == redskyv1alpha1.SetupTaskFailed {
+			return corev1.ConditionTrue, fmt.Sprintf("Setup task '%s' failed: %s", ts.Name, ts.Message)
 		}
 	}
 
 	return corev1.ConditionFalse, ""
 }
 
+// setupFailureReason returns the reason for a failed setup job, failures attributed to the cluster (e.g. an evicted
+// setup pod) are reported using the reason of the pod so the assignments can be retried
+func setupFailureReason(pods []corev1.Pod) string {
+	for i := range pods {
+		if reason, _ := ready.PodFailure(&pods[i]); trial.IsInfrastructureFailureReason(reason) {
+			return reason
+		}
+	}
+	return "SetupJobFailed"
+}
+
 // createSetupJob determines if a setup job is necessary and creates it
 func (r *SetupReconciler) createSetupJob(ctx context.Context, t *redskyv1alpha1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
 	mode := ""
 
 	// If the created condition is unknown, we may need a create job
 	if trial.CheckCondition(&t.Status, redskyv1alpha1.TrialSetupCreated, corev1.ConditionUnknown) {
-		// Before we can create the job, we need an initializer/finalizer
-		if trial.AddInitializer(t, setup.Initializer) || meta.AddFinalizer(t, setup.Finalizer) {
+		// Before we can create the job, we need an initializer/finalizer (the finalizer is only needed for a delete job)
+		needsFinalizer := setup.HasTasks(t, redskyv1alpha1.SetupScopeTrial, setup.ModeDelete)
+		if trial.AddInitializer(t, setup.Initializer) || (needsFinalizer && meta.AddFinalizer(t, setup.Finalizer)) {
 			err := r.Update(ctx, t)
 			return controller.RequeueConflict(err)
 		}
 
 		// Do not create setup tasks if the trial is deleted
 		if t.DeletionTimestamp.IsZero() {
-			mode = setup.ModeCreate
+			// The experiment scoped setup tasks must finish before the trial scoped setup tasks start
+			if result, err := r.createExperimentSetupJob(ctx, t, probeTime); result != nil {
+				return result, err
+			}
+
+			if setup.HasTasks(t, redskyv1alpha1.SetupScopeTrial, setup.ModeCreate) {
+				mode = setup.ModeCreate
+			} else {
+				trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialSetupCreated, corev1.ConditionTrue, "", "", probeTime)
+				err := r.Update(ctx, t)
+				return controller.RequeueConflict(err)
+			}
 		}
 	}
 
@@ -195,7 +255,33 @@ func (r *SetupReconciler) createSetupJob(ctx context.Context, t *redskyv1alpha1.
 
 	// Create a setup job if necessary
 	if mode != "" {
-		job, err := setup.NewJob(t, mode)
+		// The experiment may already be gone if this is a delete job
+		exp := &redskyv1alpha1.Experiment{}
+		if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); controller.IgnoreNotFound(err) != nil {
+			return &ctrl.Result{}, err
+		}
+		te, err := templateEngine(ctx, r.apiReader, exp)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		// Render the manifests once, the delete job uses the same config maps as the create job
+		if mode == setup.ModeCreate {
+			cms, err := setup.NewManifests(t, te)
+			if err != nil {
+				return &ctrl.Result{}, err
+			}
+			for i := range cms {
+				if err := controllerutil.SetControllerReference(t, &cms[i], r.Scheme); err != nil {
+					return &ctrl.Result{}, err
+				}
+				if err := r.Create(ctx, &cms[i]); controller.IgnoreAlreadyExists(err) != nil {
+					return &ctrl.Result{}, err
+				}
+			}
+		}
+
+		job, err := setup.NewJob(t, mode, te)
 		if err != nil {
 			return &ctrl.Result{}, err
 		}
@@ -217,6 +303,85 @@ func (r *SetupReconciler) createSetupJob(ctx context.Context, t *redskyv1alpha1.
 	return nil, nil
 }
 
+// createExperimentSetupJob creates the experiment scoped setup tasks in the trial namespace if they do not already
+// exist, returning nil once they have finished
+func (r *SetupReconciler) createExperimentSetupJob(ctx context.Context, t *redskyv1alpha1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Do not wait for the shared setup if there isn't any (or if the trial already failed)
+	if !setup.HasTasks(t, redskyv1alpha1.SetupScopeExperiment, setup.ModeCreate) || trial.IsFinished(t) {
+		return nil, nil
+	}
+
+	// Look for an existing job in the trial namespace, it is shared by all of the trials
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: t.Namespace, Name: setup.ExperimentJobName(t.ExperimentNamespacedName().Name, setup.ModeCreate)}, job)
+	if apierrs.IsNotFound(err) {
+		exp := &redskyv1alpha1.Experiment{}
+		if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); err != nil {
+			return &ctrl.Result{}, err
+		}
+		te, err := templateEngine(ctx, r.apiReader, exp)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		cms, err := setup.NewExperimentManifests(t, te)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+		job, err := setup.NewExperimentJob(t, setup.ModeCreate, te)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		// The objects can only be owned by the experiment if they are in the same namespace
+		for i := range cms {
+			if exp.Namespace == cms[i].Namespace {
+				if err := controllerutil.SetControllerReference(exp, &cms[i], r.Scheme); err != nil {
+					return &ctrl.Result{}, err
+				}
+			}
+			if err := r.Create(ctx, &cms[i]); controller.IgnoreAlreadyExists(err) != nil {
+				return &ctrl.Result{}, err
+			}
+		}
+		if exp.Namespace == job.Namespace {
+			if err := controllerutil.SetControllerReference(exp, job, r.Scheme); err != nil {
+				return &ctrl.Result{}, err
+			}
+		}
+		err = r.Create(ctx, job)
+		return &ctrl.Result{RequeueAfter: setupPollInterval}, controller.IgnoreAlreadyExists(err)
+	} else if err != nil {
+		return &ctrl.Result{}, err
+	}
+
+	// Record the status of the individual setup tasks
+	pods := r.listSetupJobPods(ctx, job)
+	taskStatus := setup.GetTaskStatus(job, pods)
+	dirty := setup.ApplyTaskStatus(&t.Status, taskStatus)
+
+	// Check the state of the job, failures are not retried by this trial but the next trial will try again
+	conditionStatus, failureMessage := setup.GetConditionStatus(job)
+	if _, taskFailure := inspectSetupTasks(taskStatus); taskFailure != "" {
+		conditionStatus, failureMessage = corev1.ConditionTrue, taskFailure
+	}
+	switch {
+	case failureMessage != "":
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, setupFailureReason(pods), failureMessage, probeTime)
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialSetupCreated, corev1.ConditionTrue, "", "", probeTime)
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); controller.IgnoreNotFound(err) != nil {
+			return &ctrl.Result{}, err
+		}
+	case conditionStatus != corev1.ConditionTrue && !dirty:
+		return &ctrl.Result{RequeueAfter: setupPollInterval}, nil
+	case conditionStatus == corev1.ConditionTrue && !dirty:
+		return nil, nil
+	}
+
+	err = r.Update(ctx, t)
+	return controller.RequeueConflict(err)
+}
+
 // finish takes care of removing initializers and finalizers
 func (r *SetupReconciler) finish(ctx context.Context, t *redskyv1alpha1.Trial) (*ctrl.Result, error) {
 	// If the create job isn't finished, wait for it (unless the trial is already finished, i.e. failed)
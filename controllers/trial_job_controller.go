@@ -18,19 +18,29 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/redskyops/redskyops-controller/internal/controller"
 	"github.com/redskyops/redskyops-controller/internal/meta"
+	"github.com/redskyops/redskyops-controller/internal/ready"
 	"github.com/redskyops/redskyops-controller/internal/trial"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // TrialJobReconciler reconciles a Trial's job
@@ -38,21 +48,48 @@ type TrialJobReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Events are read directly from the API server and watched per-namespace, they are not added to the shared cache
+	apiReader client.Reader
+	events    *ready.EventWatcher
 }
 
 // +kubebuilder:rbac:groups=redskyops.dev,resources=trials,verbs=get;list;watch;update
-// +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=list;watch;create
-// +kubebuilder:rbac:groups="",resources=pods,verbs=list
+// +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+// +kubebuilder:rbac:groups="",resources=events,verbs=list;watch
 
 func (r *TrialJobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
 	now := metav1.Now()
 
 	t := &redskyv1alpha1.Trial{}
-	if err := r.Get(ctx, req.NamespacedName, t); err != nil || r.ignoreTrial(t) {
+	if err := r.Get(ctx, req.NamespacedName, t); err != nil {
 		return ctrl.Result{}, controller.IgnoreNotFound(err)
 	}
 
+	// The deadline applies to every phase of the trial, not just the trial run
+	if result, err := r.enforceDeadline(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
+	if r.ignoreTrial(t) {
+		return deadlineResult(t, &now), nil
+	}
+
+	// Watch for fatal events in the namespace of the trial run
+	if err := r.events.WatchNamespace(t.Namespace); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Trial runs created from a run template are not jobs
+	if t.Spec.RunTemplate != nil {
+		if result, err := r.reconcileRun(ctx, t, &now); result != nil {
+			return *result, err
+		}
+		return ctrl.Result{}, nil
+	}
+
 	// List the trial jobs (there should only ever be 0 or 1 matching jobs)
 	jobList := &batchv1.JobList{}
 	if err := r.listJobs(ctx, jobList, t.Namespace, t.GetJobSelector()); err != nil {
@@ -66,20 +103,71 @@ func (r *TrialJobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 	// Create a new job if necessary
 	if len(jobList.Items) == 0 {
-		if result, err := r.createJob(ctx, t); result != nil {
+		if result, err := r.createJob(ctx, t, &now); result != nil {
 			return *result, err
 		}
 	}
 
-	return ctrl.Result{}, nil
+	return deadlineResult(t, &now), nil
 }
 
 func (r *TrialJobReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	r.apiReader = mgr.GetAPIReader()
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+
+	c, err := ctrl.NewControllerManagedBy(mgr).
 		Named("trial-job").
 		For(&redskyv1alpha1.Trial{}).
 		Owns(&batchv1.Job{}).
-		Complete(r)
+		Build(r)
+	if err != nil {
+		return err
+	}
+
+	// Only the fatal events of the trial job (or it's pods) are used to trigger a reconcile
+	h := &handler.EnqueueRequestsFromMapFunc{ToRequests: eventToTrialRequests(r.apiReader)}
+	r.events = ready.NewEventWatcher(clientset, func(src source.Source) error {
+		return c.Watch(src, h, ready.FatalEventPredicate())
+	})
+	return mgr.Add(r.events)
+}
+
+// eventToTrialRequests returns a mapping function that enqueues the trial labeling the job or pod an event is about
+func eventToTrialRequests(reader client.Reader) handler.ToRequestsFunc {
+	return func(o handler.MapObject) []reconcile.Request {
+		e, ok := o.Object.(*corev1.Event)
+		if !ok {
+			return nil
+		}
+
+		// Trial jobs and the pods they create are labeled with the name of the trial
+		var obj runtime.Object
+		switch e.InvolvedObject.Kind {
+		case "Job":
+			obj = &batchv1.Job{}
+		case "Pod":
+			obj = &corev1.Pod{}
+		default:
+			return nil
+		}
+		key := client.ObjectKey{Namespace: e.InvolvedObject.Namespace, Name: e.InvolvedObject.Name}
+		if err := reader.Get(context.TODO(), key, obj); err != nil {
+			return nil
+		}
+		m, err := apimeta.Accessor(obj)
+		if err != nil || m.GetUID() != e.InvolvedObject.UID {
+			return nil
+		}
+
+		name := m.GetLabels()[redskyv1alpha1.LabelTrial]
+		if name == "" {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: m.GetNamespace(), Name: name}}}
+	}
 }
 
 func (r *TrialJobReconciler) ignoreTrial(t *redskyv1alpha1.Trial) bool {
@@ -107,6 +195,73 @@ func (r *TrialJobReconciler) ignoreTrial(t *redskyv1alpha1.Trial) bool {
 	return false
 }
 
+// enforceDeadline fails the trial if it is still active after the deadline, the trial run is stopped if necessary
+func (r *TrialJobReconciler) enforceDeadline(ctx context.Context, t *redskyv1alpha1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	deadline := trial.ActiveDeadline(t)
+	if deadline == nil || probeTime.Before(deadline) || trial.IsFinished(t) || !t.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+
+	// Stop the trial run, if it is still running it would just interfere with the next trial
+	if err := r.deleteRuns(ctx, t); err != nil {
+		return &ctrl.Result{}, err
+	}
+
+	// Failing the trial will also trigger the setup delete tasks
+	msg := fmt.Sprintf("Trial was active longer than the deadline of %d seconds", *t.Spec.ActiveDeadlineSeconds)
+	trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, "DeadlineExceeded", msg, probeTime)
+	err := r.Update(ctx, t)
+	return controller.RequeueConflict(err)
+}
+
+// deleteRuns deletes the trial run jobs (or objects created from the run template)
+func (r *TrialJobReconciler) deleteRuns(ctx context.Context, t *redskyv1alpha1.Trial) error {
+	var runs []runtime.Object
+	if t.Spec.RunTemplate != nil {
+		run, err := trial.NewRun(t)
+		if err != nil {
+			// An invalid run template never created anything
+			return nil
+		}
+		runList := &unstructured.UnstructuredList{}
+		runList.SetGroupVersionKind(run.GroupVersionKind())
+		matchingLabels := client.MatchingLabels{redskyv1alpha1.LabelTrial: t.Name, redskyv1alpha1.LabelTrialRole: "trialRun"}
+		if err := r.List(ctx, runList, client.InNamespace(t.Namespace), matchingLabels); err != nil {
+			return err
+		}
+		for i := range runList.Items {
+			runs = append(runs, &runList.Items[i])
+		}
+	} else {
+		jobList := &batchv1.JobList{}
+		if err := r.listJobs(ctx, jobList, t.Namespace, t.GetJobSelector()); err != nil {
+			return err
+		}
+		for i := range jobList.Items {
+			if jobList.Items[i].Status.CompletionTime == nil {
+				runs = append(runs, &jobList.Items[i])
+			}
+		}
+	}
+
+	for _, run := range runs {
+		if err := r.Delete(ctx, run, client.PropagationPolicy(metav1.DeletePropagationBackground)); controller.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deadlineResult returns a result that ensures the trial is reconciled again when the deadline passes
+func deadlineResult(t *redskyv1alpha1.Trial, probeTime *metav1.Time) ctrl.Result {
+	if deadline := trial.ActiveDeadline(t); deadline != nil && !trial.IsFinished(t) && t.DeletionTimestamp.IsZero() {
+		if d := deadline.Sub(probeTime.Time); d > 0 {
+			return ctrl.Result{RequeueAfter: d}
+		}
+	}
+	return ctrl.Result{}
+}
+
 // updateStatus will update the trial status based on the supplied list of trial run jobs
 func (r *TrialJobReconciler) updateStatus(ctx context.Context, t *redskyv1alpha1.Trial, jobList *batchv1.JobList, probeTime *metav1.Time) (*ctrl.Result, error) {
 	for i := range jobList.Items {
@@ -123,16 +278,142 @@ func (r *TrialJobReconciler) updateStatus(ctx context.Context, t *redskyv1alpha1
 }
 
 // createJob will create a new trial run job
-func (r *TrialJobReconciler) createJob(ctx context.Context, t *redskyv1alpha1.Trial) (*ctrl.Result, error) {
-	job := trial.NewJob(t)
+func (r *TrialJobReconciler) createJob(ctx context.Context, t *redskyv1alpha1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	job, err := trial.NewJob(t)
+	if err != nil {
+		// The trial job patches cannot be retried, fail the trial
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, "PatchFailed", err.Error(), probeTime)
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
 	if err := controllerutil.SetControllerReference(t, job, r.Scheme); err != nil {
 		return &ctrl.Result{}, err
 	}
 
-	err := r.Create(ctx, job)
+	err = r.Create(ctx, job)
 	return &ctrl.Result{}, err
 }
 
+// reconcileRun will create the trial run from the run template and update the trial status using the state of the run
+func (r *TrialJobReconciler) reconcileRun(ctx context.Context, t *redskyv1alpha1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	run, err := trial.NewRun(t)
+	if err != nil {
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, "InvalidRunTemplate", err.Error(), probeTime)
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
+
+	// List the trial runs (there should only ever be 0 or 1 matching runs)
+	// RBAC: Like "patch", we assume that we have "list" and "create" permission from a customer defined role
+	runList := &unstructured.UnstructuredList{}
+	runList.SetGroupVersionKind(run.GroupVersionKind())
+	matchingLabels := client.MatchingLabels{redskyv1alpha1.LabelTrial: t.Name, redskyv1alpha1.LabelTrialRole: "trialRun"}
+	if err := r.List(ctx, runList, client.InNamespace(t.Namespace), matchingLabels); err != nil {
+		return &ctrl.Result{}, err
+	}
+
+	// Create a new run if necessary
+	if len(runList.Items) == 0 {
+		if err := controllerutil.SetControllerReference(t, run, r.Scheme); err != nil {
+			return &ctrl.Result{}, err
+		}
+		if err := r.Create(ctx, run); err != nil {
+			return &ctrl.Result{}, err
+		}
+		return &ctrl.Result{RequeueAfter: runPollInterval}, nil
+	}
+
+	// Update trial status based on existing run state
+	if dirty, err := r.applyRunStatus(ctx, t, &runList.Items[0], probeTime); err != nil {
+		return &ctrl.Result{}, err
+	} else if dirty {
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
+
+	// We are not watching the trial run kind, we need to poll for changes
+	return &ctrl.Result{RequeueAfter: runPollInterval}, nil
+}
+
+// runPollInterval is the amount of time between checks of the trial run state
+const runPollInterval = 5 * time.Second
+
+// applyRunStatus updates the trial status using the configured conditions and time fields of the trial run
+func (r *TrialJobReconciler) applyRunStatus(ctx context.Context, t *redskyv1alpha1.Trial, run *unstructured.Unstructured, probeTime *metav1.Time) (bool, error) {
+	rt := t.Spec.RunTemplate
+	checker := ready.ReadinessChecker{Reader: r, EventReader: r.apiReader}
+
+	// Look for fatal events (e.g. the run cannot create pods because a quota was exceeded)
+	if err := checker.CheckEvents(ctx, run, run.GetCreationTimestamp().Time); err != nil {
+		reason, msg := failureReason(err, "RunFailed", err.Error())
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, reason, msg, probeTime)
+		return true, nil
+	}
+
+	// Look for failures, this includes obviously failed pods owned by the run
+	for _, c := range rt.FailedConditionTypes {
+		msg, ok, err := checker.CheckConditions(ctx, run, []string{c})
+		if err != nil || ok {
+			if msg == "" {
+				msg = fmt.Sprintf("Trial run failed: %s", c)
+			}
+			reason, msg := failureReason(err, "RunFailed", msg)
+			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, reason, msg, probeTime)
+			return true, nil
+		}
+	}
+
+	var dirty bool
+
+	// Adjust the trial start time
+	startTimePath := rt.StartTimePath
+	if startTimePath == "" {
+		startTimePath = trial.DefaultStartTimePath
+	}
+	startedAt, err := trial.RunTime(run, startTimePath)
+	if err != nil {
+		return false, err
+	}
+	if startTime, updated := latestTime(t.Status.StartTime, startedAt, t.Spec.StartTimeOffset); updated {
+		t.Status.StartTime = startTime
+		dirty = true
+	}
+
+	// Check for completion, falling back to the current time if the completion time is not available
+	_, completed, err := checker.CheckConditions(ctx, run, rt.CompletedConditionTypes)
+	if err != nil {
+		reason, msg := failureReason(err, "RunFailed", err.Error())
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, reason, msg, probeTime)
+		return true, nil
+	}
+	if !completed {
+		return dirty, nil
+	}
+	completionTimePath := rt.CompletionTimePath
+	if completionTimePath == "" {
+		completionTimePath = trial.DefaultCompletionTimePath
+	}
+	finishedAt, err := trial.RunTime(run, completionTimePath)
+	if err != nil {
+		return false, err
+	}
+	if finishedAt == nil {
+		finishedAt = probeTime
+	}
+	if t.Status.StartTime == nil {
+		// A run that completed without reporting a start time is treated as starting when it was created
+		createdAt := run.GetCreationTimestamp()
+		t.Status.StartTime, _ = latestTime(nil, &createdAt, t.Spec.StartTimeOffset)
+		dirty = true
+	}
+	if completionTime, updated := earliestTime(t.Status.CompletionTime, finishedAt); updated {
+		t.Status.CompletionTime = completionTime
+		dirty = true
+	}
+
+	return dirty, nil
+}
+
 // listJobs will return all of the jobs for the trial
 func (r *TrialJobReconciler) listJobs(ctx context.Context, jobList *batchv1.JobList, namespace string, selector *metav1.LabelSelector) error {
 	matchingSelector, err := meta.MatchingSelector(selector)
@@ -162,9 +443,12 @@ func (r *TrialJobReconciler) applyJobStatus(ctx context.Context, t *redskyv1alph
 	// Get the interval of the container execution in the job pods
 	startedAt := job.Status.StartTime
 	finishedAt := job.Status.CompletionTime
+	var pods *corev1.PodList
+	var reason, msg string
 	if matchingSelector, err := meta.MatchingSelector(job.Spec.Selector); err == nil {
 		podList := &corev1.PodList{}
 		if err := r.List(ctx, podList, client.InNamespace(job.Namespace), matchingSelector); err == nil {
+			pods = podList
 			startedAt, finishedAt = containerTime(podList)
 
 			// Check if the job has a start/completion time, but it is not yet reflected in the pod state we are seeing
@@ -174,15 +458,28 @@ func (r *TrialJobReconciler) applyJobStatus(ctx context.Context, t *redskyv1alph
 
 			// Look for pod failures (edge case where job controller doesn't update status properly, e.g. initContainer failure)
 			for i := range podList.Items {
-				s := &podList.Items[i].Status
-				if s.Phase == corev1.PodFailed {
-					trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, s.Reason, "", time)
-					dirty = true
+				if reason, msg = ready.PodFailure(&podList.Items[i]); reason != "" {
+					break
+				}
+				if s := &podList.Items[i].Status; s.Phase == corev1.PodFailed {
+					reason, msg = s.Reason, s.Message
+					if reason == "" {
+						reason = "PodFailed"
+					}
+					break
 				}
 			}
 		}
 	}
 
+	// Look for fatal events (e.g. the job cannot create pods because a quota was exceeded)
+	if u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job); err == nil && reason == "" {
+		checker := ready.ReadinessChecker{Reader: r, EventReader: r.apiReader}
+		if err := checker.CheckEvents(ctx, &unstructured.Unstructured{Object: u}, job.CreationTimestamp.Time); err != nil {
+			reason, msg = failureReason(err, "JobFailed", err.Error())
+		}
+	}
+
 	// Adjust the trial start time
 	if startTime, updated := latestTime(t.Status.StartTime, startedAt, t.Spec.StartTimeOffset); updated {
 		t.Status.StartTime = startTime
@@ -195,17 +492,48 @@ func (r *TrialJobReconciler) applyJobStatus(ctx context.Context, t *redskyv1alph
 		dirty = true
 	}
 
-	// Mark the trial as failed if the job itself failed
+	// Mark the trial as failed if the job itself failed, preferring the more specific reason from the pods or events
 	for _, c := range job.Status.Conditions {
 		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
-			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, c.Reason, c.Message, time)
-			dirty = true
+			if reason == "" {
+				reason, msg = c.Reason, c.Message
+			}
+			trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, reason, msg, time)
+			return true, false
 		}
 	}
 
+	// The job controller retries failed pods, only fail early if the job cannot make any more progress
+	if reason != "" && backoffExhausted(job, pods) {
+		trial.ApplyCondition(&t.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, reason, msg, time)
+		dirty = true
+	}
+
 	return dirty, false
 }
 
+// backoffExhausted checks if the job controller will not retry any more pods of the supplied job
+func backoffExhausted(job *batchv1.Job, pods *corev1.PodList) bool {
+	backoffLimit := int32(6) // The Kubernetes default
+	if job.Spec.BackoffLimit != nil {
+		backoffLimit = *job.Spec.BackoffLimit
+	}
+
+	// Container restarts count as failures when the pods are restarted in place
+	failures := job.Status.Failed
+	if pods != nil && job.Spec.Template.Spec.RestartPolicy == corev1.RestartPolicyOnFailure {
+		for i := range pods.Items {
+			for _, cs := range pods.Items[i].Status.InitContainerStatuses {
+				failures += cs.RestartCount
+			}
+			for _, cs := range pods.Items[i].Status.ContainerStatuses {
+				failures += cs.RestartCount
+			}
+		}
+	}
+	return failures >= backoffLimit
+}
+
 func containerTime(pods *corev1.PodList) (startedAt *metav1.Time, finishedAt *metav1.Time) {
 	for i := range pods.Items {
 		for j := range pods.Items[i].Status.ContainerStatuses {
@@ -9,6 +9,7 @@ require (
 	github.com/Masterminds/sprig v2.20.0+incompatible
 	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/evanphx/json-patch v4.5.0+incompatible
 	github.com/go-logr/logr v0.1.0
 	github.com/go-logr/zapr v0.1.1 // indirect
 	github.com/huandu/xstrings v1.2.0 // indirect
@@ -17,6 +18,7 @@ require (
 	github.com/mdp/qrterminal/v3 v3.0.0
 	github.com/onsi/gomega v1.8.1
 	github.com/pkg/browser v0.0.0-20180916011732-0a3d74bf9ce4
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/prometheus/client_golang v1.0.0
 	github.com/prometheus/common v0.4.1
 	github.com/redskyops/redskyops-ui/v2 v2.0.2
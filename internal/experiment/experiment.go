@@ -25,6 +25,8 @@ import (
 const (
 	// HasTrialFinalizer is a finalizer that indicates an experiment has at least one trial
 	HasTrialFinalizer = "hasTrialFinalizer.redskyops.dev"
+	// SharedSetupFinalizer is a finalizer that indicates the experiment scoped setup tasks have not been deleted
+	SharedSetupFinalizer = "sharedSetupFinalizer.redskyops.dev"
 )
 
 // TODO Make the constant names better reflect the code, not the text
@@ -0,0 +1,40 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package experiment
+
+import (
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	redskyv1alpha2 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha2"
+	"sigs.k8s.io/yaml"
+)
+
+// UnmarshalManifest decodes an experiment manifest, experiments using a newer API version are converted
+func UnmarshalManifest(data []byte, exp *redskyv1alpha1.Experiment) error {
+	if err := yaml.Unmarshal(data, exp); err != nil {
+		return err
+	}
+	if exp.GroupVersionKind().GroupVersion() != redskyv1alpha2.GroupVersion {
+		return nil
+	}
+
+	in := &redskyv1alpha2.Experiment{}
+	if err := yaml.Unmarshal(data, in); err != nil {
+		return err
+	}
+	*exp = redskyv1alpha1.Experiment{}
+	return in.ConvertTo(exp)
+}
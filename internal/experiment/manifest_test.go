@@ -0,0 +1,48 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package experiment
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+)
+
+func TestUnmarshalManifest(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, apiVersion := range []string{"redskyops.dev/v1alpha1", "redskyops.dev/v1alpha2"} {
+		data := []byte(`apiVersion: ` + apiVersion + `
+kind: Experiment
+metadata:
+  name: test
+spec:
+  template:
+    spec:
+      template:
+        spec:
+          backoffLimit: 1
+`)
+		exp := &redskyv1alpha1.Experiment{}
+		g.Expect(UnmarshalManifest(data, exp)).To(Succeed())
+		g.Expect(exp.GroupVersionKind().GroupVersion()).To(Equal(redskyv1alpha1.GroupVersion))
+		g.Expect(exp.Name).To(Equal("test"))
+		g.Expect(exp.Spec.Template.Spec.Template).NotTo(BeNil())
+		g.Expect(*exp.Spec.Template.Spec.Template.Spec.BackoffLimit).To(Equal(int32(1)))
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package experiment
 
 import (
+	"github.com/redskyops/redskyops-controller/internal/template"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -60,3 +61,23 @@ func PopulateTrialFromTemplate(exp *redskyv1alpha1.Experiment, t *redskyv1alpha1
 		t.Namespace = exp.Namespace
 	}
 }
+
+// PopulateDerivedAssignments computes the values of the experiment derived parameters from the trial assignments, it
+// should be called once the trial assignments are known; if a derived parameter cannot be computed, the assignments of
+// the preceding derived parameters are kept and an error is returned
+func PopulateDerivedAssignments(exp *redskyv1alpha1.Experiment, t *redskyv1alpha1.Trial) error {
+	t.Spec.DerivedAssignments = nil
+	te := template.New()
+	for i := range exp.Spec.DerivedParameters {
+		// Derived parameters are evaluated in order so they can reference previously derived values
+		v, err := te.RenderDerivedParameter(&exp.Spec.DerivedParameters[i], t)
+		if err != nil {
+			return err
+		}
+		t.Spec.DerivedAssignments = append(t.Spec.DerivedAssignments, redskyv1alpha1.Assignment{
+			Name:  exp.Spec.DerivedParameters[i].Name,
+			Value: v,
+		})
+	}
+	return nil
+}
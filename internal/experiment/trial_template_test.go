@@ -0,0 +1,55 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package experiment
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+)
+
+func TestPopulateDerivedAssignments(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	exp := &redskyv1alpha1.Experiment{}
+	exp.Spec.DerivedParameters = []redskyv1alpha1.DerivedParameter{
+		{Name: "heap", Expression: "{{ percent .Values.memory 75 }}"},
+		{Name: "limit", Expression: "{{ mulf .Values.memory 1.5 | roundUp 64 }}"},
+		{Name: "offHeap", Expression: "{{ sub .Values.memory .Values.heap }}"},
+	}
+
+	tr := &redskyv1alpha1.Trial{}
+	tr.Spec.Assignments = []redskyv1alpha1.Assignment{{Name: "memory", Value: 1000}}
+
+	g.Expect(PopulateDerivedAssignments(exp, tr)).To(Succeed())
+	g.Expect(tr.Spec.DerivedAssignments).To(Equal([]redskyv1alpha1.Assignment{
+		{Name: "heap", Value: 750},
+		{Name: "limit", Value: 1536},
+		{Name: "offHeap", Value: 250},
+	}))
+	heap, ok := tr.GetAssignment("heap")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(heap).To(Equal(int64(750)))
+
+	exp.Spec.DerivedParameters = []redskyv1alpha1.DerivedParameter{
+		{Name: "heap", Expression: "{{ percent .Values.memory 75 }}"},
+		{Name: "invalid", Expression: "not a number"},
+	}
+	g.Expect(PopulateDerivedAssignments(exp, tr)).ToNot(Succeed())
+	g.Expect(tr.Spec.DerivedAssignments).To(Equal([]redskyv1alpha1.Assignment{{Name: "heap", Value: 750}}))
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meta
+
+import (
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// ApplyPatch applies a patch to the supplied JSON representation of an object, the data structure is required for
+// strategic merge patches and should be an instance of the Go type represented by the JSON
+func ApplyPatch(original []byte, patchType types.PatchType, data []byte, dataStruct interface{}) ([]byte, error) {
+	switch patchType {
+	case types.StrategicMergePatchType:
+		if dataStruct == nil {
+			return nil, fmt.Errorf("strategic merge patch requires a data structure")
+		}
+		return strategicpatch.StrategicMergePatch(original, data, dataStruct)
+	case types.MergePatchType:
+		return jsonpatch.MergePatch(original, data)
+	case types.ApplyPatchType:
+		// This is only an approximation of the merge performed by the server
+		if dataStruct == nil {
+			return jsonpatch.MergePatch(original, data)
+		}
+		return strategicpatch.StrategicMergePatch(original, data, dataStruct)
+	case types.JSONPatchType:
+		p, err := jsonpatch.DecodePatch(data)
+		if err != nil {
+			return nil, err
+		}
+		return p.Apply(original)
+	default:
+		return nil, fmt.Errorf("unknown patch type: %s", patchType)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meta
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestApplyPatch(t *testing.T) {
+	original := []byte(`{"spec":{"replicas":1,"template":{"spec":{"containers":[{"name":"a","image":"a:1"},{"name":"b","image":"b:1"}]}}}}`)
+	cases := []struct {
+		desc       string
+		patchType  types.PatchType
+		data       string
+		dataStruct interface{}
+		expected   string
+		err        bool
+	}{
+		{
+			desc:       "strategic",
+			patchType:  types.StrategicMergePatchType,
+			data:       `{"spec":{"template":{"spec":{"containers":[{"name":"b","image":"b:2"}]}}}}`,
+			dataStruct: &appsv1.Deployment{},
+			expected:   `{"spec":{"replicas":1,"template":{"spec":{"containers":[{"name":"a","image":"a:1"},{"name":"b","image":"b:2"}]}}}}`,
+		},
+		{
+			desc:      "strategic without type",
+			patchType: types.StrategicMergePatchType,
+			data:      `{"spec":{"replicas":2}}`,
+			err:       true,
+		},
+		{
+			desc:      "merge",
+			patchType: types.MergePatchType,
+			data:      `{"spec":{"replicas":2}}`,
+			expected:  `{"spec":{"replicas":2,"template":{"spec":{"containers":[{"name":"a","image":"a:1"},{"name":"b","image":"b:1"}]}}}}`,
+		},
+		{
+			desc:      "apply without type",
+			patchType: types.ApplyPatchType,
+			data:      `{"spec":{"replicas":2}}`,
+			expected:  `{"spec":{"replicas":2,"template":{"spec":{"containers":[{"name":"a","image":"a:1"},{"name":"b","image":"b:1"}]}}}}`,
+		},
+		{
+			desc:      "json",
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"replace","path":"/spec/template/spec/containers/0/image","value":"a:2"}]`,
+			expected:  `{"spec":{"replicas":1,"template":{"spec":{"containers":[{"name":"a","image":"a:2"},{"name":"b","image":"b:1"}]}}}}`,
+		},
+		{
+			desc:      "unknown",
+			patchType: "application/unknown",
+			data:      `{}`,
+			err:       true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			patched, err := ApplyPatch(original, c.patchType, []byte(c.data), c.dataStruct)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.JSONEq(t, c.expected, string(patched))
+			}
+		})
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/redskyops/redskyops-controller/internal/template"
+	internaltrial "github.com/redskyops/redskyops-controller/internal/trial"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -46,17 +47,21 @@ func (e *CaptureError) Error() string {
 	return e.Message
 }
 
-// CaptureMetric captures a point-in-time metric value and it's error (standard deviation)
-func CaptureMetric(metric *redskyv1alpha1.Metric, trial *redskyv1alpha1.Trial, target runtime.Object) (float64, float64, error) {
+// CaptureMetric captures a point-in-time metric value and it's error (standard deviation), the supplied template engine
+// is used to render the metric queries
+func CaptureMetric(te *template.Engine, metric *redskyv1alpha1.Metric, trial *redskyv1alpha1.Trial, target runtime.Object) (float64, float64, error) {
 	// Work on a copy so we can render the queries in place
 	metric = metric.DeepCopy()
 
 	// Execute the query as a template against the current state of the trial
 	var err error
-	if metric.Query, metric.ErrorQuery, err = template.New().RenderMetricQueries(metric, trial, target); err != nil {
+	if metric.Query, metric.ErrorQuery, err = te.RenderMetricQueries(metric, trial, target); err != nil {
 		return 0, 0, err
 	}
 
+	// Metrics are collected over the measurement window of the trial run
+	startTime, completionTime := internaltrial.MeasurementTimes(trial)
+
 	// Capture the value based on the metric type
 	switch metric.Type {
 	case redskyv1alpha1.MetricLocal, redskyv1alpha1.MetricPods, "":
@@ -64,9 +69,9 @@ func CaptureMetric(metric *redskyv1alpha1.Metric, trial *redskyv1alpha1.Trial, t
 		value, err := strconv.ParseFloat(metric.Query, 64)
 		return value, 0, err
 	case redskyv1alpha1.MetricPrometheus:
-		return capturePrometheusMetric(metric, target, trial.Status.CompletionTime.Time)
+		return capturePrometheusMetric(metric, target, completionTime)
 	case redskyv1alpha1.MetricDatadog:
-		return captureDatadogMetric(metric.Scheme, metric.Query, trial.Status.StartTime.Time, trial.Status.CompletionTime.Time)
+		return captureDatadogMetric(metric.Scheme, metric.Query, startTime, completionTime)
 	case redskyv1alpha1.MetricJSONPath:
 		return captureJSONPathMetric(metric, target)
 	default:
@@ -0,0 +1,199 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Overlap describes a field that is set by more then one patch operation, the result of applying the patches depends
+// on the order they are applied in
+type Overlap struct {
+	// First is the index of the first patch operation to set the field
+	First int
+	// Second is the index of the patch operation which overwrites the field
+	Second int
+	// Path is the field that both patch operations set
+	Path string
+}
+
+// String returns a description of the overlap
+func (o *Overlap) String() string {
+	return fmt.Sprintf("patch operations %d and %d both set %s", o.First, o.Second, o.Path)
+}
+
+// FindOverlaps returns the overlapping fields of patch operations against the same target
+func FindOverlaps(pos []redskyv1alpha1.PatchOperation) ([]Overlap, error) {
+	paths := make([][][]string, len(pos))
+	for i := range pos {
+		p, err := fieldPaths(&pos[i])
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = p
+	}
+
+	var overlaps []Overlap
+	for i := range pos {
+		for j := i + 1; j < len(pos); j++ {
+			if !sameTarget(&pos[i], &pos[j]) {
+				continue
+			}
+			if path, ok := overlappingPath(paths[i], paths[j]); ok {
+				overlaps = append(overlaps, Overlap{First: i, Second: j, Path: pathString(path)})
+			}
+		}
+	}
+	return overlaps, nil
+}
+
+// sameTarget checks if two patch operations apply to the same object
+func sameTarget(a, b *redskyv1alpha1.PatchOperation) bool {
+	return a.TargetRef.Kind == b.TargetRef.Kind &&
+		a.TargetRef.Name == b.TargetRef.Name &&
+		a.TargetRef.Namespace == b.TargetRef.Namespace &&
+		a.TargetRef.GroupVersionKind().Group == b.TargetRef.GroupVersionKind().Group
+}
+
+// overlappingPath returns the shortest path in the first list that is equal to (or a prefix of) a path in the second
+// list, or vice versa
+func overlappingPath(a, b [][]string) ([]string, bool) {
+	var overlap []string
+	for _, pa := range a {
+		for _, pb := range b {
+			shorter, longer := pa, pb
+			if len(shorter) > len(longer) {
+				shorter, longer = longer, shorter
+			}
+			if isPrefix(shorter, longer) && (overlap == nil || len(shorter) < len(overlap)) {
+				overlap = shorter
+			}
+		}
+	}
+	return overlap, overlap != nil
+}
+
+// isPrefix checks if the first path is a prefix of the second path
+func isPrefix(prefix, path []string) bool {
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldPaths returns the paths of the fields set by a patch operation; list elements are included in the path using
+// either their name (for strategic merge patches) or their index (for JSON patches). Without the patched object the
+// index of an element cannot be matched to its name, so the paths of the two patch types never overlap within a list.
+func fieldPaths(po *redskyv1alpha1.PatchOperation) ([][]string, error) {
+	var paths [][]string
+	switch po.PatchType {
+	case types.StrategicMergePatchType, types.MergePatchType, types.ApplyPatchType:
+		data := make(map[string]interface{})
+		if err := json.Unmarshal(po.Data, &data); err != nil {
+			return nil, err
+		}
+		fieldPathsFromMap(data, nil, po.PatchType != types.MergePatchType, &paths)
+
+	case types.JSONPatchType:
+		var ops []struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(po.Data, &ops); err != nil {
+			return nil, err
+		}
+		for _, op := range ops {
+			// Tests do not set anything and appending to a list cannot overwrite anything
+			if op.Op == "test" || strings.HasSuffix(op.Path, "/-") {
+				continue
+			}
+			paths = append(paths, pointerPath(op.Path))
+		}
+	}
+
+	// Make the results deterministic
+	sort.Slice(paths, func(i, j int) bool { return pathString(paths[i]) < pathString(paths[j]) })
+	return paths, nil
+}
+
+// fieldPathsFromMap collects the paths of the leaf values in the supplied patch data
+func fieldPathsFromMap(data map[string]interface{}, prefix []string, mergeLists bool, paths *[][]string) {
+	for k, v := range data {
+		if ignoredField(prefix, k) {
+			continue
+		}
+
+		path := append(append([]string{}, prefix...), k)
+		switch t := v.(type) {
+		case map[string]interface{}:
+			if len(t) > 0 {
+				fieldPathsFromMap(t, path, mergeLists, paths)
+				continue
+			}
+		case []interface{}:
+			if mergeLists && namedElements(t) {
+				for _, e := range t {
+					// The name only identifies the element, it is not set by the patch
+					m := make(map[string]interface{})
+					for ek, ev := range e.(map[string]interface{}) {
+						m[ek] = ev
+					}
+					name := m["name"]
+					delete(m, "name")
+					fieldPathsFromMap(m, append(append([]string{}, path...), fmt.Sprintf("[name=%v]", name)), mergeLists, paths)
+				}
+				continue
+			}
+		}
+		*paths = append(*paths, path)
+	}
+}
+
+// namedElements checks if every element of a list is an object with a name, i.e. the list will be merged by a
+// strategic merge patch using the name as the key
+func namedElements(list []interface{}) bool {
+	for _, e := range list {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := m["name"]; !ok {
+			return false
+		}
+	}
+	return len(list) > 0
+}
+
+// pathString returns the display form of a field path
+func pathString(path []string) string {
+	var b strings.Builder
+	for i, p := range path {
+		if i > 0 && !strings.HasPrefix(p, "[") {
+			b.WriteByte('.')
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"testing"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestFindOverlaps(t *testing.T) {
+	app := corev1.ObjectReference{Kind: "Deployment", Name: "app", Namespace: "default"}
+	other := corev1.ObjectReference{Kind: "Deployment", Name: "other", Namespace: "default"}
+
+	cases := []struct {
+		desc     string
+		pos      []redskyv1alpha1.PatchOperation
+		expected []Overlap
+	}{
+		{
+			desc: "same field",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"replicas":1}}`)},
+				{TargetRef: app, PatchType: types.MergePatchType, Data: []byte(`{"spec":{"replicas":2}}`)},
+			},
+			expected: []Overlap{{First: 0, Second: 1, Path: "spec.replicas"}},
+		},
+		{
+			desc: "different targets",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"replicas":1}}`)},
+				{TargetRef: other, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"replicas":2}}`)},
+			},
+		},
+		{
+			desc: "different containers",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a"}]}}}}`)},
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"template":{"spec":{"containers":[{"name":"b","image":"b"}]}}}}`)},
+			},
+		},
+		{
+			desc: "same container",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"template":{"spec":{"containers":[{"name":"a","resources":{"limits":{"cpu":"1"}}}]}}}}`)},
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"template":{"spec":{"containers":[{"name":"a","resources":null}]}}}}`)},
+			},
+			expected: []Overlap{{First: 0, Second: 1, Path: "spec.template.spec.containers[name=a].resources"}},
+		},
+		{
+			desc: "json patch",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.JSONPatchType, Data: []byte(`[{"op":"replace","path":"/spec/replicas","value":1},{"op":"add","path":"/spec/template/spec/containers/-","value":{}}]`)},
+				{TargetRef: app, PatchType: types.JSONPatchType, Data: []byte(`[{"op":"test","path":"/spec/replicas","value":1},{"op":"add","path":"/spec/template/spec/containers/-","value":{}}]`)},
+				{TargetRef: app, PatchType: types.JSONPatchType, Data: []byte(`[{"op":"remove","path":"/spec"}]`)},
+			},
+			expected: []Overlap{
+				{First: 0, Second: 2, Path: "spec"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			overlaps, err := FindOverlaps(c.pos)
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.expected, overlaps)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redskyops/redskyops-controller/internal/meta"
+	"github.com/redskyops/redskyops-controller/internal/template"
+	"github.com/redskyops/redskyops-controller/internal/trial"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// FieldManager is the name of the manager used to identify the fields owned by server-side apply patches
+const FieldManager = "redskyops-patch"
+
+// RenderTemplate determines the patch target and renders the patch template; the experiment and the current state of
+// the target are optional, however the target is required to identify the object matched by a selector
+func RenderTemplate(te *template.Engine, exp *redskyv1alpha1.Experiment, t *redskyv1alpha1.Trial, p *redskyv1alpha1.PatchTemplate, target *unstructured.Unstructured) (*corev1.ObjectReference, []byte, error) {
+	// Render the actual patch data
+	data, err := te.RenderPatch(p, t, exp, target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Determine the reference, possibly extracting it from the rendered data
+	ref := &corev1.ObjectReference{}
+	if p.TargetRef != nil {
+		p.TargetRef.DeepCopyInto(ref)
+		if p.Selector != nil && target != nil {
+			ref.Name = target.GetName()
+		}
+	} else if p.Type == redskyv1alpha1.PatchStrategic || p.Type == redskyv1alpha1.PatchServerSide || p.Type == "" {
+		m := &struct {
+			metav1.TypeMeta   `json:",inline"`
+			metav1.ObjectMeta `json:"metadata,omitempty"`
+		}{}
+		if err := json.Unmarshal(data, m); err == nil {
+			ref.APIVersion = m.APIVersion
+			ref.Kind = m.Kind
+			ref.Name = m.Name
+			ref.Namespace = m.Namespace
+		}
+	}
+
+	// Default the namespace to the trial namespace
+	if ref.Namespace == "" {
+		ref.Namespace = t.Namespace
+	}
+
+	// Validate the reference, the name is not known until the selector is evaluated
+	if p.Selector != nil {
+		if p.TargetRef == nil || ref.Kind == "" || p.TargetRef.Name != "" {
+			return nil, nil, fmt.Errorf("invalid patch selector, the target reference must have a kind and no name")
+		}
+	} else if ref.Name == "" || ref.Kind == "" {
+		return nil, nil, fmt.Errorf("invalid patch reference")
+	}
+
+	return ref, data, nil
+}
+
+// CreatePatchOperation creates a new patch operation from a patch template and it's (fully rendered) patch data
+func CreatePatchOperation(t *redskyv1alpha1.Trial, p *redskyv1alpha1.PatchTemplate, ref *corev1.ObjectReference, data []byte) (*redskyv1alpha1.PatchOperation, error) {
+	po := &redskyv1alpha1.PatchOperation{
+		TargetRef:         *ref,
+		Data:              data,
+		AttemptsRemaining: 3,
+	}
+
+	// If the patch is effectively null, we do not need to evaluate it
+	if len(po.Data) == 0 || string(po.Data) == "null" {
+		return nil, nil
+	}
+
+	// Determine the patch type
+	switch p.Type {
+	case redskyv1alpha1.PatchStrategic, "":
+		po.PatchType = types.StrategicMergePatchType
+	case redskyv1alpha1.PatchMerge:
+		po.PatchType = types.MergePatchType
+	case redskyv1alpha1.PatchJSON:
+		po.PatchType = types.JSONPatchType
+	case redskyv1alpha1.PatchServerSide:
+		po.PatchType = types.ApplyPatchType
+		if err := addApplyIdentity(po); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown patch type: %s", p.Type)
+	}
+
+	// If the patch is for the trial job itself, it cannot be applied (since the job won't exist until well after patches are applied)
+	if trial.IsTrialJobReference(t, &po.TargetRef) {
+		po.AttemptsRemaining = 0
+	}
+
+	return po, nil
+}
+
+// addApplyIdentity ensures the data of a server-side apply patch includes the identifying fields of the target
+func addApplyIdentity(po *redskyv1alpha1.PatchOperation) error {
+	if po.TargetRef.APIVersion == "" {
+		return fmt.Errorf("server-side apply patch requires an API version")
+	}
+
+	obj := make(map[string]interface{})
+	if err := json.Unmarshal(po.Data, &obj); err != nil {
+		return err
+	}
+	u := &unstructured.Unstructured{Object: obj}
+	u.SetAPIVersion(po.TargetRef.APIVersion)
+	u.SetKind(po.TargetRef.Kind)
+	u.SetName(po.TargetRef.Name)
+	u.SetNamespace(po.TargetRef.Namespace)
+
+	data, err := u.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	po.Data = data
+	return nil
+}
+
+// Apply applies a patch operation to the supplied JSON representation of an object, the data structure is required
+// for strategic merge patches and should be an instance of the Go type represented by the JSON
+func Apply(original []byte, po *redskyv1alpha1.PatchOperation, dataStruct interface{}) ([]byte, error) {
+	if po.PatchType == types.StrategicMergePatchType && dataStruct == nil {
+		return nil, fmt.Errorf("strategic merge patch is not supported for %s", po.TargetRef.GroupVersionKind())
+	}
+	return meta.ApplyPatch(original, po.PatchType, po.Data, dataStruct)
+}
+
+// RestoreData returns a patch that will restore the fields modified by the patch operation to the state they have in
+// the supplied JSON representation of the original object; only the modified fields are restored, for built-in kinds
+// list elements are identified by their merge key so changes to other elements of the same list are kept
+func RestoreData(original []byte, po *redskyv1alpha1.PatchOperation) ([]byte, error) {
+	obj := make(map[string]interface{})
+	if err := json.Unmarshal(original, &obj); err != nil {
+		return nil, err
+	}
+
+	m, err := restoreMerge(&po.TargetRef)
+	if err != nil {
+		return nil, err
+	}
+
+	switch po.PatchType {
+	case types.StrategicMergePatchType, types.MergePatchType, types.ApplyPatchType:
+		data := make(map[string]interface{})
+		if err := json.Unmarshal(po.Data, &data); err != nil {
+			return nil, err
+		}
+		// Lists in a merge patch always replace the entire list
+		m.merge = m.merge && po.PatchType != types.MergePatchType
+		return json.Marshal(restoreValue(data, obj, true, nil, m))
+
+	case types.JSONPatchType:
+		restore, err := jsonPatchRestore(original, obj, po.Data, m)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(restore)
+
+	default:
+		return nil, fmt.Errorf("unknown patch type: %s", po.PatchType)
+	}
+}
+
+// jsonPatchRestore returns the restore patch for a JSON patch; each operation is converted to the equivalent strategic
+// merge patch so list indexes can be replaced by the merge key of the element they referenced when the patch was applied
+func jsonPatchRestore(original []byte, obj map[string]interface{}, data []byte, m *listMerge) (map[string]interface{}, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	restore := make(map[string]interface{})
+	current := original
+	for _, r := range raw {
+		var op struct {
+			Op    string      `json:"op"`
+			Path  string      `json:"path"`
+			From  string      `json:"from"`
+			Value interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(r, &op); err != nil {
+			return nil, err
+		}
+		if op.Op == "test" {
+			continue
+		}
+
+		// Indexes refer to the state of the object after the preceding operations were applied
+		state := make(map[string]interface{})
+		if err := json.Unmarshal(current, &state); err != nil {
+			return nil, err
+		}
+
+		var fragments []interface{}
+		switch op.Op {
+		case "move":
+			value, _ := pointerValue(state, pointerPath(op.From))
+			fragments = append(fragments,
+				jsonPatchFragment(state, pointerPath(op.From), "remove", nil, m),
+				jsonPatchFragment(state, pointerPath(op.Path), "add", value, m))
+		case "copy":
+			value, _ := pointerValue(state, pointerPath(op.From))
+			fragments = append(fragments, jsonPatchFragment(state, pointerPath(op.Path), "add", value, m))
+		default:
+			fragments = append(fragments, jsonPatchFragment(state, pointerPath(op.Path), op.Op, op.Value, m))
+		}
+		for _, f := range fragments {
+			if fm, ok := f.(map[string]interface{}); ok {
+				mergeRestoreValues(restore, restoreValue(fm, obj, true, nil, m).(map[string]interface{}), m)
+			}
+		}
+
+		var err error
+		if current, err = meta.ApplyPatch(current, types.JSONPatchType, []byte("["+string(r)+"]"), nil); err != nil {
+			return nil, err
+		}
+	}
+	return restore, nil
+}
+
+// jsonPatchFragment returns the strategic merge patch equivalent to a JSON patch operation against the current value;
+// list elements are identified by their merge key, if an element cannot be identified the entire list is replaced
+func jsonPatchFragment(current interface{}, path []string, op string, value interface{}, m *listMerge) interface{} {
+	if len(path) == 0 {
+		if op == "remove" {
+			return nil
+		}
+		return value
+	}
+
+	switch c := current.(type) {
+	case []interface{}:
+		key, merged, err := m.mergeKey(c)
+		i, ierr := strconv.Atoi(path[0])
+		if path[0] == "-" {
+			i, ierr = len(c), nil
+		}
+		if err != nil || !merged || key == "" || ierr != nil || i < 0 || i > len(c) {
+			return replacedList(c, op, value)
+		}
+
+		var element map[string]interface{}
+		if i < len(c) {
+			element, _ = c[i].(map[string]interface{})
+		}
+		switch {
+		case len(path) > 1 && element != nil && element[key] != nil:
+			f, ok := jsonPatchFragment(element, path[1:], op, value, m.element()).(map[string]interface{})
+			if !ok {
+				return replacedList(c, op, value)
+			}
+			f[key] = element[key]
+			return []interface{}{f}
+
+		case len(path) == 1 && op == "add":
+			if v, ok := value.(map[string]interface{}); ok && v[key] != nil {
+				return []interface{}{v}
+			}
+
+		case len(path) == 1 && op == "remove" && element != nil && element[key] != nil:
+			return []interface{}{map[string]interface{}{key: element[key], "$patch": "delete"}}
+
+		case len(path) == 1 && op == "replace" && element != nil && element[key] != nil:
+			// Fields of the element which are not part of the new value are removed
+			f := make(map[string]interface{}, len(element))
+			for k := range element {
+				f[k] = nil
+			}
+			if v, ok := value.(map[string]interface{}); ok {
+				for k, vv := range v {
+					f[k] = vv
+				}
+			}
+			f[key] = element[key]
+			return []interface{}{f}
+		}
+		return replacedList(c, op, value)
+
+	default:
+		cm, _ := c.(map[string]interface{})
+		cv := cm[path[0]]
+		if cv == nil && len(path) > 1 {
+			cv = map[string]interface{}{}
+		}
+		f := jsonPatchFragment(cv, path[1:], op, value, m.field(path[0], fieldValue(cv, value)))
+		return map[string]interface{}{path[0]: f}
+	}
+}
+
+// fieldValue returns a value representing the type of a field, used to lookup the patch metadata of the field
+func fieldValue(current, value interface{}) interface{} {
+	if current != nil {
+		return current
+	}
+	return value
+}
+
+// replacedList returns a list which indicates the entire list is modified by a JSON patch operation; lists of primitive
+// values contain the added value so it can be removed from a list that is merged
+func replacedList(list []interface{}, op string, value interface{}) []interface{} {
+	for _, e := range list {
+		if _, ok := e.(map[string]interface{}); ok {
+			return []interface{}{map[string]interface{}{"$patch": "replace"}}
+		}
+	}
+	if _, ok := value.(map[string]interface{}); ok {
+		return []interface{}{map[string]interface{}{"$patch": "replace"}}
+	}
+	if op != "remove" && value != nil {
+		if _, ok := value.([]interface{}); !ok {
+			return []interface{}{value}
+		}
+	}
+	return []interface{}{}
+}
+
+// ignoredField checks if a key of the patch data at the supplied path is a strategic merge patch directive or one of
+// the identifying fields of the object, neither of which are set by the patch
+func ignoredField(path []string, key string) bool {
+	return strings.HasPrefix(key, "$") ||
+		(len(path) == 0 && (key == "apiVersion" || key == "kind")) ||
+		(len(path) == 1 && path[0] == "metadata" && (key == "name" || key == "namespace"))
+}
+
+// pointerPath decodes a JSON pointer into a field path
+func pointerPath(pointer string) []string {
+	var path []string
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		path = append(path, strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~"))
+	}
+	return path
+}
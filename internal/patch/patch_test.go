@@ -0,0 +1,306 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"testing"
+
+	"github.com/redskyops/redskyops-controller/internal/template"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	trial := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+		Spec: redskyv1alpha1.TrialSpec{
+			Assignments: []redskyv1alpha1.Assignment{{Name: "cpu", Value: 500}},
+		},
+	}
+
+	cases := []struct {
+		desc         string
+		patch        redskyv1alpha1.PatchTemplate
+		target       *unstructured.Unstructured
+		expectedRef  *corev1.ObjectReference
+		expectedData string
+	}{
+		{
+			desc: "target reference",
+			patch: redskyv1alpha1.PatchTemplate{
+				TargetRef: &corev1.ObjectReference{Kind: "Deployment", Name: "app"},
+				Patch:     `{"spec":{"cpu":"{{ .Values.cpu }}m"}}`,
+			},
+			expectedRef:  &corev1.ObjectReference{Kind: "Deployment", Name: "app", Namespace: "default"},
+			expectedData: `{"spec":{"cpu":"500m"}}`,
+		},
+		{
+			desc: "embedded reference",
+			patch: redskyv1alpha1.PatchTemplate{
+				Patch: `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app","namespace":"test"}}`,
+			},
+			expectedRef:  &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "test"},
+			expectedData: `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app","namespace":"test"}}`,
+		},
+		{
+			desc: "selector",
+			patch: redskyv1alpha1.PatchTemplate{
+				TargetRef: &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment"},
+				Selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+				Patch:     `{"spec":{"replicas":{{ .Target.spec.replicas }},"cpu":"{{ .Values.cpu }}m"}}`,
+			},
+			target: &unstructured.Unstructured{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "app-1"},
+				"spec":     map[string]interface{}{"replicas": int64(2)},
+			}},
+			expectedRef:  &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app-1", Namespace: "default"},
+			expectedData: `{"spec":{"replicas":2,"cpu":"500m"}}`,
+		},
+		{
+			desc: "selector with name",
+			patch: redskyv1alpha1.PatchTemplate{
+				TargetRef: &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app"},
+				Selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+				Patch:     `{"spec":{"cpu":"{{ .Values.cpu }}m"}}`,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			ref, data, err := RenderTemplate(template.New(), nil, trial, &c.patch, c.target)
+			if c.expectedRef == nil {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, c.expectedRef, ref)
+				assert.JSONEq(t, c.expectedData, string(data))
+			}
+		})
+	}
+}
+
+func TestCreatePatchOperation(t *testing.T) {
+	trial := &redskyv1alpha1.Trial{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}}
+
+	cases := []struct {
+		desc         string
+		patch        redskyv1alpha1.PatchTemplate
+		ref          corev1.ObjectReference
+		data         string
+		expectedType types.PatchType
+		expectedData string
+		expectedErr  bool
+	}{
+		{
+			desc:         "default",
+			ref:          corev1.ObjectReference{Kind: "Deployment", Name: "app", Namespace: "default"},
+			data:         `{"spec":{"replicas":2}}`,
+			expectedType: types.StrategicMergePatchType,
+			expectedData: `{"spec":{"replicas":2}}`,
+		},
+		{
+			desc:         "server-side",
+			patch:        redskyv1alpha1.PatchTemplate{Type: redskyv1alpha1.PatchServerSide},
+			ref:          corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "default"},
+			data:         `{"spec":{"replicas":2}}`,
+			expectedType: types.ApplyPatchType,
+			expectedData: `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app","namespace":"default"},"spec":{"replicas":2}}`,
+		},
+		{
+			desc:        "server-side without API version",
+			patch:       redskyv1alpha1.PatchTemplate{Type: redskyv1alpha1.PatchServerSide},
+			ref:         corev1.ObjectReference{Kind: "Deployment", Name: "app", Namespace: "default"},
+			data:        `{"spec":{"replicas":2}}`,
+			expectedErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			po, err := CreatePatchOperation(trial, &c.patch, &c.ref, []byte(c.data))
+			if c.expectedErr {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, c.expectedType, po.PatchType)
+				assert.JSONEq(t, c.expectedData, string(po.Data))
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	original := `{"spec":{"replicas":1,"template":{"spec":{"containers":[{"name":"a","image":"a"},{"name":"b","image":"b"}]}}}}`
+
+	cases := []struct {
+		desc       string
+		patchType  types.PatchType
+		data       string
+		dataStruct interface{}
+		expected   string
+	}{
+		{
+			desc:       "strategic",
+			patchType:  types.StrategicMergePatchType,
+			data:       `{"spec":{"template":{"spec":{"containers":[{"name":"b","image":"c"}]}}}}`,
+			dataStruct: &appsv1.Deployment{},
+			expected:   `{"spec":{"replicas":1,"template":{"spec":{"containers":[{"name":"a","image":"a"},{"name":"b","image":"c"}]}}}}`,
+		},
+		{
+			desc:      "merge",
+			patchType: types.MergePatchType,
+			data:      `{"spec":{"replicas":2}}`,
+			expected:  `{"spec":{"replicas":2,"template":{"spec":{"containers":[{"name":"a","image":"a"},{"name":"b","image":"b"}]}}}}`,
+		},
+		{
+			desc:      "json",
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"replace","path":"/spec/template/spec/containers/0/image","value":"c"}]`,
+			expected:  `{"spec":{"replicas":1,"template":{"spec":{"containers":[{"name":"a","image":"c"},{"name":"b","image":"b"}]}}}}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			po := &redskyv1alpha1.PatchOperation{PatchType: c.patchType, Data: []byte(c.data)}
+			patched, err := Apply([]byte(original), po, c.dataStruct)
+			if assert.NoError(t, err) {
+				assert.JSONEq(t, c.expected, string(patched))
+			}
+		})
+	}
+}
+
+func TestRestoreData(t *testing.T) {
+	original := `{"metadata":{"name":"app","labels":{"a":"b"},"finalizers":["a"]},"spec":{"replicas":1,"template":{"spec":{"containers":[{"name":"a","image":"a"},{"name":"b","image":"b"}]}}},"status":{"replicas":1}}`
+	widget := corev1.ObjectReference{APIVersion: "example.com/v1", Kind: "Widget"}
+	deployment := corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment"}
+
+	cases := []struct {
+		desc      string
+		ref       corev1.ObjectReference
+		patchType types.PatchType
+		data      string
+		expected  string
+	}{
+		{
+			desc:      "strategic",
+			ref:       widget,
+			patchType: types.StrategicMergePatchType,
+			data:      `{"metadata":{"name":"app","labels":{"c":"d"}},"spec":{"template":{"spec":{"containers":[{"name":"a","image":"b"}]}}}}`,
+			expected:  `{"metadata":{"labels":{"c":null}},"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a"},{"name":"b","image":"b"}]}}}}`,
+		},
+		{
+			desc:      "merge",
+			ref:       widget,
+			patchType: types.MergePatchType,
+			data:      `{"spec":{"replicas":2,"paused":true}}`,
+			expected:  `{"spec":{"replicas":1,"paused":null}}`,
+		},
+		{
+			desc:      "json",
+			ref:       widget,
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"replace","path":"/spec/template/spec/containers/0/image","value":"b"},{"op":"test","path":"/spec/replicas","value":1}]`,
+			expected:  `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a"},{"name":"b","image":"b"}]}}}}`,
+		},
+		{
+			desc:      "strategic built-in",
+			ref:       deployment,
+			patchType: types.StrategicMergePatchType,
+			data:      `{"metadata":{"finalizers":["b"]},"spec":{"template":{"spec":{"containers":[{"name":"a","image":"b","args":["x"]},{"name":"c","image":"c"}]}}}}`,
+			expected:  `{"metadata":{"finalizers":["a"],"$deleteFromPrimitiveList/finalizers":["b"]},"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a","args":null},{"name":"c","$patch":"delete"}]}}}}`,
+		},
+		{
+			desc:      "merge built-in",
+			ref:       deployment,
+			patchType: types.MergePatchType,
+			data:      `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"b"}]}}}}`,
+			expected:  `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a"},{"name":"b","image":"b"},{"$patch":"replace"}]}}}}`,
+		},
+		{
+			desc:      "json built-in",
+			ref:       deployment,
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"add","path":"/spec/template/spec/containers/0","value":{"name":"c","image":"c"}},{"op":"replace","path":"/spec/template/spec/containers/2/image","value":"c"},{"op":"remove","path":"/spec/template/spec/containers/1"}]`,
+			expected:  `{"spec":{"template":{"spec":{"containers":[{"name":"c","$patch":"delete"},{"name":"b","image":"b"},{"name":"a","image":"a"}]}}}}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			po := &redskyv1alpha1.PatchOperation{TargetRef: c.ref, PatchType: c.patchType, Data: []byte(c.data)}
+			data, err := RestoreData([]byte(original), po)
+			if assert.NoError(t, err) {
+				assert.JSONEq(t, c.expected, string(data))
+			}
+		})
+	}
+}
+
+func TestRestoreData_KeepsLaterChanges(t *testing.T) {
+	original := `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a"},{"name":"b","image":"b"}]}}}}`
+	later := `{"spec":{"template":{"spec":{"containers":[{"name":"b","image":"d"},{"name":"e","image":"e"}]}}}}`
+	expected := `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a"},{"name":"b","image":"d"},{"name":"e","image":"e"}]}}}}`
+
+	cases := []struct {
+		desc      string
+		patchType types.PatchType
+		data      string
+	}{
+		{
+			desc:      "strategic",
+			patchType: types.StrategicMergePatchType,
+			data:      `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"c","args":["x"]},{"name":"c","image":"c"}]}}}}`,
+		},
+		{
+			desc:      "json",
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"replace","path":"/spec/template/spec/containers/0/image","value":"c"},{"op":"add","path":"/spec/template/spec/containers/-","value":{"name":"c","image":"c"}}]`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			po := &redskyv1alpha1.PatchOperation{
+				TargetRef: corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment"},
+				PatchType: c.patchType,
+				Data:      []byte(c.data),
+			}
+			restore, err := RestoreData([]byte(original), po)
+			if !assert.NoError(t, err) {
+				return
+			}
+			patched, err := Apply([]byte(original), po, &appsv1.Deployment{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			changed, err := Apply(patched, &redskyv1alpha1.PatchOperation{PatchType: types.StrategicMergePatchType, Data: []byte(later)}, &appsv1.Deployment{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			restored, err := Apply(changed, &redskyv1alpha1.PatchOperation{PatchType: RestorePatchType(&po.TargetRef), Data: restore}, &appsv1.Deployment{})
+			if assert.NoError(t, err) {
+				assert.JSONEq(t, expected, string(restored))
+			}
+		})
+	}
+}
+
+func TestPointerPath(t *testing.T) {
+	assert.Equal(t, []string{"spec", "template", "spec", "containers", "0", "image"}, pointerPath("/spec/template/spec/containers/0/image"))
+	assert.Equal(t, []string{"metadata", "annotations", "redskyops.dev/a~b"}, pointerPath("/metadata/annotations/redskyops.dev~1a~0b"))
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"encoding/json"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// RestoreFinalizer is a finalizer that indicates the original state of the patched objects has not been restored
+const RestoreFinalizer = "restoreFinalizer.redskyops.dev"
+
+// RestoreConfigMapName returns the name of the config map used to store the original state of the objects patched
+// by the trials of an experiment
+func RestoreConfigMapName(experimentName string) string {
+	return experimentName + "-restore"
+}
+
+// RestoreKey returns the config map key used to store the original state of a patch target
+func RestoreKey(ref *corev1.ObjectReference) string {
+	gvk := ref.GroupVersionKind()
+	return strings.Join([]string{gvk.Group, gvk.Kind, ref.Namespace, ref.Name}, "_")
+}
+
+// RestorePatchType returns the type of the patches used to restore the referenced object, strategic merge patches are
+// used for built-in kinds so list elements can be restored individually
+func RestorePatchType(ref *corev1.ObjectReference) types.PatchType {
+	if scheme.Scheme.Recognizes(ref.GroupVersionKind()) {
+		return types.StrategicMergePatchType
+	}
+	return types.MergePatchType
+}
+
+// MergeRestoreData combines two restore patches of the referenced object, the values of the existing patch always
+// take precedence so the first recorded state of a field is the one that is restored
+func MergeRestoreData(existing, restore []byte, ref *corev1.ObjectReference) ([]byte, error) {
+	e := make(map[string]interface{})
+	if err := json.Unmarshal(existing, &e); err != nil {
+		return nil, err
+	}
+	r := make(map[string]interface{})
+	if err := json.Unmarshal(restore, &r); err != nil {
+		return nil, err
+	}
+	m, err := restoreMerge(ref)
+	if err != nil {
+		return nil, err
+	}
+	mergeRestoreValues(e, r, m)
+	return json.Marshal(e)
+}
+
+// restoreMerge returns the list merge of the restore patches for the referenced object
+func restoreMerge(ref *corev1.ObjectReference) (*listMerge, error) {
+	obj, err := scheme.Scheme.New(ref.GroupVersionKind())
+	if err != nil {
+		// Restore patches for other kinds are merge patches which always replace lists
+		return &listMerge{}, nil
+	}
+	meta, err := strategicpatch.NewPatchMetaFromStruct(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &listMerge{merge: true, meta: meta}, nil
+}
+
+// mergeRestoreValues adds the values which are not already present
+func mergeRestoreValues(existing, restore map[string]interface{}, m *listMerge) {
+	for k, v := range restore {
+		ev, ok := existing[k]
+		if !ok {
+			existing[k] = v
+			continue
+		}
+
+		if strings.HasPrefix(k, deleteFromPrimitiveListDirective) {
+			// Values added after either state was recorded must be removed
+			el, _ := ev.([]interface{})
+			rl, _ := v.([]interface{})
+			for _, rv := range rl {
+				if !containsValue(el, rv) {
+					el = append(el, rv)
+				}
+			}
+			existing[k] = el
+			continue
+		}
+
+		switch e := ev.(type) {
+		case map[string]interface{}:
+			if r, ok := v.(map[string]interface{}); ok && e["$patch"] == nil {
+				mergeRestoreValues(e, r, m.field(k, e))
+			}
+		case []interface{}:
+			if r, ok := v.([]interface{}); ok {
+				existing[k] = mergeRestoreLists(e, r, m.field(k, e))
+			}
+		}
+	}
+}
+
+// mergeRestoreLists adds the elements which are not already present to a list whose elements are restored individually
+func mergeRestoreLists(existing, restore []interface{}, m *listMerge) []interface{} {
+	key, merged, err := m.mergeKey(existing)
+	if err != nil || !merged || key == "" || replacesList(existing) || replacesList(restore) {
+		return existing
+	}
+	for _, re := range restore {
+		rm, ok := re.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if em, ok := keyedElement(existing, key, rm[key]).(map[string]interface{}); ok {
+			if em["$patch"] == nil && rm["$patch"] == nil {
+				mergeRestoreValues(em, rm, m.element())
+			}
+			continue
+		}
+		existing = append(existing, re)
+	}
+	return existing
+}
+
+// deleteFromPrimitiveListDirective is the prefix of the strategic merge patch directive for removing list values
+const deleteFromPrimitiveListDirective = "$deleteFromPrimitiveList/"
+
+// restoreValue returns the value of the restore patch for a value of the patch data; the original value is the value
+// at the same location in the original object, found indicates if the original object has a value at that location
+func restoreValue(patch, original interface{}, found bool, path []string, m *listMerge) interface{} {
+	switch p := patch.(type) {
+	case map[string]interface{}:
+		om, ok := original.(map[string]interface{})
+		if found && !ok {
+			return original
+		}
+		if _, ok := p["$patch"]; ok {
+			// The entire map was replaced or deleted
+			return replacedValue(p, original, found, m.meta != nil)
+		}
+
+		r := make(map[string]interface{}, len(p))
+		for k, pv := range p {
+			if ignoredField(path, k) {
+				continue
+			}
+			ov, ok := om[k]
+			f := m.field(k, pv)
+			r[k] = restoreValue(pv, ov, ok, append(append([]string{}, path...), k), f)
+
+			// Values added to a merged list of primitive values must be explicitly removed
+			pl, isList := pv.([]interface{})
+			ol, wasList := ov.([]interface{})
+			if isList && wasList && f.strategic() && !hasMaps(pl) {
+				var added []interface{}
+				for _, pe := range pl {
+					if !containsValue(ol, pe) {
+						added = append(added, pe)
+					}
+				}
+				if len(added) > 0 {
+					r[deleteFromPrimitiveListDirective+k] = added
+				}
+			}
+		}
+		return r
+
+	case []interface{}:
+		key, merged, err := m.mergeKey(p)
+		if err != nil || !merged || key == "" || replacesList(p) {
+			return replacedValue(p, original, found, m.strategic())
+		}
+
+		ol, _ := original.([]interface{})
+		r := make([]interface{}, 0, len(p))
+		for _, pe := range p {
+			em, ok := pe.(map[string]interface{})
+			if !ok || em[key] == nil {
+				continue
+			}
+			oe, _ := keyedElement(ol, key, em[key]).(map[string]interface{})
+			_, directive := em["$patch"]
+			switch {
+			case oe == nil && !directive:
+				// The element was added by the patch
+				r = append(r, map[string]interface{}{key: em[key], "$patch": "delete"})
+			case oe != nil && directive:
+				// The element was removed by the patch
+				r = append(r, oe)
+			case oe != nil:
+				re := restoreValue(em, oe, true, path, m.element()).(map[string]interface{})
+				re[key] = oe[key]
+				r = append(r, re)
+			}
+		}
+		return r
+
+	default:
+		if !found {
+			return nil
+		}
+		return original
+	}
+}
+
+// replacedValue returns the value of the restore patch for a value that was replaced in its entirety; when the value
+// would otherwise be merged by a strategic merge patch, a directive is included to replace the current value
+func replacedValue(patch, original interface{}, found, strategic bool) interface{} {
+	if !found {
+		return nil
+	}
+	if !strategic {
+		return original
+	}
+
+	switch o := original.(type) {
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(o)+1)
+		for k, v := range o {
+			r[k] = v
+		}
+		r["$patch"] = "replace"
+		return r
+	case []interface{}:
+		if pl, _ := patch.([]interface{}); hasMaps(o) || hasMaps(pl) {
+			return append(append(make([]interface{}, 0, len(o)+1), o...), map[string]interface{}{"$patch": "replace"})
+		}
+	}
+	return original
+}
+
+// replacesList checks if a list includes the strategic merge patch directive to replace the entire list
+func replacesList(list []interface{}) bool {
+	for _, e := range list {
+		if m, ok := e.(map[string]interface{}); ok && m["$patch"] == "replace" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMaps checks if any of the elements of a list is a map
+func hasMaps(list []interface{}) bool {
+	for _, e := range list {
+		if _, ok := e.(map[string]interface{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// containsValue checks if a list of primitive values contains the supplied value
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, e := range list {
+		if sameValue(e, value) {
+			return true
+		}
+	}
+	return false
+}
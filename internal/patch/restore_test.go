@@ -0,0 +1,99 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRestoreKey(t *testing.T) {
+	assert.Equal(t, "apps_Deployment_default_app", RestoreKey(&corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "app"}))
+	assert.Equal(t, "_ConfigMap_default_app.config", RestoreKey(&corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "app.config"}))
+}
+
+func TestRestorePatchType(t *testing.T) {
+	assert.Equal(t, types.StrategicMergePatchType, RestorePatchType(&corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment"}))
+	assert.Equal(t, types.MergePatchType, RestorePatchType(&corev1.ObjectReference{APIVersion: "example.com/v1", Kind: "Widget"}))
+}
+
+func TestMergeRestoreData(t *testing.T) {
+	widget := corev1.ObjectReference{APIVersion: "example.com/v1", Kind: "Widget"}
+	deployment := corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment"}
+
+	cases := []struct {
+		desc     string
+		ref      corev1.ObjectReference
+		existing string
+		restore  string
+		expected string
+	}{
+		{
+			desc:     "disjoint",
+			ref:      widget,
+			existing: `{"spec":{"replicas":1}}`,
+			restore:  `{"spec":{"paused":null}}`,
+			expected: `{"spec":{"replicas":1,"paused":null}}`,
+		},
+		{
+			desc:     "existing wins",
+			ref:      widget,
+			existing: `{"spec":{"replicas":1}}`,
+			restore:  `{"spec":{"replicas":2}}`,
+			expected: `{"spec":{"replicas":1}}`,
+		},
+		{
+			desc:     "existing removal wins",
+			ref:      widget,
+			existing: `{"metadata":{"labels":{"c":null}}}`,
+			restore:  `{"metadata":{"labels":{"c":"d","e":"f"}}}`,
+			expected: `{"metadata":{"labels":{"c":null,"e":"f"}}}`,
+		},
+		{
+			desc:     "existing list wins",
+			ref:      widget,
+			existing: `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a"}]}}}}`,
+			restore:  `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"b"}]}}}}`,
+			expected: `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a"}]}}}}`,
+		},
+		{
+			desc:     "merged elements",
+			ref:      deployment,
+			existing: `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a"},{"name":"c","$patch":"delete"}]}}}}`,
+			restore:  `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"b","args":null},{"name":"b","image":"b"},{"name":"c","image":"c"}]}}}}`,
+			expected: `{"spec":{"template":{"spec":{"containers":[{"name":"a","image":"a","args":null},{"name":"c","$patch":"delete"},{"name":"b","image":"b"}]}}}}`,
+		},
+		{
+			desc:     "removed primitive values",
+			ref:      deployment,
+			existing: `{"metadata":{"finalizers":["a"],"$deleteFromPrimitiveList/finalizers":["b"]}}`,
+			restore:  `{"metadata":{"finalizers":["a","b"],"$deleteFromPrimitiveList/finalizers":["c"]}}`,
+			expected: `{"metadata":{"finalizers":["a"],"$deleteFromPrimitiveList/finalizers":["b","c"]}}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			data, err := MergeRestoreData([]byte(c.existing), []byte(c.restore), &c.ref)
+			if assert.NoError(t, err) {
+				assert.JSONEq(t, c.expected, string(data))
+			}
+		})
+	}
+}
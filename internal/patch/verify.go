@@ -0,0 +1,335 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// Changed returns the fields set by the indexed patch operation which no longer hold the patched values in the
+// supplied (JSON encoded) object, e.g. because an admission controller mutated the object after it was patched; fields
+// overwritten by subsequent patch operations against the same object are not considered. The data structure should be
+// an instance of the Go type represented by the JSON (if known), it is used to determine how lists are merged.
+func Changed(pos []redskyv1alpha1.PatchOperation, i int, object []byte, dataStruct interface{}) ([]string, error) {
+	po := &pos[i]
+	actual := make(map[string]interface{})
+	if err := json.Unmarshal(object, &actual); err != nil {
+		return nil, err
+	}
+
+	var changed [][]string
+	switch po.PatchType {
+	case types.StrategicMergePatchType, types.MergePatchType, types.ApplyPatchType:
+		expected := make(map[string]interface{})
+		if err := json.Unmarshal(po.Data, &expected); err != nil {
+			return nil, err
+		}
+		m := &listMerge{merge: po.PatchType != types.MergePatchType}
+		if m.merge && dataStruct != nil {
+			meta, err := strategicpatch.NewPatchMetaFromStruct(dataStruct)
+			if err != nil {
+				return nil, err
+			}
+			m.meta = meta
+		}
+		changedFields(expected, actual, nil, m, &changed)
+
+	case types.JSONPatchType:
+		var ops []struct {
+			Op    string      `json:"op"`
+			Path  string      `json:"path"`
+			Value interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(po.Data, &ops); err != nil {
+			return nil, err
+		}
+		for _, op := range ops {
+			// Only check operations whose result is known from the patch alone
+			if (op.Op != "add" && op.Op != "replace" && op.Op != "remove") || strings.HasSuffix(op.Path, "/-") {
+				continue
+			}
+			path := pointerPath(op.Path)
+			v, ok := pointerValue(actual, path)
+			if op.Op == "remove" {
+				if ok {
+					changed = append(changed, path)
+				}
+				continue
+			}
+			if !ok {
+				changed = append(changed, path)
+				continue
+			}
+			changedFields(op.Value, v, path, &listMerge{}, &changed)
+		}
+
+	default:
+		return nil, fmt.Errorf("unable to verify patch type: %s", po.PatchType)
+	}
+
+	var later [][]string
+	for j := i + 1; j < len(pos); j++ {
+		if sameTarget(po, &pos[j]) {
+			paths, err := fieldPaths(&pos[j])
+			if err != nil {
+				return nil, err
+			}
+			later = append(later, paths...)
+		}
+	}
+
+	var fields []string
+	for _, path := range changed {
+		if _, ok := overlappingPath([][]string{path}, later); !ok {
+			fields = append(fields, pathString(path))
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// listMerge describes how the lists of a patch are combined with the lists of the patched object
+type listMerge struct {
+	// merge is true if the lists of the patch may be merged into the existing lists instead of replacing them
+	merge bool
+	// meta is the patch metadata of the current value, nil if the Go type of the patched object is not known
+	meta strategicpatch.LookupPatchMeta
+	// strategies and key are the patch strategies and merge key of the current list, only known if meta is not nil
+	strategies []string
+	key        string
+}
+
+// field returns the list merge for the named field of the current value
+func (m *listMerge) field(name string, value interface{}) *listMerge {
+	f := &listMerge{merge: m.merge}
+	if m.meta == nil {
+		return f
+	}
+
+	var pm strategicpatch.PatchMeta
+	var err error
+	switch value.(type) {
+	case map[string]interface{}:
+		f.meta, pm, err = m.meta.LookupPatchMetadataForStruct(name)
+	case []interface{}:
+		f.meta, pm, err = m.meta.LookupPatchMetadataForSlice(name)
+	default:
+		return f
+	}
+	if err != nil {
+		// The field is not part of the Go type, treat it like an unknown type
+		return &listMerge{merge: m.merge}
+	}
+	f.strategies = pm.GetPatchStrategies()
+	f.key = pm.GetPatchMergeKey()
+	return f
+}
+
+// element returns the list merge for the elements of the current list
+func (m *listMerge) element() *listMerge {
+	return &listMerge{merge: m.merge, meta: m.meta}
+}
+
+// mergeKey returns the key used to match the elements of a list which is merged, false is returned if the list is
+// replaced and an empty key is returned for lists of primitive values which are merged. If the Go type of the patched
+// object is not known, only lists of named elements can be matched: an error is returned for other lists.
+func (m *listMerge) mergeKey(list []interface{}) (string, bool, error) {
+	switch {
+	case !m.merge:
+		return "", false, nil
+	case m.meta != nil:
+		if m.strategic() {
+			return m.key, true, nil
+		}
+		return "", false, nil
+	case namedElements(list):
+		return "name", true, nil
+	default:
+		return "", false, fmt.Errorf("unknown merge key")
+	}
+}
+
+// strategic checks if the patch metadata of the current list specifies it is merged by strategic merge patches
+func (m *listMerge) strategic() bool {
+	if m.meta == nil {
+		return false
+	}
+	for _, s := range m.strategies {
+		if s == "merge" {
+			return true
+		}
+	}
+	return false
+}
+
+// changedFields collects the paths of the patched values which are not present in the actual value; fields which
+// are present in the actual value but not in the patch (e.g. defaulted fields) are ignored
+func changedFields(expected, actual interface{}, path []string, m *listMerge, changed *[][]string) {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			*changed = append(*changed, path)
+			return
+		}
+		for k, ev := range e {
+			if ignoredField(path, k) {
+				continue
+			}
+			p := append(append([]string{}, path...), k)
+			av, ok := a[k]
+			if ev == nil {
+				// A null value removes the field
+				if ok && av != nil {
+					*changed = append(*changed, p)
+				}
+				continue
+			}
+			if !ok {
+				*changed = append(*changed, p)
+				continue
+			}
+			changedFields(ev, av, p, m.field(k, ev), changed)
+		}
+
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			*changed = append(*changed, path)
+			return
+		}
+		key, merged, err := m.mergeKey(e)
+		if err != nil {
+			// Without knowing how the elements are matched, the merged list cannot be verified
+			return
+		}
+		if merged && key != "" {
+			for _, ee := range e {
+				em, ok := ee.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if _, ok := em["$patch"]; ok {
+					// Ignore elements using strategic merge patch directives
+					continue
+				}
+				p := append(append([]string{}, path...), fmt.Sprintf("[%s=%v]", key, em[key]))
+				if ae := keyedElement(a, key, em[key]); ae != nil {
+					changedFields(ee, ae, p, m.element(), changed)
+				} else {
+					*changed = append(*changed, p)
+				}
+			}
+			return
+		}
+		if merged {
+			// Merged lists of primitive values only need to contain the patched values
+			for _, ee := range e {
+				found := false
+				for _, ae := range a {
+					found = found || sameValue(ee, ae)
+				}
+				if !found {
+					*changed = append(*changed, path)
+					return
+				}
+			}
+			return
+		}
+		if len(e) != len(a) {
+			*changed = append(*changed, path)
+			return
+		}
+		var c [][]string
+		for i := range e {
+			changedFields(e[i], a[i], append(append([]string{}, path...), strconv.Itoa(i)), m.element(), &c)
+		}
+		if len(c) > 0 {
+			// Report the list as a whole rather then the individual elements
+			*changed = append(*changed, path)
+		}
+
+	default:
+		if !sameValue(expected, actual) {
+			*changed = append(*changed, path)
+		}
+	}
+}
+
+// keyedElement returns the element of a list with the supplied merge key value
+func keyedElement(list []interface{}, key string, value interface{}) interface{} {
+	for _, e := range list {
+		if m, ok := e.(map[string]interface{}); ok && sameValue(m[key], value) {
+			return m
+		}
+	}
+	return nil
+}
+
+// pointerValue returns the value at the supplied (decoded) JSON pointer path
+func pointerValue(obj interface{}, path []string) (interface{}, bool) {
+	for _, token := range path {
+		switch o := obj.(type) {
+		case map[string]interface{}:
+			v, ok := o[token]
+			if !ok {
+				return nil, false
+			}
+			obj = v
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(o) {
+				return nil, false
+			}
+			obj = o[i]
+		default:
+			return nil, false
+		}
+	}
+	return obj, true
+}
+
+// sameValue compares scalar values, allowing for the canonical forms the API server uses for quantities and for
+// integers supplied as strings
+func sameValue(expected, actual interface{}) bool {
+	if reflect.DeepEqual(expected, actual) {
+		return true
+	}
+	es, as := fmt.Sprint(expected), fmt.Sprint(actual)
+	if es == as {
+		return true
+	}
+	eq, err := resource.ParseQuantity(es)
+	if err != nil {
+		return false
+	}
+	aq, err := resource.ParseQuantity(as)
+	if err != nil {
+		return false
+	}
+	return eq.Cmp(aq) == 0
+}
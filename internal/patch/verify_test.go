@@ -0,0 +1,108 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"testing"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestChanged(t *testing.T) {
+	app := corev1.ObjectReference{Kind: "Deployment", Name: "app", Namespace: "default"}
+	object := []byte(`{"spec":{"replicas":2,"template":{"spec":{"containers":[{"name":"a","image":"a","args":["--verbose"],"ports":[{"containerPort":80},{"containerPort":8080,"name":"admin"}],"resources":{"limits":{"cpu":"1","memory":"512Mi"}}}],"tolerations":[{"key":"a"},{"key":"b"}]}}}}`)
+
+	cases := []struct {
+		desc       string
+		pos        []redskyv1alpha1.PatchOperation
+		dataStruct interface{}
+		expected   []string
+	}{
+		{
+			desc: "unchanged",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"replicas":2,"template":{"spec":{"containers":[{"name":"a","resources":{"limits":{"cpu":"1000m","memory":"0.5Gi"}}}]}}}}`)},
+			},
+		},
+		{
+			desc: "changed",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"replicas":3,"template":{"spec":{"containers":[{"name":"a","resources":{"limits":{"cpu":"2"}}},{"name":"b","image":"b"}]}}}}`)},
+			},
+			expected: []string{
+				"spec.replicas",
+				"spec.template.spec.containers[name=a].resources.limits.cpu",
+				"spec.template.spec.containers[name=b]",
+			},
+		},
+		{
+			desc: "merge key",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"template":{"spec":{"containers":[{"name":"a","ports":[{"containerPort":8080,"name":"admin"},{"containerPort":9090}]}]}}}}`)},
+			},
+			dataStruct: &appsv1.Deployment{},
+			expected:   []string{"spec.template.spec.containers[name=a].ports[containerPort=9090]"},
+		},
+		{
+			desc: "replaced list",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"template":{"spec":{"containers":[{"name":"a","args":["--verbose"]}],"tolerations":[{"key":"b"},{"key":"a"}]}}}}`)},
+			},
+			dataStruct: &appsv1.Deployment{},
+			expected:   []string{"spec.template.spec.tolerations"},
+		},
+		{
+			desc: "unknown merge key",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"template":{"spec":{"containers":[{"name":"a","ports":[{"containerPort":8080}]}],"tolerations":[{"key":"b"}]}}}}`)},
+			},
+		},
+		{
+			desc: "removed",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.MergePatchType, Data: []byte(`{"spec":{"replicas":null,"paused":null}}`)},
+			},
+			expected: []string{"spec.replicas"},
+		},
+		{
+			desc: "json patch",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.JSONPatchType, Data: []byte(`[{"op":"replace","path":"/spec/replicas","value":2},{"op":"add","path":"/spec/template/spec/containers/0/image","value":"b"},{"op":"remove","path":"/spec/paused"}]`)},
+			},
+			expected: []string{"spec.template.spec.containers.0.image"},
+		},
+		{
+			desc: "overwritten",
+			pos: []redskyv1alpha1.PatchOperation{
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"replicas":3}}`)},
+				{TargetRef: app, PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"replicas":2}}`)},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			changed, err := Changed(c.pos, 0, object, c.dataStruct)
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.expected, changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ready
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// fatalEventReasons are the reasons of warning events that indicate a failure which is unlikely to recover
+var fatalEventReasons = map[string]bool{
+	"FailedCreate": true, // e.g. a quota was exceeded or an admission controller rejected the pod
+	"Evicted":      true,
+}
+
+// InvolvedObjectUIDField is the name of the event field selector used to find the events of an object
+const InvolvedObjectUIDField = "involvedObject.uid"
+
+// IsFatalEvent checks to see if the supplied event indicates a failure which is unlikely to recover
+func IsFatalEvent(e *corev1.Event) bool {
+	return e.Type == corev1.EventTypeWarning && fatalEventReasons[e.Reason]
+}
+
+// FatalEventPredicate filters out events which are not fatal, other objects are not filtered
+func FatalEventPredicate() predicate.Predicate {
+	fatal := func(o runtime.Object) bool {
+		if e, ok := o.(*corev1.Event); ok {
+			return IsFatalEvent(e)
+		}
+		return true
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return fatal(e.Object) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return fatal(e.ObjectNew) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return fatal(e.Object) },
+		GenericFunc: func(e event.GenericEvent) bool { return fatal(e.Object) },
+	}
+}
+
+// CheckEvents looks for fatal events that occurred after the supplied time about the object or the pods it owns (e.g.
+// the pods of a job); replica failures reported in the status of the object (e.g. a deployment whose replica set
+// cannot create pods because a quota was exceeded) are also considered fatal
+func (r *ReadinessChecker) CheckEvents(ctx context.Context, obj *unstructured.Unstructured, since time.Time) error {
+	if reason, message, ok := replicaFailure(obj); ok {
+		return &FailureError{Reason: reason, Message: fmt.Sprintf("%s %q: %s", strings.ToLower(obj.GetKind()), obj.GetName(), message)}
+	}
+
+	// Only consider the events of the object and it's pods
+	uids := []types.UID{obj.GetUID()}
+	pods, err := r.listPods(ctx, obj)
+	if err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		uids = append(uids, pods.Items[i].UID)
+	}
+
+	for _, uid := range uids {
+		if uid == "" {
+			continue
+		}
+
+		list := &corev1.EventList{}
+		if err := r.EventReader.List(ctx, list, client.InNamespace(obj.GetNamespace()), client.MatchingFields{InvolvedObjectUIDField: string(uid)}); err != nil {
+			return err
+		}
+		for i := range list.Items {
+			e := &list.Items[i]
+			if e.InvolvedObject.UID != uid || !IsFatalEvent(e) || eventTime(e).Before(since) {
+				continue
+			}
+			msg := fmt.Sprintf("%s %q: %s", strings.ToLower(e.InvolvedObject.Kind), e.InvolvedObject.Name, e.Message)
+			return &FailureError{Reason: e.Reason, Message: msg}
+		}
+	}
+	return nil
+}
+
+// replicaFailure returns the reason and message of the "ReplicaFailure" condition of the supplied object, if it is true
+func replicaFailure(obj *unstructured.Unstructured) (string, string, bool) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cm, ok := c.(map[string]interface{})
+		if !ok || cm["type"] != "ReplicaFailure" || cm["status"] != string(corev1.ConditionTrue) {
+			continue
+		}
+		reason, _ := cm["reason"].(string)
+		message, _ := cm["message"].(string)
+		return reason, message, true
+	}
+	return "", "", false
+}
+
+// EventWatcher watches the warning events of individual namespaces using a dedicated informer for each namespace,
+// events are never added to the shared cache (which would hold every event in the cluster)
+type EventWatcher struct {
+	// Clientset is used to list and watch the events of each namespace
+	Clientset kubernetes.Interface
+	// Watch registers the source of events for a newly watched namespace, e.g. the `Watch` function of a controller
+	Watch func(src source.Source) error
+
+	stop       chan struct{}
+	namespaces map[string]bool
+	mu         sync.Mutex
+}
+
+// NewEventWatcher returns a new event watcher that registers the events of each namespace using the supplied function
+func NewEventWatcher(clientset kubernetes.Interface, watch func(src source.Source) error) *EventWatcher {
+	return &EventWatcher{
+		Clientset:  clientset,
+		Watch:      watch,
+		stop:       make(chan struct{}),
+		namespaces: make(map[string]bool),
+	}
+}
+
+// Start blocks until the supplied channel is closed, stopping the informers of all of the watched namespaces
+func (w *EventWatcher) Start(stop <-chan struct{}) error {
+	<-stop
+	close(w.stop)
+	return nil
+}
+
+// WatchNamespace starts watching the warning events of the supplied namespace if they are not already being watched
+func (w *EventWatcher) WatchNamespace(namespace string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.namespaces[namespace] {
+		return nil
+	}
+
+	informer := w.newInformer(namespace)
+	if err := w.Watch(&source.Informer{Informer: informer}); err != nil {
+		return err
+	}
+	go informer.Run(w.stop)
+
+	w.namespaces[namespace] = true
+	return nil
+}
+
+// newInformer returns an informer for the warning events of a single namespace
+func (w *EventWatcher) newInformer(namespace string) cache.SharedIndexInformer {
+	warnings := fields.OneTermEqualSelector("type", corev1.EventTypeWarning).String()
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = warnings
+				return w.Clientset.CoreV1().Events(namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = warnings
+				return w.Clientset.CoreV1().Events(namespace).Watch(options)
+			},
+		},
+		&corev1.Event{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+// eventTime returns the most recent time associated with an event
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.CreationTimestamp.Time
+	}
+}
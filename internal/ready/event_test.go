@@ -0,0 +1,173 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ready
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+func TestReadinessChecker_CheckEvents(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	now := time.Now()
+	job := &batchv1.Job{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "job-uid"},
+		Spec:       batchv1.JobSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": "test"}}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default", UID: "pod-uid", Labels: map[string]string{"job-name": "test"}},
+	}
+	event := func(name, reason string, lastTimestamp time.Time, involvedObject corev1.ObjectReference) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+			InvolvedObject: involvedObject,
+			Type:           corev1.EventTypeWarning,
+			Reason:         reason,
+			Message:        "exceeded quota",
+			LastTimestamp:  metav1.NewTime(lastTimestamp),
+		}
+	}
+	jobRef := corev1.ObjectReference{APIVersion: "batch/v1", Kind: "Job", Namespace: "default", Name: "test", UID: "job-uid"}
+	podRef := corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "test-pod", UID: "pod-uid"}
+	otherRef := corev1.ObjectReference{APIVersion: "batch/v1", Kind: "Job", Namespace: "default", Name: "other", UID: "other-uid"}
+
+	t.Run("failed-create", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			err := rc.CheckEvents(context.TODO(), u, now.Add(-time.Minute))
+			g.Expect(err).Should(MatchError("FailedCreate"))
+			g.Expect(err.(*FailureError).Message).To(Equal(`job "test": exceeded quota`))
+		},
+		job.DeepCopy(),
+		pod.DeepCopy(),
+		event("test-event", "FailedCreate", now, jobRef),
+	))
+
+	t.Run("evicted-pod", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			err := rc.CheckEvents(context.TODO(), u, now.Add(-time.Minute))
+			g.Expect(err).Should(MatchError("Evicted"))
+			g.Expect(err.(*FailureError).Message).To(HavePrefix(`pod "test-pod"`))
+		},
+		job.DeepCopy(),
+		pod.DeepCopy(),
+		event("test-event", "Evicted", now, podRef),
+	))
+
+	t.Run("other-object", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			err := rc.CheckEvents(context.TODO(), u, now.Add(-time.Minute))
+			g.Expect(err).ShouldNot(HaveOccurred())
+		},
+		job.DeepCopy(),
+		pod.DeepCopy(),
+		event("test-event", "FailedCreate", now, otherRef),
+	))
+
+	t.Run("old-event", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			err := rc.CheckEvents(context.TODO(), u, now.Add(-time.Minute))
+			g.Expect(err).ShouldNot(HaveOccurred())
+		},
+		job.DeepCopy(),
+		pod.DeepCopy(),
+		event("test-event", "FailedCreate", now.Add(-time.Hour), jobRef),
+	))
+
+	t.Run("not-fatal", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			err := rc.CheckEvents(context.TODO(), u, now.Add(-time.Minute))
+			g.Expect(err).ShouldNot(HaveOccurred())
+		},
+		job.DeepCopy(),
+		pod.DeepCopy(),
+		event("test-event", "FailedMount", now, podRef),
+	))
+
+	t.Run("replica-failure", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			err := rc.CheckEvents(context.TODO(), u, now.Add(-time.Minute))
+			g.Expect(err).Should(MatchError("FailedCreate"))
+			g.Expect(err.(*FailureError).Message).To(Equal(`deployment "test": exceeded quota`))
+		},
+		&appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "deployment-uid"},
+			Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}}},
+			Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentReplicaFailure, Status: corev1.ConditionTrue, Reason: "FailedCreate", Message: "exceeded quota"},
+			}},
+		},
+	))
+}
+
+func TestFatalEventPredicate(t *testing.T) {
+	g := NewWithT(t)
+	p := FatalEventPredicate()
+	fatal := &corev1.Event{Type: corev1.EventTypeWarning, Reason: "Evicted"}
+	normal := &corev1.Event{Type: corev1.EventTypeNormal, Reason: "Evicted"}
+	g.Expect(p.Create(event.CreateEvent{Object: fatal, Meta: fatal})).To(BeTrue())
+	g.Expect(p.Create(event.CreateEvent{Object: normal, Meta: normal})).To(BeFalse())
+	g.Expect(p.Update(event.UpdateEvent{ObjectOld: normal, ObjectNew: normal, MetaOld: normal, MetaNew: normal})).To(BeFalse())
+
+	// Other objects are never filtered
+	pod := &corev1.Pod{}
+	g.Expect(p.Create(event.CreateEvent{Object: pod, Meta: pod})).To(BeTrue())
+}
+
+func TestEventWatcher_WatchNamespace(t *testing.T) {
+	g := NewWithT(t)
+
+	var sources []source.Source
+	w := NewEventWatcher(fake.NewSimpleClientset(), func(src source.Source) error {
+		sources = append(sources, src)
+		return nil
+	})
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- w.Start(stop) }()
+
+	// Each namespace is only registered once
+	g.Expect(w.WatchNamespace("default")).To(Succeed())
+	g.Expect(w.WatchNamespace("default")).To(Succeed())
+	g.Expect(w.WatchNamespace("other")).To(Succeed())
+	g.Expect(sources).To(HaveLen(2))
+	g.Expect(sources[0]).To(BeAssignableToTypeOf(&source.Informer{}))
+
+	// The informers are started by the watcher
+	g.Eventually(sources[0].(*source.Informer).Informer.(cache.SharedIndexInformer).HasSynced).Should(BeTrue())
+
+	close(stop)
+	g.Eventually(done).Should(Receive(BeNil()))
+}
@@ -0,0 +1,378 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ready
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Expression is a boolean expression evaluated against the unstructured content of an object. Expressions are a
+// small subset of CEL: field paths (e.g. `status.readyReplicas` or a JSONPath in curly braces), string, number and
+// boolean literals, the comparison operators `==`, `!=`, `<`, `<=`, `>` and `>=`, the logical operators `&&`, `||`
+// and `!` and parenthesis for grouping. An expression consisting of only a field path is true if the matched value is
+// the boolean `true` or the string "True".
+type Expression struct {
+	text string
+	root node
+}
+
+// ParseExpression parses the supplied text into an expression
+func ParseExpression(text string) (*Expression, error) {
+	p := &parser{text: text}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.text) {
+		return nil, p.errorf("unexpected %q", p.text[p.pos:])
+	}
+	return &Expression{text: text, root: n}, nil
+}
+
+// String returns the original text of the expression
+func (e *Expression) String() string {
+	return e.text
+}
+
+// Evaluate returns the result of evaluating the expression against the supplied unstructured content
+func (e *Expression) Evaluate(content map[string]interface{}) (bool, error) {
+	v, err := e.root.eval(content)
+	if err != nil {
+		return false, err
+	}
+	return truthy(v), nil
+}
+
+// node is a single node in the expression tree
+type node interface {
+	eval(content map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) { return n.value, nil }
+
+type pathNode struct{ jp *jsonpath.JSONPath }
+
+func (n *pathNode) eval(content map[string]interface{}) (interface{}, error) {
+	results, err := n.jp.FindResults(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	for _, r := range results {
+		for _, v := range r {
+			values = append(values, v.Interface())
+		}
+	}
+
+	switch len(values) {
+	case 0:
+		return nil, nil
+	case 1:
+		return values[0], nil
+	default:
+		return values, nil
+	}
+}
+
+type notNode struct{ operand node }
+
+func (n *notNode) eval(content map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(content)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(content map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short circuit the logical operators
+	switch n.op {
+	case "&&":
+		if !truthy(l) {
+			return false, nil
+		}
+	case "||":
+		if truthy(l) {
+			return true, nil
+		}
+	}
+
+	r, err := n.right.eval(content)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		return truthy(r), nil
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	}
+
+	// Ordering is only defined for numbers, missing values are never ordered
+	if l == nil || r == nil {
+		return false, nil
+	}
+	lf, lok := toFloat(l)
+	rf, rok := toFloat(r)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot compare %v %s %v", l, n.op, r)
+	}
+	switch n.op {
+	case "<":
+		return lf < rf, nil
+	case "<=":
+		return lf <= rf, nil
+	case ">":
+		return lf > rf, nil
+	case ">=":
+		return lf >= rf, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %s", n.op)
+	}
+}
+
+// parser is a recursive descent parser for expressions
+type parser struct {
+	text string
+	pos  int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid expression %q at %d: %s", p.text, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.text) && unicode.IsSpace(rune(p.text[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume advances past the supplied token if it is next in the input
+func (p *parser) consume(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.text[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	n, err := p.parseAnd()
+	for err == nil && p.consume("||") {
+		var r node
+		if r, err = p.parseAnd(); err == nil {
+			n = &binaryNode{op: "||", left: n, right: r}
+		}
+	}
+	return n, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	n, err := p.parseUnary()
+	for err == nil && p.consume("&&") {
+		var r node
+		if r, err = p.parseUnary(); err == nil {
+			n = &binaryNode{op: "&&", left: n, right: r}
+		}
+	}
+	return n, err
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.skipSpace(); strings.HasPrefix(p.text[p.pos:], "!") && !strings.HasPrefix(p.text[p.pos:], "!=") {
+		p.pos++
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: n}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	// Longer operators must be checked first
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.consume(op) {
+			r, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return &binaryNode{op: op, left: l, right: r}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	p.skipSpace()
+	if p.pos >= len(p.text) {
+		return nil, p.errorf("unexpected end of expression")
+	}
+
+	c := p.text[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("missing closing parenthesis")
+		}
+		return n, nil
+
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(p.text[p.pos+1:], c)
+		if end < 0 {
+			return nil, p.errorf("unterminated string")
+		}
+		s := p.text[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return &literalNode{value: s}, nil
+
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.text) && strings.ContainsRune("0123456789.eE", rune(p.text[p.pos])) {
+			p.pos++
+		}
+		f, err := strconv.ParseFloat(p.text[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.text[start:p.pos])
+		}
+		return &literalNode{value: f}, nil
+
+	case c == '{':
+		// Find the matching closing brace, JSONPath filters may contain nested braces
+		depth, start := 0, p.pos
+		for ; p.pos < len(p.text); p.pos++ {
+			if p.text[p.pos] == '{' {
+				depth++
+			} else if p.text[p.pos] == '}' {
+				if depth--; depth == 0 {
+					p.pos++
+					return p.newPath(p.text[start:p.pos])
+				}
+			}
+		}
+		return nil, p.errorf("unterminated JSONPath")
+
+	case isIdentifier(c):
+		start := p.pos
+		for p.pos < len(p.text) && (isIdentifier(p.text[p.pos]) || strings.ContainsRune(".[]0123456789", rune(p.text[p.pos]))) {
+			p.pos++
+		}
+		path := p.text[start:p.pos]
+		switch path {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		return p.newPath("{." + path + "}")
+	}
+
+	return nil, p.errorf("unexpected %q", c)
+}
+
+func (p *parser) newPath(path string) (node, error) {
+	jp := jsonpath.New(p.text).AllowMissingKeys(true)
+	if err := jp.Parse(path); err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	return &pathNode{jp: jp}, nil
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// truthy returns the boolean interpretation of a value
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		return strings.EqualFold(t, "true")
+	default:
+		return false
+	}
+}
+
+// equal compares two values, numbers are compared numerically regardless of their type
+func equal(l, r interface{}) bool {
+	if lf, ok := toFloat(l); ok {
+		if rf, ok := toFloat(r); ok {
+			return lf == rf
+		}
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+// toFloat attempts to convert a value into a floating point number
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ready
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestExpression_Evaluate(t *testing.T) {
+	g := NewWithT(t)
+
+	content := map[string]interface{}{
+		"metadata": map[string]interface{}{"generation": int64(2)},
+		"spec":     map[string]interface{}{"replicas": int64(3)},
+		"status": map[string]interface{}{
+			"observedGeneration": int64(2),
+			"readyReplicas":      int64(3),
+			"phase":              "Running",
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+				map[string]interface{}{"type": "Degraded", "status": "False"},
+			},
+		},
+	}
+
+	cases := []struct {
+		expression string
+		expected   bool
+	}{
+		{expression: "status.readyReplicas == spec.replicas && status.observedGeneration == metadata.generation", expected: true},
+		{expression: "status.readyReplicas < spec.replicas", expected: false},
+		{expression: "status.readyReplicas >= 3 && status.phase == 'Running'", expected: true},
+		{expression: "!(status.phase != \"Running\")", expected: true},
+		{expression: "status.missing > 1 || status.phase == 'Running'", expected: true},
+		{expression: "status.missing == null", expected: true},
+		{expression: `{.status.conditions[?(@.type=="Ready")].status}`, expected: true},
+		{expression: `{.status.conditions[?(@.type=="Degraded")].status}`, expected: false},
+		{expression: "status.conditions[0].type == 'Ready'", expected: true},
+	}
+	for _, c := range cases {
+		expr, err := ParseExpression(c.expression)
+		g.Expect(err).ShouldNot(HaveOccurred(), c.expression)
+		actual, err := expr.Evaluate(content)
+		g.Expect(err).ShouldNot(HaveOccurred(), c.expression)
+		g.Expect(actual).To(Equal(c.expected), c.expression)
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+	g := NewWithT(t)
+
+	invalid := []string{
+		"",
+		"status.phase ==",
+		"(status.phase == 'Running'",
+		"status.phase == 'Running",
+		"status.phase == 'Running' foo",
+		"{.status.phase",
+	}
+	for _, text := range invalid {
+		_, err := ParseExpression(text)
+		g.Expect(err).Should(HaveOccurred(), text)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ready
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// httpClient is used to evaluate HTTP GET conditions; like the kubelet, certificates are not verified since we are
+// addressing pods and services by IP
+var httpClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+}
+
+// httpGet issues the configured HTTP request against the service or each of the pods of the target object
+func (r *ReadinessChecker) httpGet(ctx context.Context, obj *unstructured.Unstructured) (string, corev1.ConditionStatus, error) {
+	if r.HTTPGet == nil {
+		return "", corev1.ConditionFalse, fmt.Errorf("missing HTTP GET configuration for %s", ConditionTypeHTTPGet)
+	}
+
+	urls, err := r.httpGetURLs(ctx, obj)
+	if err != nil {
+		return "", corev1.ConditionFalse, err
+	}
+	if len(urls) == 0 {
+		return fmt.Sprintf("Waiting for %s %q to have an address", strings.ToLower(obj.GetKind()), obj.GetName()), corev1.ConditionFalse, nil
+	}
+
+	// Every URL must be successful
+	for _, u := range urls {
+		msg, ok, err := r.httpGetOne(ctx, u)
+		if err != nil {
+			return "", corev1.ConditionFalse, err
+		}
+		if !ok {
+			return msg, corev1.ConditionFalse, nil
+		}
+	}
+	return "", corev1.ConditionTrue, nil
+}
+
+// httpGetOne issues a single request, returning an error only if the configuration is invalid
+func (r *ReadinessChecker) httpGetOne(ctx context.Context, url string) (string, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	for _, h := range r.HTTPGet.HTTPHeaders {
+		req.Header.Add(h.Name, h.Value)
+	}
+
+	// Connection failures are expected while the application is starting
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Sprintf("Waiting for %s: %v", url, err), false, nil
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	// Check the response status
+	statusCode := int(r.HTTPGet.StatusCode)
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	if resp.StatusCode != statusCode {
+		return fmt.Sprintf("Waiting for %s: expected status %d, got %d", url, statusCode, resp.StatusCode), false, nil
+	}
+
+	// Check the response body
+	if r.HTTPGet.JSONPath == "" {
+		return "", true, nil
+	}
+	jp := jsonpath.New(ConditionTypeHTTPGet).AllowMissingKeys(true)
+	if err := jp.Parse(r.HTTPGet.JSONPath); err != nil {
+		return "", false, err
+	}
+	var data interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Sprintf("Waiting for %s: %v", url, err), false, nil
+	}
+	values, err := jp.FindResults(data)
+	if err != nil {
+		return fmt.Sprintf("Waiting for %s: %v", url, err), false, nil
+	}
+	for _, v := range values {
+		for i := range v {
+			if r.HTTPGet.Value == "" || fmt.Sprint(v[i].Interface()) == r.HTTPGet.Value {
+				return "", true, nil
+			}
+		}
+	}
+	return fmt.Sprintf("Waiting for %s: response did not match %s", url, r.HTTPGet.JSONPath), false, nil
+}
+
+// httpGetURLs returns the URLs to request for the target object: a service is addressed by it's cluster IP, any other
+// kind of object is addressed through the IPs of it's pods
+func (r *ReadinessChecker) httpGetURLs(ctx context.Context, obj *unstructured.Unstructured) ([]string, error) {
+	s := strings.ToLower(string(r.HTTPGet.Scheme))
+	if s == "" {
+		s = "http"
+	} else if s != "http" && s != "https" {
+		return nil, fmt.Errorf("scheme must be 'HTTP' or 'HTTPS': %s", r.HTTPGet.Scheme)
+	}
+	path := "/" + strings.TrimLeft(r.HTTPGet.Path, "/")
+
+	var urls []string
+	addURL := func(host string, port int) {
+		urls = append(urls, fmt.Sprintf("%s://%s%s", s, net.JoinHostPort(host, strconv.Itoa(port)), path))
+	}
+
+	// Services are addressed directly
+	if obj.GroupVersionKind() == corev1.SchemeGroupVersion.WithKind("Service") {
+		svc := &corev1.Service{}
+		if err := scheme.Scheme.Convert(obj, svc, nil); err != nil {
+			return nil, fmt.Errorf("failed to convert %T to %T: %v", obj, svc, err)
+		}
+		if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+			return nil, fmt.Errorf("service %q does not have a cluster IP", svc.Name)
+		}
+		port := r.HTTPGet.Port.IntValue()
+		for _, sp := range svc.Spec.Ports {
+			if port < 1 && (sp.Name == r.HTTPGet.Port.StrVal || len(svc.Spec.Ports) == 1) {
+				port = int(sp.Port)
+			}
+		}
+		if port < 1 {
+			return nil, fmt.Errorf("service %q has unresolvable port: %s", svc.Name, r.HTTPGet.Port.String())
+		}
+		addURL(svc.Spec.ClusterIP, port)
+		return urls, nil
+	}
+
+	// Everything else is addressed through the pods
+	list, err := r.listPods(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if pod.Status.PodIP == "" {
+			continue
+		}
+		port := r.HTTPGet.Port.IntValue()
+		for _, c := range pod.Spec.Containers {
+			for _, cp := range c.Ports {
+				if port < 1 && cp.Name == r.HTTPGet.Port.StrVal {
+					port = int(cp.ContainerPort)
+				}
+			}
+		}
+		if port < 1 {
+			return nil, fmt.Errorf("pod %q has unresolvable port: %s", pod.Name, r.HTTPGet.Port.String())
+		}
+		addURL(pod.Status.PodIP, port)
+	}
+	return urls, nil
+}
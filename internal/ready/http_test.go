@@ -0,0 +1,115 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ready
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestReadinessChecker_HTTPGet(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			_, _ = w.Write([]byte(`{"status":"UP","checks":[{"name":"db","status":"UP"}]}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	host, p, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(p)
+
+	svc := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: host,
+			Ports:     []corev1.ServicePort{{Name: "http", Port: int32(port)}},
+		},
+	}
+
+	cases := []struct {
+		desc     string
+		httpGet  redskyv1alpha1.HTTPGetCondition
+		expected bool
+	}{
+		{
+			desc:     "status",
+			httpGet:  redskyv1alpha1.HTTPGetCondition{Path: "/healthz", Port: intstr.FromString("http")},
+			expected: true,
+		},
+		{
+			desc:     "unavailable",
+			httpGet:  redskyv1alpha1.HTTPGetCondition{Path: "/", Port: intstr.FromInt(port)},
+			expected: false,
+		},
+		{
+			desc:     "expected-status",
+			httpGet:  redskyv1alpha1.HTTPGetCondition{Path: "/", Port: intstr.FromInt(port), StatusCode: http.StatusServiceUnavailable},
+			expected: true,
+		},
+		{
+			desc:     "json-value",
+			httpGet:  redskyv1alpha1.HTTPGetCondition{Path: "/healthz", Port: intstr.FromString("http"), JSONPath: "{.status}", Value: "UP"},
+			expected: true,
+		},
+		{
+			desc:     "json-filter",
+			httpGet:  redskyv1alpha1.HTTPGetCondition{Path: "/healthz", Port: intstr.FromString("http"), JSONPath: `{.checks[?(@.name=="db")].status}`, Value: "DOWN"},
+			expected: false,
+		},
+		{
+			desc:     "json-missing",
+			httpGet:  redskyv1alpha1.HTTPGetCondition{Path: "/healthz", Port: intstr.FromString("http"), JSONPath: "{.missing}"},
+			expected: false,
+		},
+	}
+	for _, c := range cases {
+		httpGet := c.httpGet
+		expected := c.expected
+		t.Run(c.desc, withReadinessChecker(scheme,
+			func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+				rc.HTTPGet = &httpGet
+				msg, ok, err := rc.CheckConditions(context.TODO(), u, []string{ConditionTypeHTTPGet})
+				g.Expect(err).ShouldNot(HaveOccurred())
+				g.Expect(ok).To(Equal(expected), msg)
+			},
+			svc.DeepCopy(),
+		))
+	}
+}
@@ -21,14 +21,16 @@ import (
 	"fmt"
 	"strings"
 
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/scale/scheme/extensionsv1beta1"
-	"k8s.io/kubectl/pkg/polymorphichelpers"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -41,17 +43,32 @@ const (
 	// ConditionTypeRolloutStatus is a special condition type whose status is determined using the equivalent of a
 	// `kubectl rollout status` call on the target object. This condition will return "True" when evaluated against
 	// an object whose "update strategy" is not "RollingUpdate"; use the "app ready" check to perform a rollout
-	// status that falls back to a pod readiness check in cases where the rollout status cannot be determined.
+	// status that falls back to a pod readiness check in cases where the rollout status cannot be determined. In
+	// addition to the kinds supported by kubectl, replica sets, jobs (and cron jobs), Argo Rollouts and Knative Services
+	// are also supported; jobs are only considered "rolled out" once they complete.
 	ConditionTypeRolloutStatus = "redskyops.dev/rollout-status"
 	// ConditionTypeAppReady is a special condition type that combines the efficiency of the rollout status check,
 	// the compatibility of the pod ready check.
 	ConditionTypeAppReady = "redskyops.dev/app-ready"
+	// ConditionTypeExpressionPrefix is a special condition type prefix; the remainder of the condition type is an
+	// expression which is evaluated against the target object, e.g.
+	// `redskyops.dev/expression=status.readyReplicas == spec.replicas && status.observedGeneration == metadata.generation`.
+	// See `Expression` for a description of the supported syntax.
+	ConditionTypeExpressionPrefix = "redskyops.dev/expression="
+	// ConditionTypeHTTPGet is a special condition type whose status is determined by issuing an HTTP GET request to
+	// the target service (or each of the pods associated with the target object) and checking the response.
+	ConditionTypeHTTPGet = "redskyops.dev/http-get"
 )
 
 // ReadinessChecker is used to check the conditions of runtime objects
 type ReadinessChecker struct {
 	// Reader is used to fetch information about objects related to the object whose conditions are being checked
 	Reader client.Reader
+	// EventReader is used to fetch events, it should read directly from the API server since caching events would
+	// require watching every event in the cluster
+	EventReader client.Reader
+	// HTTPGet is the configuration used to evaluate the HTTP GET condition type
+	HTTPGet *redskyv1alpha1.HTTPGetCondition
 }
 
 // CheckConditions checks to see that all of the listed conditions have a status of true on the specified object. Note
@@ -70,11 +87,17 @@ func (r *ReadinessChecker) CheckConditions(ctx context.Context, obj *unstructure
 		case ConditionTypePodReady:
 			msg, s, err = r.podReady(ctx, obj)
 		case ConditionTypeRolloutStatus:
-			msg, s, err = r.rolloutStatus(obj)
+			msg, s, err = r.rolloutStatus(ctx, obj)
 		case ConditionTypeAppReady:
 			msg, s, err = r.appReady(ctx, obj)
+		case ConditionTypeHTTPGet:
+			msg, s, err = r.httpGet(ctx, obj)
 		default:
-			msg, s, err = r.unstructuredConditionStatus(obj, c)
+			if strings.HasPrefix(c, ConditionTypeExpressionPrefix) {
+				msg, s, err = r.expression(obj, strings.TrimPrefix(c, ConditionTypeExpressionPrefix))
+			} else {
+				msg, s, err = r.unstructuredConditionStatus(obj, c)
+			}
 		}
 
 		// Hard stop
@@ -104,6 +127,23 @@ func (r *ReadinessChecker) alwaysTrue(obj *unstructured.Unstructured) (string, c
 	return "", corev1.ConditionTrue, nil
 }
 
+// expression evaluates an expression against the unstructured contents
+func (r *ReadinessChecker) expression(obj *unstructured.Unstructured, text string) (string, corev1.ConditionStatus, error) {
+	expr, err := ParseExpression(text)
+	if err != nil {
+		return "", corev1.ConditionFalse, err
+	}
+
+	ok, err := expr.Evaluate(obj.UnstructuredContent())
+	if err != nil {
+		return "", corev1.ConditionFalse, err
+	}
+	if !ok {
+		return fmt.Sprintf("Waiting for expression to be true: %s", expr), corev1.ConditionFalse, nil
+	}
+	return "", corev1.ConditionTrue, nil
+}
+
 // unstructuredConditionStatus inspects unstructured contents for the status of a condition
 func (r *ReadinessChecker) unstructuredConditionStatus(obj *unstructured.Unstructured, conditionType string) (string, corev1.ConditionStatus, error) {
 	s, ok := obj.UnstructuredContent()["status"].(map[string]interface{})
@@ -137,10 +177,10 @@ func (r *ReadinessChecker) unstructuredConditionStatus(obj *unstructured.Unstruc
 
 // appReady performs a rollout status check and falls back to a pod ready check
 func (r *ReadinessChecker) appReady(ctx context.Context, obj *unstructured.Unstructured) (string, corev1.ConditionStatus, error) {
-	// Get the kubectl status viewer for the object; ignore errors if we do not recognize the kind
-	if sv, err := polymorphichelpers.StatusViewerFor(obj.GetObjectKind().GroupVersionKind().GroupKind()); err == nil {
+	// Get the status viewer for the object; ignore errors if we do not recognize the kind
+	if sv, err := r.statusViewerFor(obj.GetObjectKind().GroupVersionKind().GroupKind()); err == nil {
 		// This code returns `ok && err != nil` if the object isn't supported, we want to do a pod ready check in that case
-		if msg, ok, err := sv.Status(obj, 0); ok && err == nil {
+		if msg, ok, err := sv.Status(ctx, obj); ok && err == nil {
 			return strings.TrimSpace(msg), corev1.ConditionTrue, nil
 		}
 	}
@@ -149,16 +189,16 @@ func (r *ReadinessChecker) appReady(ctx context.Context, obj *unstructured.Unstr
 	return r.podReady(ctx, obj)
 }
 
-// rolloutStatus uses the kubectl implementation of rollout status to get the status of an object
-func (r *ReadinessChecker) rolloutStatus(obj *unstructured.Unstructured) (string, corev1.ConditionStatus, error) {
-	// Get the kubectl status viewer for the object
-	sv, err := polymorphichelpers.StatusViewerFor(obj.GetObjectKind().GroupVersionKind().GroupKind())
+// rolloutStatus uses the kubectl implementation of rollout status (or an equivalent) to get the status of an object
+func (r *ReadinessChecker) rolloutStatus(ctx context.Context, obj *unstructured.Unstructured) (string, corev1.ConditionStatus, error) {
+	// Get the status viewer for the object
+	sv, err := r.statusViewerFor(obj.GetObjectKind().GroupVersionKind().GroupKind())
 	if err != nil {
 		return "", corev1.ConditionFalse, err
 	}
 
 	// Evaluate the status
-	msg, ok, err := sv.Status(obj, 0)
+	msg, ok, err := sv.Status(ctx, obj)
 	msg = strings.TrimSpace(msg)
 	if ok {
 		return msg, corev1.ConditionTrue, nil
@@ -192,6 +232,19 @@ func (r *ReadinessChecker) podReady(ctx context.Context, obj *unstructured.Unstr
 	return "", corev1.ConditionTrue, nil
 }
 
+// FailureError indicates the checked object has failed in a way that it is unlikely to recover from
+type FailureError struct {
+	// Reason is a brief machine readable explanation of the failure, e.g. "OOMKilled"
+	Reason string
+	// Message is a human readable description of the failure
+	Message string
+}
+
+// Error returns the failure reason
+func (e *FailureError) Error() string {
+	return e.Reason
+}
+
 // podFailed looks for pods that are obviously in a failed state and are unlikely to recover
 func (r *ReadinessChecker) podFailed(ctx context.Context, obj *unstructured.Unstructured) (string, error) {
 	// Get the list of pods for the object
@@ -202,52 +255,92 @@ func (r *ReadinessChecker) podFailed(ctx context.Context, obj *unstructured.Unst
 
 	// Iterate over the pods looking for failures
 	for i := range list.Items {
-		p := &list.Items[i]
-		for _, c := range p.Status.Conditions {
-			// Check for unschedulable pods
-			if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable {
-				return c.Message, fmt.Errorf("%s", c.Reason)
+		if reason, msg := PodFailure(&list.Items[i]); reason != "" {
+			return msg, &FailureError{Reason: reason, Message: msg}
+		}
+	}
+
+	// There are no recognizably failed pods
+	return "", nil
+}
+
+// PodFailure returns the reason and message if the supplied pod is obviously in a failed state and is unlikely to
+// recover, an empty reason is returned for pods that have not failed
+func PodFailure(p *corev1.Pod) (string, string) {
+	// Check for evicted pods
+	if p.Status.Phase == corev1.PodFailed && p.Status.Reason == "Evicted" {
+		return p.Status.Reason, fmt.Sprintf("pod %q was evicted: %s", p.Name, p.Status.Message)
+	}
+
+	// Check for unschedulable pods
+	for _, c := range p.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable {
+			return c.Reason, c.Message
+		}
+	}
+
+	// Check the container status
+	var cs []corev1.ContainerStatus
+	cs = append(cs, p.Status.InitContainerStatuses...)
+	cs = append(cs, p.Status.ContainerStatuses...)
+	for _, cc := range cs {
+		if cc.Ready {
+			continue
+		}
+
+		// A container that was previously OOM killed is going to keep getting killed
+		if t := cc.LastTerminationState.Terminated; t != nil && t.Reason == "OOMKilled" {
+			return t.Reason, fmt.Sprintf("container %q in pod %q was OOM killed", cc.Name, p.Name)
+		}
+
+		switch {
+		case cc.State.Waiting != nil:
+			switch cc.State.Waiting.Reason {
+			case "CrashLoopBackOff":
+				if cc.RestartCount > 0 {
+					return cc.State.Waiting.Reason, cc.State.Waiting.Message
+				}
+			case "ImagePullBackOff", "ErrImageNeverPull", "InvalidImageName", "CreateContainerConfigError":
+				return cc.State.Waiting.Reason, fmt.Sprintf("container %q in pod %q: %s", cc.Name, p.Name, cc.State.Waiting.Message)
 			}
 
-			// Check the container status
-			var cs []corev1.ContainerStatus
-			cs = append(cs, p.Status.InitContainerStatuses...)
-			cs = append(cs, p.Status.ContainerStatuses...)
-			for _, cc := range cs {
-				if !cc.Ready {
-					switch {
-					case cc.State.Waiting != nil:
-						if cc.RestartCount > 0 && cc.State.Waiting.Reason == "CrashLoopBackOff" {
-							return cc.State.Waiting.Message, fmt.Errorf("%s", cc.State.Waiting.Reason)
-						}
-
-					case cc.State.Terminated != nil:
-						if p.Spec.RestartPolicy == corev1.RestartPolicyNever && cc.RestartCount == 0 && cc.State.Terminated.Reason == "Error" {
-							return cc.State.Terminated.Message, fmt.Errorf("%s", cc.State.Terminated.Reason)
-						}
-					}
+		case cc.State.Terminated != nil:
+			switch cc.State.Terminated.Reason {
+			case "OOMKilled":
+				return cc.State.Terminated.Reason, fmt.Sprintf("container %q in pod %q was OOM killed", cc.Name, p.Name)
+			case "Error":
+				if p.Spec.RestartPolicy == corev1.RestartPolicyNever && cc.RestartCount == 0 {
+					return cc.State.Terminated.Reason, cc.State.Terminated.Message
 				}
 			}
 		}
 	}
 
-	// There are no recognizably failed pods
-	return "", nil
+	// There is no recognizable failure
+	return "", ""
 }
 
 // listPods returns the pods "owned" by the supplied unstructured object
 func (r *ReadinessChecker) listPods(ctx context.Context, obj *unstructured.Unstructured) (*corev1.PodList, error) {
+	// Cron jobs do not select pods directly, we need to go through the jobs
+	if obj.GetObjectKind().GroupVersionKind().GroupKind() == cronJobGroupKind {
+		return r.listCronJobPods(ctx, obj)
+	}
+
 	// Get the pod selector
 	sel, err := podSelector(obj)
 	if err != nil {
 		return nil, err
 	}
 
+	// If we could not determine a selector, look for pods that are owned by the object instead
+	if sel == nil {
+		return r.listOwnedPods(ctx, obj)
+	}
+
 	// Get the list of pods
 	list := &corev1.PodList{}
-	if sel != nil {
-		err = r.Reader.List(ctx, list, client.InNamespace(obj.GetNamespace()), client.MatchingLabelsSelector{Selector: sel})
-	}
+	err = r.Reader.List(ctx, list, client.InNamespace(obj.GetNamespace()), client.MatchingLabelsSelector{Selector: sel})
 	return list, err
 }
 
@@ -286,6 +379,47 @@ func podSelector(obj *unstructured.Unstructured) (labels.Selector, error) {
 		}
 		ls = sts.Spec.Selector
 
+	case extensionsv1beta1.SchemeGroupVersion.WithKind("ReplicaSet").GroupKind(),
+		appsv1.SchemeGroupVersion.WithKind("ReplicaSet").GroupKind():
+
+		rs := &appsv1.ReplicaSet{}
+		if err := scheme.Scheme.Convert(obj, rs, nil); err != nil {
+			return nil, fmt.Errorf("failed to convert %T to %T: %v", obj, rs, err)
+		}
+		ls = rs.Spec.Selector
+
+	case corev1.SchemeGroupVersion.WithKind("ReplicationController").GroupKind():
+
+		rc := &corev1.ReplicationController{}
+		if err := scheme.Scheme.Convert(obj, rc, nil); err != nil {
+			return nil, fmt.Errorf("failed to convert %T to %T: %v", obj, rc, err)
+		}
+		return labels.SelectorFromSet(rc.Spec.Selector), nil
+
+	case batchv1.SchemeGroupVersion.WithKind("Job").GroupKind():
+
+		job := &batchv1.Job{}
+		if err := scheme.Scheme.Convert(obj, job, nil); err != nil {
+			return nil, fmt.Errorf("failed to convert %T to %T: %v", obj, job, err)
+		}
+		ls = job.Spec.Selector
+
+	case argoRolloutGroupKind:
+
+		m, ok, err := unstructured.NestedMap(obj.Object, "spec", "selector")
+		if err != nil || !ok {
+			return nil, err
+		}
+		ls = &metav1.LabelSelector{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, ls); err != nil {
+			return nil, fmt.Errorf("failed to convert rollout selector: %v", err)
+		}
+
+	case knativeServiceGroupKind:
+
+		// Knative labels all of the pods for a service (regardless of the revision)
+		return labels.SelectorFromSet(map[string]string{knativeServiceLabel: obj.GetName()}), nil
+
 	default:
 		// Return a nil selector (which is not the same as leaving `ls == nil`)
 		return nil, nil
@@ -22,10 +22,12 @@ import (
 
 	. "github.com/onsi/gomega"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -91,6 +93,187 @@ func TestReadinessChecker_CheckConditions(t *testing.T) {
 			},
 		},
 	))
+
+	t.Run("oom-killed", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			msg, _, err := rc.CheckConditions(context.TODO(), u, []string{ConditionTypePodReady})
+			g.Expect(err).Should(MatchError("OOMKilled"))
+			g.Expect(msg).To(ContainSubstring("OOM killed"))
+		},
+		&appsv1.Deployment{
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"test": "test"},
+				},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"test": "test"}},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:                 "app",
+					RestartCount:         1,
+					State:                corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+					LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled"}},
+				}},
+			},
+		},
+	))
+
+	t.Run("image-pull", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			_, _, err := rc.CheckConditions(context.TODO(), u, []string{ConditionTypePodReady})
+			g.Expect(err).Should(MatchError("ImagePullBackOff"))
+		},
+		&appsv1.Deployment{
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"test": "test"},
+				},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"test": "test"}},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "app",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+				}},
+			},
+		},
+	))
+
+	t.Run("replica-set", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			msg, ok, err := rc.CheckConditions(context.TODO(), u, []string{ConditionTypeAppReady})
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ok).To(BeFalse())
+			g.Expect(msg).To(Equal("not ready"))
+		},
+		&appsv1.ReplicaSet{
+			Spec: appsv1.ReplicaSetSpec{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"test": "test"},
+				},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"test": "test"}},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{
+					Type:    corev1.PodReady,
+					Status:  corev1.ConditionFalse,
+					Message: "not ready",
+				}},
+			},
+		},
+	))
+
+	t.Run("job-complete", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			_, ok, err := rc.CheckConditions(context.TODO(), u, []string{ConditionTypeRolloutStatus})
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ok).To(BeTrue())
+		},
+		&batchv1.Job{
+			Status: batchv1.JobStatus{
+				Conditions: []batchv1.JobCondition{{
+					Type:   batchv1.JobComplete,
+					Status: corev1.ConditionTrue,
+				}},
+			},
+		},
+	))
+
+	t.Run("job-failed", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			_, _, err := rc.CheckConditions(context.TODO(), u, []string{ConditionTypeRolloutStatus})
+			g.Expect(err).Should(MatchError("BackoffLimitExceeded"))
+		},
+		&batchv1.Job{
+			Status: batchv1.JobStatus{
+				Conditions: []batchv1.JobCondition{{
+					Type:   batchv1.JobFailed,
+					Status: corev1.ConditionTrue,
+					Reason: "BackoffLimitExceeded",
+				}},
+			},
+		},
+	))
+
+	t.Run("knative-service", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			_, ok, err := rc.CheckConditions(context.TODO(), u, []string{ConditionTypeRolloutStatus})
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ok).To(BeTrue())
+		},
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "serving.knative.dev/v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "test", "generation": int64(1)},
+			"status": map[string]interface{}{
+				"observedGeneration": int64(1),
+				"conditions":         []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}},
+			},
+		}},
+	))
+
+	t.Run("expression", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			msg, ok, err := rc.CheckConditions(context.TODO(), u, []string{ConditionTypeExpressionPrefix + "status.readyReplicas == spec.replicas"})
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ok).To(BeFalse())
+			g.Expect(msg).To(ContainSubstring("status.readyReplicas == spec.replicas"))
+		},
+		&appsv1.StatefulSet{
+			Spec:   appsv1.StatefulSetSpec{Replicas: new(int32)},
+			Status: appsv1.StatefulSetStatus{ReadyReplicas: 1},
+		},
+	))
+
+	t.Run("owner-references", withReadinessChecker(scheme,
+		func(g *WithT, rc *ReadinessChecker, u *unstructured.Unstructured) {
+			msg, ok, err := rc.CheckConditions(context.TODO(), u, []string{ConditionTypePodReady})
+			g.Expect(err).ShouldNot(HaveOccurred())
+			g.Expect(ok).To(BeFalse())
+			g.Expect(msg).To(Equal("owned"))
+		},
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "example.com/v1",
+			"kind":       "Example",
+			"metadata":   map[string]interface{}{"name": "test", "uid": "example-uid"},
+		}},
+		&appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "test-rs",
+				UID:             "rs-uid",
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "example.com/v1", Kind: "Example", Name: "test", UID: "example-uid"}},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "test-owned",
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "test-rs", UID: types.UID("rs-uid")}},
+			},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{
+					Type:    corev1.PodReady,
+					Status:  corev1.ConditionFalse,
+					Message: "owned",
+				}},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-not-owned"},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{
+					Type:    corev1.PodReady,
+					Status:  corev1.ConditionFalse,
+					Message: "not owned",
+				}},
+			},
+		},
+	))
 }
 
 // withReadinessChecker wraps a ReadinessChecker test function; the first object will be converted to an unstructured
@@ -105,6 +288,6 @@ func withReadinessChecker(scheme *runtime.Scheme, f func(*WithT, *ReadinessCheck
 			objs = objs[1:]
 		}
 		reader := fake.NewFakeClientWithScheme(scheme, objs...)
-		f(NewWithT(t), &ReadinessChecker{Reader: reader}, u)
+		f(NewWithT(t), &ReadinessChecker{Reader: reader, EventReader: reader}, u)
 	}
 }
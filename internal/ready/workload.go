@@ -0,0 +1,325 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ready
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/kubectl/pkg/polymorphichelpers"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// cronJobGroupKind is the group/kind of a cron job; the pods are associated through intermediate jobs
+	cronJobGroupKind = schema.GroupKind{Group: "batch", Kind: "CronJob"}
+	// argoRolloutGroupKind is the group/kind of an Argo Rollout
+	argoRolloutGroupKind = schema.GroupKind{Group: "argoproj.io", Kind: "Rollout"}
+	// knativeServiceGroupKind is the group/kind of a Knative Service
+	knativeServiceGroupKind = schema.GroupKind{Group: "serving.knative.dev", Kind: "Service"}
+)
+
+const (
+	// knativeServiceLabel is the label applied to all the pods of a Knative Service
+	knativeServiceLabel = "serving.knative.dev/service"
+	// maxOwnerDepth is the maximum number of owner references to follow from a pod to the object being checked
+	maxOwnerDepth = 5
+)
+
+// statusViewer evaluates the rollout status of an object, see `polymorphichelpers.StatusViewer`
+type statusViewer interface {
+	Status(ctx context.Context, obj *unstructured.Unstructured) (string, bool, error)
+}
+
+// statusViewerFor returns a status viewer for the specified kind, falling back to the kubectl implementation for the
+// kinds we do not explicitly support
+func (r *ReadinessChecker) statusViewerFor(kind schema.GroupKind) (statusViewer, error) {
+	switch kind {
+	case appsv1.SchemeGroupVersion.WithKind("ReplicaSet").GroupKind(),
+		schema.GroupKind{Group: "extensions", Kind: "ReplicaSet"}:
+		return &replicaSetStatusViewer{}, nil
+	case batchv1.SchemeGroupVersion.WithKind("Job").GroupKind():
+		return &jobStatusViewer{}, nil
+	case cronJobGroupKind:
+		return &cronJobStatusViewer{reader: r.Reader}, nil
+	case argoRolloutGroupKind:
+		return &argoRolloutStatusViewer{}, nil
+	case knativeServiceGroupKind:
+		return &knativeServiceStatusViewer{checker: r}, nil
+	}
+
+	sv, err := polymorphichelpers.StatusViewerFor(kind)
+	if err != nil {
+		return nil, err
+	}
+	return &kubectlStatusViewer{sv: sv}, nil
+}
+
+// kubectlStatusViewer adapts a kubectl status viewer
+type kubectlStatusViewer struct {
+	sv polymorphichelpers.StatusViewer
+}
+
+// Status returns the kubectl rollout status for the latest revision
+func (s *kubectlStatusViewer) Status(_ context.Context, obj *unstructured.Unstructured) (string, bool, error) {
+	return s.sv.Status(obj, 0)
+}
+
+// replicaSetStatusViewer implements a rollout status for replica sets
+type replicaSetStatusViewer struct{}
+
+// Status checks that all of the desired replicas are ready
+func (s *replicaSetStatusViewer) Status(_ context.Context, obj *unstructured.Unstructured) (string, bool, error) {
+	rs := &appsv1.ReplicaSet{}
+	if err := scheme.Scheme.Convert(obj, rs, nil); err != nil {
+		return "", false, fmt.Errorf("failed to convert %T to %T: %v", obj, rs, err)
+	}
+
+	if rs.Generation > rs.Status.ObservedGeneration {
+		return "Waiting for replica set spec update to be observed...", false, nil
+	}
+
+	replicas := int32(1)
+	if rs.Spec.Replicas != nil {
+		replicas = *rs.Spec.Replicas
+	}
+	if rs.Status.ReadyReplicas < replicas {
+		return fmt.Sprintf("Waiting for replica set %q rollout to finish: %d of %d pods are ready...", rs.Name, rs.Status.ReadyReplicas, replicas), false, nil
+	}
+	return fmt.Sprintf("replica set %q successfully rolled out", rs.Name), true, nil
+}
+
+// jobStatusViewer implements a rollout status for jobs
+type jobStatusViewer struct{}
+
+// Status checks that the job has completed; a failed job is reported as an error
+func (s *jobStatusViewer) Status(_ context.Context, obj *unstructured.Unstructured) (string, bool, error) {
+	job := &batchv1.Job{}
+	if err := scheme.Scheme.Convert(obj, job, nil); err != nil {
+		return "", false, fmt.Errorf("failed to convert %T to %T: %v", obj, job, err)
+	}
+	return jobStatus(job)
+}
+
+// jobStatus returns the rollout status of a typed job
+func jobStatus(job *batchv1.Job) (string, bool, error) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return fmt.Sprintf("job %q successfully completed", job.Name), true, nil
+		case batchv1.JobFailed:
+			return c.Message, false, fmt.Errorf("%s", c.Reason)
+		}
+	}
+	return fmt.Sprintf("Waiting for job %q to complete: %d active, %d succeeded...", job.Name, job.Status.Active, job.Status.Succeeded), false, nil
+}
+
+// cronJobStatusViewer implements a rollout status for cron jobs
+type cronJobStatusViewer struct {
+	reader client.Reader
+}
+
+// Status checks the rollout status of the most recent job created by the cron job
+func (s *cronJobStatusViewer) Status(ctx context.Context, obj *unstructured.Unstructured) (string, bool, error) {
+	jobs, err := listCronJobJobs(ctx, s.reader, obj)
+	if err != nil {
+		return "", false, err
+	}
+
+	var latest *batchv1.Job
+	for i := range jobs {
+		if latest == nil || latest.CreationTimestamp.Before(&jobs[i].CreationTimestamp) {
+			latest = &jobs[i]
+		}
+	}
+	if latest == nil {
+		return fmt.Sprintf("Waiting for cron job %q to schedule a job...", obj.GetName()), false, nil
+	}
+	return jobStatus(latest)
+}
+
+// argoRolloutStatusViewer implements a rollout status for Argo Rollouts
+type argoRolloutStatusViewer struct{}
+
+// Status checks the phase reported by the rollout controller, falling back to replica counts on older versions
+func (s *argoRolloutStatusViewer) Status(_ context.Context, obj *unstructured.Unstructured) (string, bool, error) {
+	if phase, ok, _ := unstructured.NestedString(obj.Object, "status", "phase"); ok {
+		msg, _, _ := unstructured.NestedString(obj.Object, "status", "message")
+		switch phase {
+		case "Healthy":
+			return fmt.Sprintf("rollout %q successfully rolled out", obj.GetName()), true, nil
+		case "Degraded":
+			return msg, false, fmt.Errorf("%s", phase)
+		default:
+			return fmt.Sprintf("Waiting for rollout %q to finish: %s %s", obj.GetName(), phase, msg), false, nil
+		}
+	}
+
+	replicas, ok, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !ok {
+		replicas = 1
+	}
+	updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas")
+	available, _, _ := unstructured.NestedInt64(obj.Object, "status", "availableReplicas")
+	current, _, _ := unstructured.NestedInt64(obj.Object, "status", "replicas")
+	if updated < replicas {
+		return fmt.Sprintf("Waiting for rollout %q to finish: %d out of %d new replicas have been updated...", obj.GetName(), updated, replicas), false, nil
+	}
+	if current > updated {
+		return fmt.Sprintf("Waiting for rollout %q to finish: %d old replicas are pending termination...", obj.GetName(), current-updated), false, nil
+	}
+	if available < updated {
+		return fmt.Sprintf("Waiting for rollout %q to finish: %d of %d updated replicas are available...", obj.GetName(), available, updated), false, nil
+	}
+	return fmt.Sprintf("rollout %q successfully rolled out", obj.GetName()), true, nil
+}
+
+// knativeServiceStatusViewer implements a rollout status for Knative Services
+type knativeServiceStatusViewer struct {
+	checker *ReadinessChecker
+}
+
+// Status checks that the latest generation of the service has been observed and is ready
+func (s *knativeServiceStatusViewer) Status(_ context.Context, obj *unstructured.Unstructured) (string, bool, error) {
+	if observed, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration"); obj.GetGeneration() > observed {
+		return "Waiting for service spec update to be observed...", false, nil
+	}
+
+	msg, status, err := s.checker.unstructuredConditionStatus(obj, "Ready")
+	if err != nil || status != corev1.ConditionTrue {
+		return fmt.Sprintf("Waiting for service %q to become ready: %s", obj.GetName(), msg), false, nil
+	}
+	return fmt.Sprintf("service %q successfully rolled out", obj.GetName()), true, nil
+}
+
+// listCronJobJobs returns the jobs controlled by the supplied cron job
+func listCronJobJobs(ctx context.Context, reader client.Reader, obj *unstructured.Unstructured) ([]batchv1.Job, error) {
+	list := &batchv1.JobList{}
+	if err := reader.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil, err
+	}
+
+	var jobs []batchv1.Job
+	for i := range list.Items {
+		if metav1.IsControlledBy(&list.Items[i], obj) {
+			jobs = append(jobs, list.Items[i])
+		}
+	}
+	return jobs, nil
+}
+
+// listCronJobPods returns the pods of all the jobs created by the supplied cron job
+func (r *ReadinessChecker) listCronJobPods(ctx context.Context, obj *unstructured.Unstructured) (*corev1.PodList, error) {
+	jobs, err := listCronJobJobs(ctx, r.Reader, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &corev1.PodList{}
+	for i := range jobs {
+		sel, err := metav1.LabelSelectorAsSelector(jobs[i].Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+
+		jobPods := &corev1.PodList{}
+		if err := r.Reader.List(ctx, jobPods, client.InNamespace(obj.GetNamespace()), client.MatchingLabelsSelector{Selector: sel}); err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, jobPods.Items...)
+	}
+	return list, nil
+}
+
+// listOwnedPods returns the pods whose owner references eventually lead back to the supplied object; this is used as
+// a fallback for arbitrary (e.g. custom resource) kinds where the pod selector cannot be determined
+func (r *ReadinessChecker) listOwnedPods(ctx context.Context, obj *unstructured.Unstructured) (*corev1.PodList, error) {
+	list := &corev1.PodList{}
+
+	// Objects that have not been persisted (or core objects like config maps) are not going to own any pods
+	if obj.GetUID() == "" || obj.GetObjectKind().GroupVersionKind().Group == "" {
+		return list, nil
+	}
+
+	all := &corev1.PodList{}
+	if err := r.Reader.List(ctx, all, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil, err
+	}
+
+	// Keep track of which owners we have already resolved to avoid fetching the same owner repeatedly
+	owned := map[types.UID]bool{obj.GetUID(): true}
+	for i := range all.Items {
+		ok, err := r.isOwnedBy(ctx, obj.GetNamespace(), all.Items[i].GetOwnerReferences(), owned, maxOwnerDepth)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			list.Items = append(list.Items, all.Items[i])
+		}
+	}
+	return list, nil
+}
+
+// isOwnedBy follows the supplied owner references looking for an owner that has already been identified as owned
+func (r *ReadinessChecker) isOwnedBy(ctx context.Context, namespace string, refs []metav1.OwnerReference, owned map[types.UID]bool, depth int) (bool, error) {
+	if depth <= 0 {
+		return false, nil
+	}
+
+	for _, ref := range refs {
+		if o, ok := owned[ref.UID]; ok {
+			if o {
+				return true, nil
+			}
+			continue
+		}
+
+		// Fetch the owner so we can look at it's owners
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion(ref.APIVersion)
+		u.SetKind(ref.Kind)
+		if err := r.Reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, u); err != nil {
+			if apierrs.IsNotFound(err) || apierrs.IsForbidden(err) {
+				owned[ref.UID] = false
+				continue
+			}
+			return false, err
+		}
+
+		ok, err := r.isOwnedBy(ctx, namespace, u.GetOwnerReferences(), owned, depth-1)
+		if err != nil {
+			return false, err
+		}
+		owned[ref.UID] = ok
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -173,6 +173,8 @@ func FromClusterTrial(in *redskyv1alpha1.Trial) *redskyapi.TrialValues {
 	for _, c := range in.Status.Conditions {
 		if c.Type == redskyv1alpha1.TrialFailed && c.Status == corev1.ConditionTrue {
 			out.Failed = true
+			out.FailureReason = c.Reason
+			out.FailureMessage = c.Message
 		}
 	}
 
@@ -417,6 +417,21 @@ func TestFromClusterTrial(t *testing.T) {
 				Failed: true,
 			},
 		},
+		{
+			desc: "failed reason",
+			in: &redskyv1alpha1.Trial{
+				Status: redskyv1alpha1.TrialStatus{
+					Conditions: []redskyv1alpha1.TrialCondition{
+						{Type: redskyv1alpha1.TrialFailed, Status: v1.ConditionTrue, Reason: "OOMKilled", Message: "container \"app\" in pod \"app-0\" was OOM killed"},
+					},
+				},
+			},
+			expectedOut: &redskyapi.TrialValues{
+				Failed:         true,
+				FailureReason:  "OOMKilled",
+				FailureMessage: "container \"app\" in pod \"app-0\" was OOM killed",
+			},
+		},
 		{
 			desc: "conditions not failed",
 			in: &redskyv1alpha1.Trial{
@@ -30,6 +30,12 @@ import (
 	"sigs.k8s.io/yaml"
 )
 
+// The shared volume used to record the failures of sequential delete tasks
+const (
+	deleteErrorsVolume = "setup-delete-errors"
+	deleteErrorsPath   = "/workspace/setup-delete-errors"
+)
+
 // This is overwritten during builds to point to the actual image
 var (
 	// Image is the name of the setuptools image to use
@@ -46,8 +52,10 @@ var (
 // ":latest". To address this we always explicitly specify the pull policy corresponding to the image.
 // Finally, when using digests, the default of "IfNotPresent" is acceptable as it is unambiguous.
 
-// NewJob returns a new setup job for either create or delete
-func NewJob(t *redskyv1alpha1.Trial, mode string) (*batchv1.Job, error) {
+// NewJob returns a new setup job for either create or delete, the supplied template engine is used to render Helm
+// values (the rendered manifests are mounted from the config maps returned by NewManifests); only trial scoped setup
+// tasks are included. If the template engine is nil, a new template engine is used.
+func NewJob(t *redskyv1alpha1.Trial, mode string, te *template.Engine) (*batchv1.Job, error) {
 	job := &batchv1.Job{}
 	job.Namespace = t.Namespace
 	job.Name = fmt.Sprintf("%s-%s", t.Name, mode)
@@ -56,12 +64,57 @@ func NewJob(t *redskyv1alpha1.Trial, mode string) (*batchv1.Job, error) {
 		redskyv1alpha1.LabelTrial:      t.Name,
 		redskyv1alpha1.LabelTrialRole:  "trialSetup",
 	}
-	job.Spec.BackoffLimit = new(int32)
 	job.Spec.Template.Labels = map[string]string{
 		redskyv1alpha1.LabelExperiment: t.ExperimentNamespacedName().Name,
 		redskyv1alpha1.LabelTrial:      t.Name,
 		redskyv1alpha1.LabelTrialRole:  "trialSetup",
 	}
+	return newJob(t, mode, te, job, redskyv1alpha1.SetupScopeTrial)
+}
+
+// NewExperimentJob returns a new setup job for either create or delete of the experiment scoped setup tasks in the
+// namespace of the supplied trial
+func NewExperimentJob(t *redskyv1alpha1.Trial, mode string, te *template.Engine) (*batchv1.Job, error) {
+	job := &batchv1.Job{}
+	job.Namespace = t.Namespace
+	job.Name = ExperimentJobName(t.ExperimentNamespacedName().Name, mode)
+	job.Labels = map[string]string{
+		redskyv1alpha1.LabelExperiment: t.ExperimentNamespacedName().Name,
+		redskyv1alpha1.LabelTrialRole:  "experimentSetup",
+	}
+	job.Spec.Template.Labels = map[string]string{
+		redskyv1alpha1.LabelExperiment: t.ExperimentNamespacedName().Name,
+		redskyv1alpha1.LabelTrialRole:  "experimentSetup",
+	}
+	return newJob(sharedTrial(t), mode, te, job, redskyv1alpha1.SetupScopeExperiment)
+}
+
+// sharedTrial returns a copy of the supplied trial without anything specific to that trial, it is used to render the
+// experiment scoped setup tasks so they are the same for every trial in the namespace
+func sharedTrial(t *redskyv1alpha1.Trial) *redskyv1alpha1.Trial {
+	nn := t.ExperimentNamespacedName()
+	shared := &redskyv1alpha1.Trial{}
+	shared.Namespace = t.Namespace
+	shared.Labels = map[string]string{redskyv1alpha1.LabelExperiment: nn.Name}
+	t.Spec.DeepCopyInto(&shared.Spec)
+	shared.Spec.ExperimentRef = &corev1.ObjectReference{Namespace: nn.Namespace, Name: nn.Name}
+	shared.Spec.Assignments = nil
+	shared.Spec.DerivedAssignments = nil
+	return shared
+}
+
+// ExperimentJobName returns the name of the job used for experiment scoped setup tasks
+func ExperimentJobName(experimentName, mode string) string {
+	return fmt.Sprintf("%s-setup-%s", experimentName, mode)
+}
+
+// newJob adds the setup tasks of the requested scope to the job
+func newJob(t *redskyv1alpha1.Trial, mode string, te *template.Engine, job *batchv1.Job, scope redskyv1alpha1.SetupScope) (*batchv1.Job, error) {
+	if te == nil {
+		te = template.New()
+	}
+
+	job.Spec.BackoffLimit = new(int32)
 	job.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
 	job.Spec.Template.Spec.ServiceAccountName = t.Spec.SetupServiceAccountName
 
@@ -79,8 +132,24 @@ func NewJob(t *redskyv1alpha1.Trial, mode string) (*batchv1.Job, error) {
 		RunAsNonRoot: &runAsNonRoot,
 	}
 
+	// Tasks with dependencies are run one at a time, in the reverse order when deleting
+	tasks := ScopedTasks(t.Spec.SetupTasks, scope)
+	sequential := IsSequential(tasks)
+	if sequential {
+		ordered, err := OrderTasks(tasks)
+		if err != nil {
+			return nil, err
+		}
+		if mode == ModeDelete {
+			for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+				ordered[i], ordered[j] = ordered[j], ordered[i]
+			}
+		}
+		tasks = ordered
+	}
+
 	// Create containers for each of the setup tasks
-	for _, task := range t.Spec.SetupTasks {
+	for _, task := range tasks {
 		if (mode == ModeCreate && task.SkipCreate) || (mode == ModeDelete && task.SkipDelete) {
 			continue
 		}
@@ -91,7 +160,6 @@ func NewJob(t *redskyv1alpha1.Trial, mode string) (*batchv1.Job, error) {
 			Env: []corev1.EnvVar{
 				{Name: "NAMESPACE", Value: t.Namespace},
 				{Name: "NAME", Value: task.Name},
-				{Name: "TRIAL", Value: t.Name},
 			},
 			SecurityContext: &corev1.SecurityContext{
 				RunAsUser:                &id,
@@ -100,6 +168,16 @@ func NewJob(t *redskyv1alpha1.Trial, mode string) (*batchv1.Job, error) {
 			},
 		}
 
+		// Only label the objects of trial scoped tasks with the trial name
+		if scope == redskyv1alpha1.SetupScopeTrial {
+			c.Env = append(c.Env, corev1.EnvVar{Name: "TRIAL", Value: t.Name})
+		}
+
+		// Shared objects may be left behind by a failed attempt, apply them so the next trial can try again
+		if scope == redskyv1alpha1.SetupScopeExperiment && mode == ModeCreate {
+			c.Env = append(c.Env, corev1.EnvVar{Name: "APPLY", Value: "true"})
+		}
+
 		// Make sure we have an image
 		if c.Image == "" {
 			c.Image = Image
@@ -109,6 +187,11 @@ func NewJob(t *redskyv1alpha1.Trial, mode string) (*batchv1.Job, error) {
 		// Add the trial assignments to the environment
 		c.Env = trial.AppendAssignmentEnv(t, c.Env)
 
+		// Tasks that other tasks depend on must wait for the objects they create to be ready
+		if sequential && mode == ModeCreate && hasDependents(tasks, task.Name) {
+			c.Env = append(c.Env, corev1.EnvVar{Name: "WAIT", Value: "true"})
+		}
+
 		// Add the configured volume mounts
 		for _, vm := range task.VolumeMounts {
 			c.VolumeMounts = append(c.VolumeMounts, vm)
@@ -117,8 +200,6 @@ func NewJob(t *redskyv1alpha1.Trial, mode string) (*batchv1.Job, error) {
 		// For Helm installs, serialize a Konjure configuration
 		helmConfig := newHelmGeneratorConfig(&task)
 		if helmConfig != nil {
-			te := template.New()
-
 			// Helm Values
 			for _, hv := range task.HelmValues {
 				hgv := helmGeneratorValue{
@@ -129,6 +210,9 @@ func NewJob(t *redskyv1alpha1.Trial, mode string) (*batchv1.Job, error) {
 				if hv.ValueFrom != nil {
 					// Evaluate the external value source
 					switch {
+					case hv.ValueFrom.ParameterRef != nil && scope == redskyv1alpha1.SetupScopeExperiment:
+						return nil, fmt.Errorf("experiment scoped setup task '%s' cannot use parameter '%s' for Helm value '%s'", task.Name, hv.ValueFrom.ParameterRef.Name, hv.Name)
+
 					case hv.ValueFrom.ParameterRef != nil:
 						v, ok := t.GetAssignment(hv.ValueFrom.ParameterRef.Name)
 						if !ok {
@@ -141,6 +225,13 @@ func NewJob(t *redskyv1alpha1.Trial, mode string) (*batchv1.Job, error) {
 					}
 				} else {
 					// If there is no external source, evaluate the value field as a template
+					if scope == redskyv1alpha1.SetupScopeExperiment {
+						if ok, err := te.ReferencesValues(hv.Name, hv.Value.String()); err != nil {
+							return nil, err
+						} else if ok {
+							return nil, fmt.Errorf("experiment scoped setup task '%s' cannot use parameters for Helm value '%s'", task.Name, hv.Name)
+						}
+					}
 					v, err := te.RenderHelmValue(&hv, t)
 					if err != nil {
 						return nil, err
@@ -184,9 +275,67 @@ func NewJob(t *redskyv1alpha1.Trial, mode string) (*batchv1.Job, error) {
 			c.Env = append(c.Env, corev1.EnvVar{Name: "HELM_CONFIG", Value: base64.StdEncoding.EncodeToString(b)})
 		}
 
+		// For plain manifests, either mount the rendered manifests or let the task fetch them
+		if task.Manifests != nil {
+			switch {
+			case task.Manifests.ConfigMap != nil:
+				name := manifestsName(t, &task, scope)
+				if _, ok := volumes[name]; !ok {
+					volumes[name] = manifestsVolume(name)
+				}
+				c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: name, MountPath: manifestsPath, ReadOnly: true})
+				c.Env = append(c.Env, corev1.EnvVar{Name: "MANIFESTS_PATH", Value: manifestsPath})
+
+			case task.Manifests.URL != "":
+				c.Env = append(c.Env, corev1.EnvVar{Name: "MANIFESTS_URL", Value: task.Manifests.URL})
+
+			default:
+				return nil, fmt.Errorf("unknown source for manifests of setup task '%s'", task.Name)
+			}
+		}
+
+		// Apply the container customizations from the setup pod template and the task itself
+		if tmpl := t.Spec.SetupPodTemplate; tmpl != nil {
+			tmpl.Resources.DeepCopyInto(&c.Resources)
+			c.EnvFrom = append(c.EnvFrom, tmpl.EnvFrom...)
+			if tmpl.ContainerSecurityContext != nil {
+				c.SecurityContext = tmpl.ContainerSecurityContext.DeepCopy()
+			}
+		}
+		if task.Resources != nil {
+			task.Resources.DeepCopyInto(&c.Resources)
+		}
+		c.EnvFrom = append(c.EnvFrom, task.EnvFrom...)
+
 		job.Spec.Template.Spec.Containers = append(job.Spec.Template.Spec.Containers, c)
 	}
 
+	// Init containers run one at a time, so all but the last sequential task are run as init containers
+	if containers := job.Spec.Template.Spec.Containers; sequential && len(containers) > 1 {
+		job.Spec.Template.Spec.InitContainers = containers[:len(containers)-1]
+		job.Spec.Template.Spec.Containers = containers[len(containers)-1:]
+
+		// A failed delete must not prevent the remaining tasks from deleting their objects: the init containers record
+		// their failures in a shared volume and the last container fails the job once it has finished its own delete
+		if mode == ModeDelete {
+			volumes[deleteErrorsVolume] = &corev1.Volume{Name: deleteErrorsVolume, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+			vm := corev1.VolumeMount{Name: deleteErrorsVolume, MountPath: deleteErrorsPath}
+			for i := range job.Spec.Template.Spec.InitContainers {
+				c := &job.Spec.Template.Spec.InitContainers[i]
+				c.VolumeMounts = append(c.VolumeMounts, vm)
+				c.Env = append(c.Env, corev1.EnvVar{Name: "DELETE_ERRORS_PATH", Value: deleteErrorsPath}, corev1.EnvVar{Name: "CONTINUE_ON_ERROR", Value: "true"})
+			}
+			c := &job.Spec.Template.Spec.Containers[0]
+			c.VolumeMounts = append(c.VolumeMounts, vm)
+			c.Env = append(c.Env, corev1.EnvVar{Name: "DELETE_ERRORS_PATH", Value: deleteErrorsPath})
+		}
+	}
+
+	// Apply the pod customizations from the setup pod template
+	if tmpl := t.Spec.SetupPodTemplate; tmpl != nil {
+		applySetupPodTemplate(&job.Spec.Template, tmpl)
+	}
+
 	// Add all of the volumes we collected to the pod
 	for _, v := range volumes {
 		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, *v)
@@ -228,3 +377,40 @@ func newHelmGeneratorConfig(task *redskyv1alpha1.SetupTask) *helmGeneratorConfig
 
 	return cfg
 }
+
+// applySetupPodTemplate customizes the setup pod using the supplied template
+func applySetupPodTemplate(pod *corev1.PodTemplateSpec, tmpl *redskyv1alpha1.SetupPodTemplate) {
+	// Do not allow the template to overwrite the labels used to identify setup pods
+	for k, v := range tmpl.Labels {
+		if _, ok := pod.Labels[k]; !ok {
+			pod.Labels[k] = v
+		}
+	}
+	for k, v := range tmpl.Annotations {
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string, len(tmpl.Annotations))
+		}
+		pod.Annotations[k] = v
+	}
+
+	spec := &pod.Spec
+	for k, v := range tmpl.NodeSelector {
+		if spec.NodeSelector == nil {
+			spec.NodeSelector = make(map[string]string, len(tmpl.NodeSelector))
+		}
+		spec.NodeSelector[k] = v
+	}
+	for i := range tmpl.Tolerations {
+		spec.Tolerations = append(spec.Tolerations, *tmpl.Tolerations[i].DeepCopy())
+	}
+	if tmpl.Affinity != nil {
+		spec.Affinity = tmpl.Affinity.DeepCopy()
+	}
+	if tmpl.PriorityClassName != "" {
+		spec.PriorityClassName = tmpl.PriorityClassName
+	}
+	spec.ImagePullSecrets = append(spec.ImagePullSecrets, tmpl.ImagePullSecrets...)
+	if tmpl.SecurityContext != nil {
+		spec.SecurityContext = tmpl.SecurityContext.DeepCopy()
+	}
+}
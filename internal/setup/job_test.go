@@ -0,0 +1,114 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package setup
+
+import (
+	"encoding/base64"
+	"testing"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+func TestNewJob_SetupPodTemplate(t *testing.T) {
+	cpu := resource.MustParse("100m")
+	memory := resource.MustParse("64Mi")
+	tr := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001", Namespace: "default"},
+		Spec: redskyv1alpha1.TrialSpec{
+			SetupTasks: []redskyv1alpha1.SetupTask{
+				{Name: "a"},
+				{
+					Name:      "b",
+					Resources: &corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: memory}},
+					EnvFrom:   []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "b"}}}},
+				},
+			},
+			SetupPodTemplate: &redskyv1alpha1.SetupPodTemplate{
+				Labels:                   map[string]string{"app": "setup", redskyv1alpha1.LabelTrialRole: "other"},
+				NodeSelector:             map[string]string{"pool": "setup"},
+				Tolerations:              []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+				ImagePullSecrets:         []corev1.LocalObjectReference{{Name: "registry"}},
+				ContainerSecurityContext: &corev1.SecurityContext{ReadOnlyRootFilesystem: new(bool)},
+				Resources:                corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: cpu}},
+				EnvFrom:                  []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "helm"}}}},
+			},
+		},
+	}
+
+	job, err := NewJob(tr, ModeCreate, nil)
+	require.NoError(t, err)
+
+	pod := job.Spec.Template
+	assert.Equal(t, "setup", pod.Labels["app"])
+	assert.Equal(t, "trialSetup", pod.Labels[redskyv1alpha1.LabelTrialRole])
+	assert.Equal(t, map[string]string{"pool": "setup"}, pod.Spec.NodeSelector)
+	assert.Equal(t, tr.Spec.SetupPodTemplate.Tolerations, pod.Spec.Tolerations)
+	assert.Equal(t, tr.Spec.SetupPodTemplate.ImagePullSecrets, pod.Spec.ImagePullSecrets)
+	assert.NotNil(t, pod.Spec.SecurityContext)
+
+	require.Len(t, pod.Spec.Containers, 2)
+	a, b := pod.Spec.Containers[0], pod.Spec.Containers[1]
+	assert.Equal(t, tr.Spec.SetupPodTemplate.Resources, a.Resources)
+	assert.Equal(t, *tr.Spec.SetupTasks[1].Resources, b.Resources)
+	assert.Equal(t, tr.Spec.SetupPodTemplate.ContainerSecurityContext, a.SecurityContext)
+	assert.Len(t, a.EnvFrom, 1)
+	assert.Len(t, b.EnvFrom, 2)
+}
+
+func TestNewJob_HelmValues(t *testing.T) {
+	tr := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001", Namespace: "default"},
+		Spec: redskyv1alpha1.TrialSpec{
+			Assignments: []redskyv1alpha1.Assignment{{Name: "cpu", Value: 500}},
+			SetupTasks: []redskyv1alpha1.SetupTask{
+				{
+					Name:      "app",
+					HelmChart: "stable/app",
+					HelmValues: []redskyv1alpha1.HelmValue{
+						{Name: "resources.requests.cpu", Value: intstr.FromString("{{ .Values.cpu }}m")},
+					},
+				},
+			},
+		},
+	}
+
+	// A nil template engine must still render the Helm values
+	job, err := NewJob(tr, ModeCreate, nil)
+	require.NoError(t, err)
+	require.Len(t, job.Spec.Template.Spec.Containers, 1)
+
+	var helmConfig string
+	for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == "HELM_CONFIG" {
+			helmConfig = env.Value
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(helmConfig)
+	require.NoError(t, err)
+
+	cfg := &helmGeneratorConfig{}
+	require.NoError(t, yaml.Unmarshal(data, cfg))
+	assert.Equal(t, "stable/app", cfg.Chart)
+	assert.Equal(t, []helmGeneratorValue{{Name: "resources.requests.cpu", Value: "500m"}}, cfg.Values)
+}
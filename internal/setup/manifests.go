@@ -0,0 +1,150 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package setup
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/redskyops/redskyops-controller/internal/template"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// manifestsPath is where the rendered manifests of a setup task are mounted
+var manifestsPath = path.Join("/workspace", "setup-manifests")
+
+// NewManifests returns the config maps holding the rendered manifests of the trial scoped setup tasks; the config
+// maps are mounted by both the create and delete jobs so the manifests only need to be rendered once
+func NewManifests(t *redskyv1alpha1.Trial, te *template.Engine) ([]corev1.ConfigMap, error) {
+	labels := map[string]string{
+		redskyv1alpha1.LabelExperiment: t.ExperimentNamespacedName().Name,
+		redskyv1alpha1.LabelTrial:      t.Name,
+		redskyv1alpha1.LabelTrialRole:  "trialSetup",
+	}
+	return newManifests(t, te, labels, redskyv1alpha1.SetupScopeTrial)
+}
+
+// NewExperimentManifests returns the config maps holding the rendered manifests of the experiment scoped setup tasks
+// in the namespace of the supplied trial
+func NewExperimentManifests(t *redskyv1alpha1.Trial, te *template.Engine) ([]corev1.ConfigMap, error) {
+	labels := map[string]string{
+		redskyv1alpha1.LabelExperiment: t.ExperimentNamespacedName().Name,
+		redskyv1alpha1.LabelTrialRole:  "experimentSetup",
+	}
+	return newManifests(sharedTrial(t), te, labels, redskyv1alpha1.SetupScopeExperiment)
+}
+
+// newManifests renders the manifests of the setup tasks of the requested scope
+func newManifests(t *redskyv1alpha1.Trial, te *template.Engine, labels map[string]string, scope redskyv1alpha1.SetupScope) ([]corev1.ConfigMap, error) {
+	if te == nil {
+		te = template.New()
+	}
+
+	var cms []corev1.ConfigMap
+	for _, task := range ScopedTasks(t.Spec.SetupTasks, scope) {
+		if task.Manifests == nil || task.Manifests.ConfigMap == nil {
+			continue
+		}
+
+		data, err := renderManifests(t, &task, te, scope)
+		if err != nil {
+			return nil, err
+		}
+
+		cm := corev1.ConfigMap{Data: data}
+		cm.Namespace = t.Namespace
+		cm.Name = manifestsName(t, &task, scope)
+		cm.Labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			cm.Labels[k] = v
+		}
+		cms = append(cms, cm)
+	}
+	return cms, nil
+}
+
+// ExperimentManifestsNames returns the names of the config maps holding the rendered manifests of the experiment
+// scoped setup tasks
+func ExperimentManifestsNames(t *redskyv1alpha1.Trial) []string {
+	var names []string
+	for _, task := range ScopedTasks(t.Spec.SetupTasks, redskyv1alpha1.SetupScopeExperiment) {
+		if task.Manifests != nil && task.Manifests.ConfigMap != nil {
+			names = append(names, manifestsName(t, &task, redskyv1alpha1.SetupScopeExperiment))
+		}
+	}
+	return names
+}
+
+// manifestsName returns the name of the config map holding the rendered manifests of a setup task
+func manifestsName(t *redskyv1alpha1.Trial, task *redskyv1alpha1.SetupTask, scope redskyv1alpha1.SetupScope) string {
+	if scope == redskyv1alpha1.SetupScopeExperiment {
+		return fmt.Sprintf("%s-setup-%s-manifests", t.ExperimentNamespacedName().Name, task.Name)
+	}
+	return fmt.Sprintf("%s-%s-manifests", t.Name, task.Name)
+}
+
+// manifestsVolume returns the volume used to mount the rendered manifests of a setup task; the config map is optional
+// so the delete job can still run (and fall back to deleting by label) if it no longer exists
+func manifestsVolume(name string) *corev1.Volume {
+	optional := true
+	return &corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Optional:             &optional,
+			},
+		},
+	}
+}
+
+// renderManifests returns the rendered manifests from the config map of the supplied setup task
+func renderManifests(t *redskyv1alpha1.Trial, task *redskyv1alpha1.SetupTask, te *template.Engine, scope redskyv1alpha1.SetupScope) (map[string]string, error) {
+	if te.ConfigMaps == nil {
+		return nil, fmt.Errorf("unable to read manifests for setup task '%s'", task.Name)
+	}
+	data, err := te.ConfigMaps(t.ExperimentNamespacedName().Namespace, task.Manifests.ConfigMap.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use a consistent order so errors are reported consistently
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rendered := make(map[string]string, len(data))
+	for _, k := range keys {
+		if scope == redskyv1alpha1.SetupScopeExperiment {
+			if ok, err := te.ReferencesValues(k, data[k]); err != nil {
+				return nil, fmt.Errorf("invalid manifest '%s' for setup task '%s': %v", k, task.Name, err)
+			} else if ok {
+				return nil, fmt.Errorf("experiment scoped setup task '%s' cannot use parameters in manifest '%s'", task.Name, k)
+			}
+		}
+		b, err := te.RenderManifest(k, data[k], t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest '%s' for setup task '%s': %v", k, task.Name, err)
+		}
+		rendered[k] = string(b)
+	}
+	return rendered, nil
+}
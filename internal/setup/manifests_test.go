@@ -0,0 +1,90 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package setup
+
+import (
+	"testing"
+
+	"github.com/redskyops/redskyops-controller/internal/template"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewJob_Manifests(t *testing.T) {
+	tr := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001", Namespace: "default"},
+		Spec: redskyv1alpha1.TrialSpec{
+			ExperimentRef: &corev1.ObjectReference{Name: "test"},
+			Assignments:   []redskyv1alpha1.Assignment{{Name: "replicas", Value: 3}},
+			SetupTasks: []redskyv1alpha1.SetupTask{
+				{Name: "local", Manifests: &redskyv1alpha1.ManifestsSource{ConfigMap: &corev1.LocalObjectReference{Name: "manifests"}}},
+				{Name: "remote", Manifests: &redskyv1alpha1.ManifestsSource{URL: "github.com/example/app//deploy"}},
+			},
+		},
+	}
+
+	te := template.New()
+	te.ConfigMaps = func(namespace, name string) (map[string]string, error) {
+		assert.Equal(t, "default", namespace)
+		assert.Equal(t, "manifests", name)
+		return map[string]string{
+			"kustomization.yaml": "resources:\n- deployment.yaml\n",
+			"deployment.yaml":    "spec:\n  replicas: {{ .Values.replicas }}\n",
+		}, nil
+	}
+
+	// The manifests are rendered into a config map
+	cms, err := NewManifests(tr, te)
+	require.NoError(t, err)
+	if assert.Len(t, cms, 1) {
+		assert.Equal(t, "test-001-local-manifests", cms[0].Name)
+		assert.Equal(t, "test-001", cms[0].Labels[redskyv1alpha1.LabelTrial])
+		assert.Equal(t, map[string]string{
+			"kustomization.yaml": "resources:\n- deployment.yaml\n",
+			"deployment.yaml":    "spec:\n  replicas: 3\n",
+		}, cms[0].Data)
+	}
+
+	// Both the create and delete jobs mount the rendered manifests without reading the source config map
+	for _, mode := range []string{ModeCreate, ModeDelete} {
+		job, err := NewJob(tr, mode, nil)
+		require.NoError(t, err)
+		require.Len(t, job.Spec.Template.Spec.Containers, 2)
+
+		c := job.Spec.Template.Spec.Containers[0]
+		assert.Contains(t, c.Env, corev1.EnvVar{Name: "MANIFESTS_PATH", Value: "/workspace/setup-manifests"})
+		assert.Contains(t, c.VolumeMounts, corev1.VolumeMount{Name: "test-001-local-manifests", MountPath: "/workspace/setup-manifests", ReadOnly: true})
+		if assert.Len(t, job.Spec.Template.Spec.Volumes, 1) {
+			v := job.Spec.Template.Spec.Volumes[0]
+			if assert.NotNil(t, v.ConfigMap) {
+				assert.Equal(t, "test-001-local-manifests", v.ConfigMap.Name)
+				assert.True(t, *v.ConfigMap.Optional)
+			}
+		}
+
+		assert.Contains(t, job.Spec.Template.Spec.Containers[1].Env, corev1.EnvVar{Name: "MANIFESTS_URL", Value: "github.com/example/app//deploy"})
+	}
+
+	// Shared manifests cannot depend on the assignments of a single trial
+	tr.Spec.SetupTasks[0].Scope = redskyv1alpha1.SetupScopeExperiment
+	assert.Equal(t, []string{"test-setup-local-manifests"}, ExperimentManifestsNames(tr))
+	_, err = NewExperimentManifests(tr, te)
+	assert.EqualError(t, err, "experiment scoped setup task 'local' cannot use parameters in manifest 'deployment.yaml'")
+}
@@ -39,11 +39,9 @@ const (
 
 // UpdateStatus returns true if there are setup tasks
 func UpdateStatus(t *redskyv1alpha1.Trial, probeTime *metav1.Time) bool {
-	var needsCreate, needsDelete bool
-	for _, task := range t.Spec.SetupTasks {
-		needsCreate = needsCreate || !task.SkipCreate
-		needsDelete = needsDelete || !task.SkipDelete
-	}
+	// Experiment scoped tasks are created for the trial but they are not deleted with it
+	needsCreate := HasTasks(t, redskyv1alpha1.SetupScopeTrial, ModeCreate) || HasTasks(t, redskyv1alpha1.SetupScopeExperiment, ModeCreate)
+	needsDelete := HasTasks(t, redskyv1alpha1.SetupScopeTrial, ModeDelete)
 
 	// Short circuit, there are no setup tasks
 	if !needsCreate && !needsDelete {
@@ -84,6 +82,20 @@ func UpdateStatus(t *redskyv1alpha1.Trial, probeTime *metav1.Time) bool {
 	return true
 }
 
+// HasTasks checks to see if the trial has any setup tasks of the specified scope which run in the specified mode
+func HasTasks(t *redskyv1alpha1.Trial, scope redskyv1alpha1.SetupScope, mode string) bool {
+	for i := range t.Spec.SetupTasks {
+		task := &t.Spec.SetupTasks[i]
+		if TaskScope(task) != scope {
+			continue
+		}
+		if (mode == ModeCreate && !task.SkipCreate) || (mode == ModeDelete && !task.SkipDelete) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetTrialConditionType returns the trial condition type used to report status for the specified job
 func GetTrialConditionType(j *batchv1.Job) (redskyv1alpha1.TrialConditionType, error) {
 	// TODO This should just be a label or annotation on the job
@@ -0,0 +1,227 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package setup
+
+import (
+	"fmt"
+	"strings"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ScopedTasks returns the setup tasks with the specified scope, dependencies on setup tasks of a different scope are
+// removed (experiment scoped tasks always finish before any trial scoped tasks start)
+func ScopedTasks(tasks []redskyv1alpha1.SetupTask, scope redskyv1alpha1.SetupScope) []redskyv1alpha1.SetupTask {
+	scoped := make(map[string]bool, len(tasks))
+	for i := range tasks {
+		scoped[tasks[i].Name] = TaskScope(&tasks[i]) == scope
+	}
+
+	var result []redskyv1alpha1.SetupTask
+	for i := range tasks {
+		if !scoped[tasks[i].Name] {
+			continue
+		}
+		task := tasks[i]
+		task.DependsOn = nil
+		for _, d := range tasks[i].DependsOn {
+			if scoped[d] {
+				task.DependsOn = append(task.DependsOn, d)
+			}
+		}
+		result = append(result, task)
+	}
+	return result
+}
+
+// TaskScope returns the effective scope of a setup task
+func TaskScope(task *redskyv1alpha1.SetupTask) redskyv1alpha1.SetupScope {
+	if task.Scope == "" {
+		return redskyv1alpha1.SetupScopeTrial
+	}
+	return task.Scope
+}
+
+// IsSequential checks to see if any of the setup tasks have dependencies, in which case the tasks must be run one at a
+// time instead of in parallel
+func IsSequential(tasks []redskyv1alpha1.SetupTask) bool {
+	for i := range tasks {
+		if len(tasks[i].DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderTasks returns the setup tasks ordered such that every task comes after all of its dependencies; when possible
+// the order of the tasks as declared is preserved. An error is returned for unknown or circular dependencies.
+func OrderTasks(tasks []redskyv1alpha1.SetupTask) ([]redskyv1alpha1.SetupTask, error) {
+	index := make(map[string]int, len(tasks))
+	for i := range tasks {
+		index[tasks[i].Name] = i
+	}
+	for i := range tasks {
+		for _, d := range tasks[i].DependsOn {
+			if _, ok := index[d]; !ok {
+				return nil, fmt.Errorf("setup task '%s' depends on unknown setup task '%s'", tasks[i].Name, d)
+			}
+		}
+	}
+
+	ordered := make([]redskyv1alpha1.SetupTask, 0, len(tasks))
+	done := make(map[string]bool, len(tasks))
+	for len(ordered) < len(tasks) {
+		// Take the first task (in declaration order) whose dependencies are all done
+		next := -1
+		for i := range tasks {
+			if done[tasks[i].Name] {
+				continue
+			}
+			ready := true
+			for _, d := range tasks[i].DependsOn {
+				ready = ready && done[d]
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			var remaining []string
+			for i := range tasks {
+				if !done[tasks[i].Name] {
+					remaining = append(remaining, tasks[i].Name)
+				}
+			}
+			return nil, fmt.Errorf("circular dependency between setup tasks: %s", strings.Join(remaining, ", "))
+		}
+
+		ordered = append(ordered, tasks[next])
+		done[tasks[next].Name] = true
+	}
+	return ordered, nil
+}
+
+// hasDependents checks to see if any setup task depends on the named task
+func hasDependents(tasks []redskyv1alpha1.SetupTask, name string) bool {
+	for i := range tasks {
+		for _, d := range tasks[i].DependsOn {
+			if d == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetTaskStatus returns the status of each setup task run by the supplied job using the state of the job pods
+func GetTaskStatus(j *batchv1.Job, pods []corev1.Pod) []redskyv1alpha1.SetupTaskStatus {
+	mode := ModeCreate
+	if ct, err := GetTrialConditionType(j); err == nil && ct == redskyv1alpha1.TrialSetupDeleted {
+		mode = ModeDelete
+	}
+
+	// Use the most recently created pod, the job does not retry failed pods so there is normally only one
+	var pod *corev1.Pod
+	for i := range pods {
+		if pod == nil || pod.CreationTimestamp.Before(&pods[i].CreationTimestamp) {
+			pod = &pods[i]
+		}
+	}
+
+	var status []redskyv1alpha1.SetupTaskStatus
+	for _, cl := range [][]corev1.Container{j.Spec.Template.Spec.InitContainers, j.Spec.Template.Spec.Containers} {
+		for _, c := range cl {
+			ts := redskyv1alpha1.SetupTaskStatus{
+				Name:  strings.TrimPrefix(c.Name, j.Name+"-"),
+				Mode:  mode,
+				Phase: redskyv1alpha1.SetupTaskPending,
+			}
+			if pod != nil {
+				for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+					if cs.Name == c.Name {
+						ts.Phase, ts.Message = containerPhase(&cs.State)
+						break
+					}
+				}
+			}
+			status = append(status, ts)
+		}
+	}
+	return status
+}
+
+// containerPhase returns the setup task phase and message corresponding to the state of a setup task container
+func containerPhase(s *corev1.ContainerState) (redskyv1alpha1.SetupTaskPhase, string) {
+	switch {
+	case s.Terminated != nil && s.Terminated.ExitCode == 0:
+		return redskyv1alpha1.SetupTaskSucceeded, ""
+	case s.Terminated != nil:
+		msg := strings.TrimSpace(s.Terminated.Message)
+		if msg == "" {
+			msg = fmt.Sprintf("Setup task exited with code %d", s.Terminated.ExitCode)
+			if s.Terminated.Reason != "" && s.Terminated.Reason != "Error" {
+				msg = fmt.Sprintf("%s (%s)", msg, s.Terminated.Reason)
+			}
+		}
+		return redskyv1alpha1.SetupTaskFailed, msg
+	case s.Running != nil:
+		return redskyv1alpha1.SetupTaskRunning, ""
+	case s.Waiting != nil && s.Waiting.Reason != "PodInitializing" && s.Waiting.Reason != "ContainerCreating":
+		if s.Waiting.Message == "" {
+			return redskyv1alpha1.SetupTaskPending, s.Waiting.Reason
+		}
+		return redskyv1alpha1.SetupTaskPending, fmt.Sprintf("%s: %s", s.Waiting.Reason, s.Waiting.Message)
+	default:
+		return redskyv1alpha1.SetupTaskPending, ""
+	}
+}
+
+// ApplyTaskStatus records the status of the setup tasks on the trial, returning true if the trial status changed
+func ApplyTaskStatus(status *redskyv1alpha1.TrialStatus, taskStatus []redskyv1alpha1.SetupTaskStatus) bool {
+	var dirty bool
+	for _, ts := range taskStatus {
+		found := false
+		for i := range status.SetupTasks {
+			if status.SetupTasks[i].Name != ts.Name {
+				continue
+			}
+			found = true
+
+			// Do not let the create job overwrite the delete job, or a pending delete overwrite the outcome of the create
+			if status.SetupTasks[i].Mode == ModeDelete && ts.Mode == ModeCreate {
+				break
+			}
+			if status.SetupTasks[i].Mode != ts.Mode && ts.Phase == redskyv1alpha1.SetupTaskPending && ts.Message == "" {
+				break
+			}
+			if status.SetupTasks[i] != ts {
+				status.SetupTasks[i] = ts
+				dirty = true
+			}
+			break
+		}
+		if !found {
+			status.SetupTasks = append(status.SetupTasks, ts)
+			dirty = true
+		}
+	}
+	return dirty
+}
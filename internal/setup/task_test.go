@@ -0,0 +1,213 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package setup
+
+import (
+	"testing"
+
+	"github.com/redskyops/redskyops-controller/internal/template"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestOrderTasks(t *testing.T) {
+	cases := []struct {
+		desc     string
+		tasks    []redskyv1alpha1.SetupTask
+		expected []string
+		err      bool
+	}{
+		{
+			desc:     "declared order",
+			tasks:    []redskyv1alpha1.SetupTask{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			desc: "dependencies",
+			tasks: []redskyv1alpha1.SetupTask{
+				{Name: "migrate", DependsOn: []string{"database"}},
+				{Name: "app", DependsOn: []string{"migrate", "cache"}},
+				{Name: "database"},
+				{Name: "cache"},
+			},
+			expected: []string{"database", "migrate", "cache", "app"},
+		},
+		{
+			desc:  "unknown",
+			tasks: []redskyv1alpha1.SetupTask{{Name: "a", DependsOn: []string{"b"}}},
+			err:   true,
+		},
+		{
+			desc: "circular",
+			tasks: []redskyv1alpha1.SetupTask{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"a"}},
+			},
+			err: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			ordered, err := OrderTasks(c.tasks)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var names []string
+			for i := range ordered {
+				names = append(names, ordered[i].Name)
+			}
+			assert.Equal(t, c.expected, names)
+		})
+	}
+}
+
+func TestNewJob_Sequential(t *testing.T) {
+	tr := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001", Namespace: "default"},
+		Spec: redskyv1alpha1.TrialSpec{
+			SetupTasks: []redskyv1alpha1.SetupTask{
+				{Name: "migrate", DependsOn: []string{"database"}},
+				{Name: "database"},
+			},
+		},
+	}
+
+	job, err := NewJob(tr, ModeCreate, nil)
+	require.NoError(t, err)
+	if assert.Len(t, job.Spec.Template.Spec.InitContainers, 1) && assert.Len(t, job.Spec.Template.Spec.Containers, 1) {
+		assert.Equal(t, "test-001-create-database", job.Spec.Template.Spec.InitContainers[0].Name)
+		assert.Contains(t, job.Spec.Template.Spec.InitContainers[0].Env, corev1.EnvVar{Name: "WAIT", Value: "true"})
+		assert.Equal(t, "test-001-create-migrate", job.Spec.Template.Spec.Containers[0].Name)
+	}
+
+	job, err = NewJob(tr, ModeDelete, nil)
+	require.NoError(t, err)
+	if assert.Len(t, job.Spec.Template.Spec.InitContainers, 1) && assert.Len(t, job.Spec.Template.Spec.Containers, 1) {
+		assert.Equal(t, "test-001-delete-migrate", job.Spec.Template.Spec.InitContainers[0].Name)
+		assert.Equal(t, "test-001-delete-database", job.Spec.Template.Spec.Containers[0].Name)
+
+		// A failed delete is recorded so the remaining tasks still run
+		assert.Contains(t, job.Spec.Template.Spec.InitContainers[0].Env, corev1.EnvVar{Name: "CONTINUE_ON_ERROR", Value: "true"})
+		assert.Contains(t, job.Spec.Template.Spec.InitContainers[0].VolumeMounts, corev1.VolumeMount{Name: "setup-delete-errors", MountPath: "/workspace/setup-delete-errors"})
+		assert.NotContains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "CONTINUE_ON_ERROR", Value: "true"})
+		assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "DELETE_ERRORS_PATH", Value: "/workspace/setup-delete-errors"})
+		if assert.Len(t, job.Spec.Template.Spec.Volumes, 1) {
+			assert.NotNil(t, job.Spec.Template.Spec.Volumes[0].EmptyDir)
+		}
+	}
+}
+
+func TestGetTaskStatus(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "test-001-create"}}
+	job.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "test-001-create-database", Args: []string{ModeCreate}}}
+	job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "test-001-create-migrate", Args: []string{ModeCreate}}}
+
+	assert.Equal(t, []redskyv1alpha1.SetupTaskStatus{
+		{Name: "database", Mode: ModeCreate, Phase: redskyv1alpha1.SetupTaskPending},
+		{Name: "migrate", Mode: ModeCreate, Phase: redskyv1alpha1.SetupTaskPending},
+	}, GetTaskStatus(job, nil))
+
+	pod := corev1.Pod{}
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{
+		{Name: "test-001-create-database", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}},
+	}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{Name: "test-001-create-migrate", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}}},
+	}
+	status := GetTaskStatus(job, []corev1.Pod{pod})
+	assert.Equal(t, []redskyv1alpha1.SetupTaskStatus{
+		{Name: "database", Mode: ModeCreate, Phase: redskyv1alpha1.SetupTaskSucceeded},
+		{Name: "migrate", Mode: ModeCreate, Phase: redskyv1alpha1.SetupTaskFailed, Message: "Setup task exited with code 1"},
+	}, status)
+
+	// A pending delete does not overwrite the outcome of the create, but the create never overwrites the delete
+	ts := &redskyv1alpha1.TrialStatus{}
+	assert.True(t, ApplyTaskStatus(ts, status))
+	assert.False(t, ApplyTaskStatus(ts, status))
+	assert.False(t, ApplyTaskStatus(ts, []redskyv1alpha1.SetupTaskStatus{{Name: "database", Mode: ModeDelete, Phase: redskyv1alpha1.SetupTaskPending}}))
+	assert.True(t, ApplyTaskStatus(ts, []redskyv1alpha1.SetupTaskStatus{{Name: "database", Mode: ModeDelete, Phase: redskyv1alpha1.SetupTaskRunning}}))
+	assert.False(t, ApplyTaskStatus(ts, status))
+	assert.Equal(t, redskyv1alpha1.SetupTaskRunning, ts.SetupTasks[0].Phase)
+}
+
+func TestNewExperimentJob(t *testing.T) {
+	tr := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001", Namespace: "default"},
+		Spec: redskyv1alpha1.TrialSpec{
+			ExperimentRef: &corev1.ObjectReference{Name: "test"},
+			Assignments:   []redskyv1alpha1.Assignment{{Name: "cpu", Value: 500}},
+			SetupTasks: []redskyv1alpha1.SetupTask{
+				{Name: "database", Scope: redskyv1alpha1.SetupScopeExperiment},
+				{Name: "app", DependsOn: []string{"database"}},
+			},
+		},
+	}
+
+	job, err := NewExperimentJob(tr, ModeCreate, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "test-setup-create", job.Name)
+	assert.Equal(t, "experimentSetup", job.Labels[redskyv1alpha1.LabelTrialRole])
+	assert.NotContains(t, job.Labels, redskyv1alpha1.LabelTrial)
+	if assert.Len(t, job.Spec.Template.Spec.Containers, 1) {
+		assert.Equal(t, "test-setup-create-database", job.Spec.Template.Spec.Containers[0].Name)
+		for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+			assert.NotEqual(t, "TRIAL", env.Name)
+			assert.NotEqual(t, "CPU", env.Name)
+		}
+		assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "APPLY", Value: "true"})
+	}
+
+	// The dependency on the experiment scoped task is satisfied before the trial job is created
+	job, err = NewJob(tr, ModeCreate, nil)
+	require.NoError(t, err)
+	assert.Empty(t, job.Spec.Template.Spec.InitContainers)
+	if assert.Len(t, job.Spec.Template.Spec.Containers, 1) {
+		assert.Equal(t, "test-001-create-app", job.Spec.Template.Spec.Containers[0].Name)
+		assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "TRIAL", Value: "test-001"})
+		assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "CPU", Value: "500"})
+	}
+
+	// Shared Helm values cannot depend on the assignments of a single trial
+	tr.Spec.SetupTasks[0].HelmChart = "stable/postgresql"
+	tr.Spec.SetupTasks[0].HelmValues = []redskyv1alpha1.HelmValue{
+		{Name: "resources.requests.cpu", ValueFrom: &redskyv1alpha1.HelmValueSource{ParameterRef: &redskyv1alpha1.ParameterSelector{Name: "cpu"}}},
+	}
+	_, err = NewExperimentJob(tr, ModeCreate, template.New())
+	assert.EqualError(t, err, "experiment scoped setup task 'database' cannot use parameter 'cpu' for Helm value 'resources.requests.cpu'")
+	tr.Spec.SetupTasks[0].HelmValues = []redskyv1alpha1.HelmValue{
+		{Name: "resources.requests.cpu", Value: intstr.FromString("{{ .Values.cpu }}m")},
+	}
+	_, err = NewExperimentJob(tr, ModeCreate, template.New())
+	assert.EqualError(t, err, "experiment scoped setup task 'database' cannot use parameters for Helm value 'resources.requests.cpu'")
+	tr.Spec.SetupTasks[0].HelmChart = ""
+	tr.Spec.SetupTasks[0].HelmValues = nil
+
+	// Experiment scoped tasks are not deleted with the trial
+	tr.Spec.SetupTasks = tr.Spec.SetupTasks[:1]
+	now := metav1.Now()
+	assert.True(t, UpdateStatus(tr, &now))
+	if assert.Len(t, tr.Status.Conditions, 1) {
+		assert.Equal(t, redskyv1alpha1.TrialSetupCreated, tr.Status.Conditions[0].Type)
+	}
+}
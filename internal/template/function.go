@@ -18,6 +18,7 @@ package template
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"text/template"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/Masterminds/sprig"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // FuncMap returns the functions used for template evaluation
@@ -37,6 +39,11 @@ func FuncMap() template.FuncMap {
 		"duration":         duration,
 		"percent":          percent,
 		"resourceRequests": resourceRequests,
+		"toMi":             toMi,
+		"toMillicores":     toMillicores,
+		"mulf":             mulf,
+		"clamp":            clamp,
+		"roundUp":          roundUp,
 	}
 
 	for k, v := range extra {
@@ -82,3 +89,105 @@ func resourceRequests(pods corev1.PodList, weights string) (float64, error) {
 	}
 	return totalResources, nil
 }
+
+// toMi returns the number of mebibytes represented by a quantity
+func toMi(v interface{}) (float64, error) {
+	q, err := toQuantity(v)
+	if err != nil {
+		return 0, err
+	}
+	return float64(q.MilliValue()) / 1000 / (1 << 20), nil
+}
+
+// toMillicores returns the number of millicores represented by a quantity
+func toMillicores(v interface{}) (int64, error) {
+	q, err := toQuantity(v)
+	if err != nil {
+		return 0, err
+	}
+	return q.MilliValue(), nil
+}
+
+// mulf returns the floating point product of two numbers
+func mulf(a, b interface{}) (float64, error) {
+	af, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+	bf, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	return af * bf, nil
+}
+
+// clamp restricts a number to the supplied range
+func clamp(min, max, value interface{}) (float64, error) {
+	minf, err := toFloat(min)
+	if err != nil {
+		return 0, err
+	}
+	maxf, err := toFloat(max)
+	if err != nil {
+		return 0, err
+	}
+	vf, err := toFloat(value)
+	if err != nil {
+		return 0, err
+	}
+	return math.Min(math.Max(vf, minf), maxf), nil
+}
+
+// roundUp rounds a number up to the nearest multiple of the supplied value
+func roundUp(multiple, value interface{}) (int64, error) {
+	mf, err := toFloat(multiple)
+	if err != nil {
+		return 0, err
+	}
+	vf, err := toFloat(value)
+	if err != nil {
+		return 0, err
+	}
+	if mf <= 0 {
+		return 0, fmt.Errorf("multiple must be positive: %v", multiple)
+	}
+	return int64(math.Ceil(vf/mf) * mf), nil
+}
+
+// toQuantity converts a string or number into a resource quantity
+func toQuantity(v interface{}) (resource.Quantity, error) {
+	switch t := v.(type) {
+	case resource.Quantity:
+		return t, nil
+	case *resource.Quantity:
+		return *t, nil
+	case string:
+		return resource.ParseQuantity(t)
+	}
+
+	f, err := toFloat(v)
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+	return resource.ParseQuantity(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// toFloat converts a number into a floating point number
+func toFloat(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case float32:
+		return float64(t), nil
+	case int:
+		return float64(t), nil
+	case int32:
+		return float64(t), nil
+	case int64:
+		return float64(t), nil
+	case string:
+		return strconv.ParseFloat(t, 64)
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", v)
+	}
+}
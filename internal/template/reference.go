@@ -0,0 +1,104 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"text/template"
+	"text/template/parse"
+)
+
+// ReferencesValues checks if the supplied template text, or any of the named templates it includes, refers to the
+// trial assignments (i.e. the "Values" field of the template data)
+func (e *Engine) ReferencesValues(name, text string) (bool, error) {
+	tmpl, err := template.New(name).Funcs(e.FuncMap).Parse(text)
+	if err != nil {
+		return false, err
+	}
+	if tmpl.Tree == nil {
+		return false, nil
+	}
+	return e.referencesValues(tmpl.Tree.Root, make(map[string]bool)), nil
+}
+
+// referencesValues walks the parse tree looking for references to the assignment values, the visited map is used to
+// avoid following the same named template more then once
+func (e *Engine) referencesValues(node parse.Node, visited map[string]bool) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Nodes {
+			if e.referencesValues(c, visited) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return e.referencesValues(n.Pipe, visited)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Cmds {
+			if e.referencesValues(c, visited) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		// Follow the named templates used by "include"
+		if len(n.Args) > 1 {
+			if id, ok := n.Args[0].(*parse.IdentifierNode); ok && id.Ident == "include" {
+				if s, ok := n.Args[1].(*parse.StringNode); ok && e.definedReferencesValues(s.Text, visited) {
+					return true
+				}
+			}
+		}
+		for _, c := range n.Args {
+			if e.referencesValues(c, visited) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return len(n.Ident) > 0 && n.Ident[0] == "Values"
+	case *parse.VariableNode:
+		return len(n.Ident) > 1 && n.Ident[0] == "$" && n.Ident[1] == "Values"
+	case *parse.ChainNode:
+		return e.referencesValues(n.Node, visited)
+	case *parse.IfNode:
+		return e.referencesValues(n.Pipe, visited) || e.referencesValues(n.List, visited) || e.referencesValues(n.ElseList, visited)
+	case *parse.RangeNode:
+		return e.referencesValues(n.Pipe, visited) || e.referencesValues(n.List, visited) || e.referencesValues(n.ElseList, visited)
+	case *parse.WithNode:
+		return e.referencesValues(n.Pipe, visited) || e.referencesValues(n.List, visited) || e.referencesValues(n.ElseList, visited)
+	case *parse.TemplateNode:
+		return e.referencesValues(n.Pipe, visited) || e.definedReferencesValues(n.Name, visited)
+	}
+	return false
+}
+
+// definedReferencesValues checks if a named template refers to the assignment values
+func (e *Engine) definedReferencesValues(name string, visited map[string]bool) bool {
+	if e.defined == nil || visited[name] {
+		return false
+	}
+	visited[name] = true
+	tmpl := e.defined.Lookup(name)
+	if tmpl == nil || tmpl.Tree == nil {
+		return false
+	}
+	return e.referencesValues(tmpl.Tree.Root, visited)
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_ReferencesValues(t *testing.T) {
+	e := New()
+	require.NoError(t, e.Define("test", `{{ define "resources" }}{{ .Values.cpu }}m{{ end }}{{ define "name" }}{{ .Trial.Name }}{{ end }}`))
+
+	cases := []struct {
+		desc     string
+		text     string
+		expected bool
+	}{
+		{desc: "plain", text: `replicas: 3`},
+		{desc: "trial", text: `name: {{ .Trial.Name }}`},
+		{desc: "field", text: `cpu: {{ .Values.cpu }}`, expected: true},
+		{desc: "root variable", text: `{{ range $k, $v := .Experiment.Labels }}{{ $.Values.cpu }}{{ end }}`, expected: true},
+		{desc: "function", text: `memory: {{ percent .Values.memory 50 }}Mi`, expected: true},
+		{desc: "condition", text: `{{ if gt .Values.cpu 500 }}large{{ else }}small{{ end }}`, expected: true},
+		{desc: "template", text: `cpu: {{ template "resources" . }}`, expected: true},
+		{desc: "include", text: `cpu: {{ include "resources" . | quote }}`, expected: true},
+		{desc: "include trial", text: `name: {{ include "name" . }}`},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			actual, err := e.ReferencesValues(c.desc, c.text)
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.expected, actual)
+			}
+		})
+	}
+}
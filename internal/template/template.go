@@ -20,12 +20,18 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
 	"time"
 
+	"github.com/redskyops/redskyops-controller/internal/trial"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/yaml"
 )
@@ -34,19 +40,27 @@ import (
 type PatchData struct {
 	// Trial metadata
 	Trial metav1.ObjectMeta
+	// The ordinal of the trial taken from the report URL, zero if it is not known
+	TrialNumber int64
+	// Experiment metadata
+	Experiment metav1.ObjectMeta
+	// The namespace of the trial
+	Namespace string
 	// Trial assignments
 	Values map[string]int64
+	// The current state of the patch target (only available for patches with an explicit target)
+	Target map[string]interface{}
 }
 
 // MetricData represents a trial during metric evaluation
 type MetricData struct {
 	// Trial metadata
 	Trial metav1.ObjectMeta
-	// The time at which the trial run started (possibly adjusted)
+	// The time at which the measurement window of the trial run started (possibly adjusted)
 	StartTime time.Time
-	// The time at which the trial run completed
+	// The time at which the measurement window of the trial run ended
 	CompletionTime time.Time
-	// The duration of the trial run expressed as a Prometheus range value
+	// The duration of the measurement window expressed as a Prometheus range value
 	Range string
 	// Trial assignments
 	Values map[string]int64
@@ -54,14 +68,29 @@ type MetricData struct {
 	Pods *corev1.PodList
 }
 
-func newPatchData(t *redskyv1alpha1.Trial) *PatchData {
+func newPatchData(t *redskyv1alpha1.Trial, exp *redskyv1alpha1.Experiment, target *unstructured.Unstructured) *PatchData {
 	d := &PatchData{}
 
 	t.ObjectMeta.DeepCopyInto(&d.Trial)
+	d.Namespace = t.Namespace
 
-	d.Values = make(map[string]int64, len(t.Spec.Assignments))
-	for _, a := range t.Spec.Assignments {
-		d.Values[a.Name] = a.Value
+	if num, err := strconv.ParseInt(path.Base(t.GetAnnotations()[redskyv1alpha1.AnnotationReportTrialURL]), 10, 64); err == nil {
+		d.TrialNumber = num
+	}
+
+	// Without the experiment we can still identify it using the trial
+	if exp != nil {
+		exp.ObjectMeta.DeepCopyInto(&d.Experiment)
+	} else {
+		nn := t.ExperimentNamespacedName()
+		d.Experiment.Name = nn.Name
+		d.Experiment.Namespace = nn.Namespace
+	}
+
+	d.Values = assignmentValues(t)
+
+	if target != nil {
+		d.Target = target.DeepCopy().UnstructuredContent()
 	}
 
 	return d
@@ -72,49 +101,130 @@ func newMetricData(t *redskyv1alpha1.Trial, target runtime.Object) *MetricData {
 
 	t.ObjectMeta.DeepCopyInto(&d.Trial)
 
-	d.Values = make(map[string]int64, len(t.Spec.Assignments))
-	for _, a := range t.Spec.Assignments {
-		d.Values[a.Name] = a.Value
-	}
+	d.Values = assignmentValues(t)
 
 	if pods, ok := target.(*corev1.PodList); ok {
 		d.Pods = pods
 	}
 
-	if t.Status.StartTime != nil {
-		d.StartTime = t.Status.StartTime.Time
-	}
-
-	if t.Status.CompletionTime != nil {
-		d.CompletionTime = t.Status.CompletionTime.Time
-	}
+	d.StartTime, d.CompletionTime = trial.MeasurementTimes(t)
 
 	d.Range = fmt.Sprintf("%.0fs", math.Max(d.CompletionTime.Sub(d.StartTime).Seconds(), 0))
 
 	return d
 }
 
+// ConfigMapLookup returns the data of the named config map
+type ConfigMapLookup func(namespace, name string) (map[string]string, error)
+
+// assignmentValues returns the assignments and derived assignments of a trial indexed by name
+func assignmentValues(t *redskyv1alpha1.Trial) map[string]int64 {
+	values := make(map[string]int64, len(t.Spec.Assignments)+len(t.Spec.DerivedAssignments))
+	for _, a := range t.Spec.Assignments {
+		values[a.Name] = a.Value
+	}
+	for _, a := range t.Spec.DerivedAssignments {
+		values[a.Name] = a.Value
+	}
+	return values
+}
+
 // Engine is used to render Go text templates
 type Engine struct {
 	FuncMap template.FuncMap
+	// ConfigMaps is used by the "lookup" function, if not set lookups always produce an empty value
+	ConfigMaps ConfigMapLookup
+
+	// defined holds the named templates which can be included by any rendered template
+	defined *template.Template
 }
 
 // New creates a new template engine
 func New() *Engine {
-	f := FuncMap()
-	return &Engine{
-		FuncMap: f,
+	e := &Engine{
+		FuncMap: FuncMap(),
 	}
+	e.FuncMap["lookup"] = e.lookup(nil)
+	e.FuncMap["include"] = func(string, interface{}) (string, error) { return "", fmt.Errorf("include is not available") }
+	return e
 }
 
-// TODO Investigate better use of template names
-// Would it be possible to have the template engine hold more scope? e.g. create the template engine using the full list
-// of patch templates or metrics (or the experiment itself, trial for HelmValues) and then render the individual values by template name?
+// lookup returns a function for looking up a single value from a config map, only config maps from the supplied
+// namespaces can be read
+func (e *Engine) lookup(namespaces []string) func(namespace, name, key string) (string, error) {
+	return func(namespace, name, key string) (string, error) {
+		if e.ConfigMaps == nil {
+			return "", nil
+		}
+		allowed := false
+		for _, ns := range namespaces {
+			allowed = allowed || ns == namespace
+		}
+		if !allowed {
+			return "", fmt.Errorf("lookup of config map '%s' is not allowed in namespace '%s'", name, namespace)
+		}
+		data, err := e.ConfigMaps(namespace, name)
+		if err != nil {
+			return "", err
+		}
+		return data[key], nil
+	}
+}
+
+// lookupNamespaces returns the namespaces config maps can be looked up in while rendering a template for the supplied
+// trial, i.e. the namespaces of the trial and of the experiment
+func lookupNamespaces(t *redskyv1alpha1.Trial) []string {
+	return []string{t.Namespace, t.ExperimentNamespacedName().Namespace}
+}
 
-// RenderPatch returns the JSON representation of the supplied patch template (input can be a Go template that produces YAML)
-func (e *Engine) RenderPatch(patch *redskyv1alpha1.PatchTemplate, trial *redskyv1alpha1.Trial) ([]byte, error) {
-	data := newPatchData(trial)
-	b, err := e.render("patch", patch.Patch, data) // TODO What should we use for patch template names? Something from the targetRef?
+// Define parses the supplied text for named template definitions which can then be included by any template
+// subsequently rendered by this engine
+func (e *Engine) Define(name, text string) error {
+	if e.defined == nil {
+		e.defined = template.New("").Funcs(e.FuncMap)
+	}
+	_, err := e.defined.New(name).Parse(text)
+	return err
+}
+
+// DefineExperimentTemplates defines the named templates from the experiment; templates from config maps are only
+// defined if the engine can lookup config maps
+func (e *Engine) DefineExperimentTemplates(exp *redskyv1alpha1.Experiment) error {
+	if exp.Spec.Templates != "" {
+		if err := e.Define(exp.Name, exp.Spec.Templates); err != nil {
+			return err
+		}
+	}
+
+	for _, tf := range exp.Spec.TemplatesFrom {
+		if tf.ConfigMap == nil || e.ConfigMaps == nil {
+			continue
+		}
+		data, err := e.ConfigMaps(exp.Namespace, tf.ConfigMap.Name)
+		if err != nil {
+			return err
+		}
+
+		// Use a consistent order in case the same template is defined more then once
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := e.Define(tf.ConfigMap.Name+"/"+k, data[k]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RenderPatch returns the JSON representation of the supplied patch template (input can be a Go template that produces
+// YAML); the experiment and the current state of the patch target are optional
+func (e *Engine) RenderPatch(patch *redskyv1alpha1.PatchTemplate, trial *redskyv1alpha1.Trial, exp *redskyv1alpha1.Experiment, target *unstructured.Unstructured) ([]byte, error) {
+	data := newPatchData(trial, exp, target)
+	b, err := e.render("patch", patch.Patch, data, lookupNamespaces(trial)) // TODO What should we use for patch template names? Something from the targetRef?
 	if err != nil {
 		return nil, err
 	}
@@ -123,30 +233,79 @@ func (e *Engine) RenderPatch(patch *redskyv1alpha1.PatchTemplate, trial *redskyv
 
 // RenderHelmValue returns a rendered string of the supplied Helm value
 func (e *Engine) RenderHelmValue(helmValue *redskyv1alpha1.HelmValue, trial *redskyv1alpha1.Trial) (string, error) {
-	data := newPatchData(trial)
-	b, err := e.render(helmValue.Name, helmValue.Value.String(), data)
+	data := newPatchData(trial, nil, nil)
+	b, err := e.render(helmValue.Name, helmValue.Value.String(), data, lookupNamespaces(trial))
 	if err != nil {
 		return "", err
 	}
 	return b.String(), nil
 }
 
+// RenderManifest returns the rendered contents of a setup task manifest
+func (e *Engine) RenderManifest(name, manifest string, trial *redskyv1alpha1.Trial) ([]byte, error) {
+	data := newPatchData(trial, nil, nil)
+	b, err := e.render(name, manifest, data, lookupNamespaces(trial))
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// RenderDerivedParameter returns the value of a derived parameter computed from the trial assignments
+func (e *Engine) RenderDerivedParameter(dp *redskyv1alpha1.DerivedParameter, trial *redskyv1alpha1.Trial) (int64, error) {
+	data := newPatchData(trial, nil, nil)
+	b, err := e.render(dp.Name, dp.Expression, data, lookupNamespaces(trial))
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(b.String()), 64)
+	if err != nil {
+		return 0, fmt.Errorf("derived parameter '%s' is not a number: %v", dp.Name, err)
+	}
+	return int64(math.Round(v)), nil
+}
+
 // RenderMetricQueries returns the metric query and the metric error query
 func (e *Engine) RenderMetricQueries(metric *redskyv1alpha1.Metric, trial *redskyv1alpha1.Trial, target runtime.Object) (string, string, error) {
 	data := newMetricData(trial, target)
-	b1, err := e.render(metric.Name, metric.Query, data)
+	b1, err := e.render(metric.Name, metric.Query, data, lookupNamespaces(trial))
 	if err != nil {
 		return "", "", err
 	}
-	b2, err := e.render(metric.Name, metric.ErrorQuery, data)
+	b2, err := e.render(metric.Name, metric.ErrorQuery, data, lookupNamespaces(trial))
 	if err != nil {
 		return "", "", err
 	}
 	return b1.String(), b2.String(), nil
 }
 
-func (e *Engine) render(name, text string, data interface{}) (*bytes.Buffer, error) {
-	tmpl, err := template.New(name).Funcs(e.FuncMap).Parse(text)
+func (e *Engine) render(name, text string, data interface{}, namespaces []string) (*bytes.Buffer, error) {
+	tmpl := template.New(name).Funcs(e.FuncMap)
+	if e.defined != nil {
+		// Clone the named templates so they are not modified by the rendered template
+		defined, err := e.defined.Clone()
+		if err != nil {
+			return nil, err
+		}
+
+		// Do not allow the rendered template to replace a named template with the same name
+		if defined.Lookup(name) != nil {
+			name = "_" + name
+		}
+		tmpl = defined.New(name)
+	}
+
+	// Like Helm, "include" allows the output of named templates to be used in pipelines
+	tmpl.Funcs(template.FuncMap{"include": func(name string, data interface{}) (string, error) {
+		b := &bytes.Buffer{}
+		err := tmpl.ExecuteTemplate(b, name, data)
+		return b.String(), err
+	}})
+
+	// Config maps can only be looked up in the namespaces of the trial being rendered
+	tmpl.Funcs(template.FuncMap{"lookup": e.lookup(namespaces)})
+
+	tmpl, err := tmpl.Parse(text)
 	if err != nil {
 		return nil, err
 	}
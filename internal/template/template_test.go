@@ -0,0 +1,134 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"testing"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestEngine_RenderPatch(t *testing.T) {
+	trial := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-007",
+			Namespace:   "default",
+			Labels:      map[string]string{redskyv1alpha1.LabelExperiment: "test"},
+			Annotations: map[string]string{redskyv1alpha1.AnnotationReportTrialURL: "http://example.com/experiments/test/trials/7"},
+		},
+		Spec: redskyv1alpha1.TrialSpec{
+			Assignments: []redskyv1alpha1.Assignment{{Name: "memory", Value: 1000}, {Name: "cpu", Value: 1500}},
+		},
+	}
+	target := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": int64(3)},
+	}}
+
+	cases := []struct {
+		desc     string
+		patch    string
+		expected string
+	}{
+		{
+			desc:     "context",
+			patch:    `{"metadata":{"labels":{"trial":"{{ .TrialNumber }}","experiment":"{{ .Experiment.Name }}","namespace":"{{ .Namespace }}"}}}`,
+			expected: `{"metadata":{"labels":{"trial":"7","experiment":"test","namespace":"default"}}}`,
+		},
+		{
+			desc:     "target",
+			patch:    `{"spec":{"replicas":{{ .Target.spec.replicas }}}}`,
+			expected: `{"spec":{"replicas":3}}`,
+		},
+		{
+			desc:     "memory limit",
+			patch:    `{"limits":{"memory":"{{ printf "%dMi" .Values.memory | toMi | mulf 1.5 | roundUp 64 }}Mi"}}`,
+			expected: `{"limits":{"memory":"1536Mi"}}`,
+		},
+		{
+			desc:     "cpu clamp",
+			patch:    `{"limits":{"cpu":"{{ toMillicores "2" | clamp 100 1000 }}m"}}`,
+			expected: `{"limits":{"cpu":"1000m"}}`,
+		},
+		{
+			desc:     "lookup",
+			patch:    `{"data":{"value":"{{ lookup .Namespace "config" "key" }}"}}`,
+			expected: `{"data":{"value":"test-value"}}`,
+		},
+	}
+
+	e := New()
+	e.ConfigMaps = func(namespace, name string) (map[string]string, error) {
+		return map[string]string{"key": "test-value"}, nil
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			data, err := e.RenderPatch(&redskyv1alpha1.PatchTemplate{Patch: c.patch}, trial, nil, target)
+			if assert.NoError(t, err) {
+				assert.JSONEq(t, c.expected, string(data))
+			}
+		})
+	}
+
+	// Config maps outside of the trial and experiment namespaces cannot be read
+	_, err := e.RenderPatch(&redskyv1alpha1.PatchTemplate{Patch: `{"data":{"value":"{{ lookup "kube-system" "config" "key" }}"}}`}, trial, nil, target)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "lookup of config map 'config' is not allowed in namespace 'kube-system'")
+	}
+}
+
+func TestEngine_DefineExperimentTemplates(t *testing.T) {
+	exp := &redskyv1alpha1.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: redskyv1alpha1.ExperimentSpec{
+			Templates: `{{ define "resources" }}{"cpu":"{{ .Values.cpu }}m"}{{ end }}`,
+			TemplatesFrom: []redskyv1alpha1.TemplatesFromSource{
+				{ConfigMap: &corev1.LocalObjectReference{Name: "shared"}},
+			},
+		},
+	}
+	trial := &redskyv1alpha1.Trial{
+		Spec: redskyv1alpha1.TrialSpec{
+			Assignments: []redskyv1alpha1.Assignment{{Name: "cpu", Value: 500}},
+		},
+	}
+
+	e := New()
+	e.ConfigMaps = func(namespace, name string) (map[string]string, error) {
+		return map[string]string{"limits.tpl": `{{ define "limits" }}{"cpu":"{{ mul .Values.cpu 2 }}m"}{{ end }}`}, nil
+	}
+	if !assert.NoError(t, e.DefineExperimentTemplates(exp)) {
+		return
+	}
+
+	patch := &redskyv1alpha1.PatchTemplate{Patch: `{"requests":{{ template "resources" . }},"limits":{{ include "limits" . | trim }}}`}
+	data, err := e.RenderPatch(patch, trial, exp, nil)
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"requests":{"cpu":"500m"},"limits":{"cpu":"1000m"}}`, string(data))
+	}
+
+	// A rendered template with the same name as a definition must not replace it
+	hv := &redskyv1alpha1.HelmValue{Name: "resources", Value: intstr.FromString(`{{ template "resources" . }}`)}
+	value, err := e.RenderHelmValue(hv, trial)
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"cpu":"500m"}`, value)
+	}
+}
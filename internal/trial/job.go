@@ -26,12 +26,11 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/strategicpatch"
 )
 
-// NewJob returns a new trial run job from the template on the trial
-func NewJob(t *redskyv1alpha1.Trial) *batchv1.Job {
+// NewJob returns a new trial run job from the template on the trial, an error is returned if a patch targeting the
+// trial job cannot be applied
+func NewJob(t *redskyv1alpha1.Trial) (*batchv1.Job, error) {
 	job := &batchv1.Job{}
 
 	// Start with the job template
@@ -78,9 +77,7 @@ func NewJob(t *redskyv1alpha1.Trial) *batchv1.Job {
 	}
 
 	// Check to see if there is patch for the (as of yet, non-existent) trial job
-	job = patchSelf(t, job)
-
-	return job
+	return patchSelf(t, job)
 }
 
 func addDefaultContainer(t *redskyv1alpha1.Trial, job *batchv1.Job) {
@@ -104,21 +101,30 @@ func addDefaultContainer(t *redskyv1alpha1.Trial, job *batchv1.Job) {
 	}
 }
 
-func patchSelf(t *redskyv1alpha1.Trial, job *batchv1.Job) *batchv1.Job {
-	// Look for patch operations that match this trial and apply them
+// patchSelf applies the patch operations targeting the trial job, all patch types are supported
+func patchSelf(t *redskyv1alpha1.Trial, job *batchv1.Job) (*batchv1.Job, error) {
 	for i := range t.Spec.PatchOperations {
 		po := &t.Spec.PatchOperations[i]
-		if IsTrialJobReference(t, &po.TargetRef) && po.PatchType == types.StrategicMergePatchType {
-			// Ignore errors all the way down, only overwrite the job if everything is successful
-			if original, err := json.Marshal(job); err == nil {
-				j := &batchv1.Job{}
-				if patched, err := strategicpatch.StrategicMergePatch(original, po.Data, j); err == nil {
-					if err := json.Unmarshal(patched, j); err == nil {
-						return j
-					}
-				}
-			}
+		if !IsTrialJobReference(t, &po.TargetRef) {
+			continue
+		}
+
+		original, err := json.Marshal(job)
+		if err != nil {
+			return nil, err
+		}
+
+		// Server-side apply is approximated using a strategic merge since the job does not exist yet
+		patched, err := meta.ApplyPatch(original, po.PatchType, po.Data, &batchv1.Job{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to patch trial job: %v", err)
+		}
+
+		j := &batchv1.Job{}
+		if err := json.Unmarshal(patched, j); err != nil {
+			return nil, fmt.Errorf("unable to patch trial job: %v", err)
 		}
+		job = j
 	}
-	return job
+	return job, nil
 }
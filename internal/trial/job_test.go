@@ -0,0 +1,125 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"testing"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	redskyv1alpha2 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha2"
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNewJob_PatchSelf(t *testing.T) {
+	template := &batchv1beta1.JobTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Name: "load"},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "load", Image: "load", Args: []string{"--users", "1"}}},
+				},
+			},
+		},
+	}
+	ref := corev1.ObjectReference{Kind: "Job", Name: "load"}
+
+	cases := []struct {
+		desc         string
+		patchType    types.PatchType
+		data         string
+		expectedArgs []string
+		expectedErr  bool
+	}{
+		{
+			desc:         "strategic",
+			patchType:    types.StrategicMergePatchType,
+			data:         `{"spec":{"template":{"spec":{"containers":[{"name":"load","args":["--users","10"]}]}}}}`,
+			expectedArgs: []string{"--users", "10"},
+		},
+		{
+			desc:         "merge",
+			patchType:    types.MergePatchType,
+			data:         `{"spec":{"backoffLimit":1}}`,
+			expectedArgs: []string{"--users", "1"},
+		},
+		{
+			desc:         "json",
+			patchType:    types.JSONPatchType,
+			data:         `[{"op":"replace","path":"/spec/template/spec/containers/0/args/1","value":"20"}]`,
+			expectedArgs: []string{"--users", "20"},
+		},
+		{
+			desc:        "invalid",
+			patchType:   types.JSONPatchType,
+			data:        `[{"op":"replace","path":"/spec/missing/0","value":"20"}]`,
+			expectedErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			tr := &redskyv1alpha1.Trial{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				Spec: redskyv1alpha1.TrialSpec{
+					Template:        template.DeepCopy(),
+					PatchOperations: []redskyv1alpha1.PatchOperation{{TargetRef: ref, PatchType: c.patchType, Data: []byte(c.data)}},
+				},
+			}
+
+			job, err := NewJob(tr)
+			if c.expectedErr {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, c.expectedArgs, job.Spec.Template.Spec.Containers[0].Args)
+			}
+		})
+	}
+}
+
+func TestNewJob_V1alpha2(t *testing.T) {
+	in := &redskyv1alpha2.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: redskyv1alpha2.TrialSpec{
+			Template: &redskyv1alpha2.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "load"}},
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Name: "load", Image: "load"}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	tr := &redskyv1alpha1.Trial{}
+	if assert.NoError(t, in.ConvertTo(tr)) {
+		assert.Equal(t, redskyv1alpha1.GroupVersion.String(), tr.APIVersion)
+
+		job, err := NewJob(tr)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "test", job.Name)
+			assert.Equal(t, "load", job.Labels["app"])
+			assert.Equal(t, "load", job.Spec.Template.Spec.Containers[0].Image)
+		}
+	}
+}
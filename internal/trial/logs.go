@@ -0,0 +1,83 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"sort"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// MaxLogsSize is the maximum number of bytes of pod logs archived for a single trial
+	MaxLogsSize = 512 * 1024
+	// LogsTailLines is the number of lines requested from the end of each container log
+	LogsTailLines = 2000
+
+	// truncatedMarker is prefixed to logs which were too large to archive in their entirety
+	truncatedMarker = "... (truncated)\n"
+)
+
+// LogsConfigMapName returns the name of the config map used to archive the pod logs of a trial
+func LogsConfigMapName(t *redskyv1alpha1.Trial) string {
+	return t.Name + "-logs"
+}
+
+// LogsKey returns the config map key used to archive the logs of a single container
+func LogsKey(pod, container string) string {
+	return pod + "." + container + ".log"
+}
+
+// NewLogsConfigMap returns a config map archiving the supplied container logs (keyed using `LogsKey`); if the combined
+// size of the logs exceeds the limit, each log is truncated to an equal share of the limit keeping the most recent output
+func NewLogsConfigMap(t *redskyv1alpha1.Trial, logs map[string][]byte, limit int) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{}
+	cm.Name = LogsConfigMapName(t)
+	cm.Namespace = t.Namespace
+	cm.Labels = map[string]string{
+		redskyv1alpha1.LabelExperiment: t.ExperimentNamespacedName().Name,
+		redskyv1alpha1.LabelTrial:      t.Name,
+	}
+	cm.Data = make(map[string]string, len(logs))
+
+	// Determine how much of each log we can keep
+	var size int
+	keys := make([]string, 0, len(logs))
+	for k, v := range logs {
+		size += len(v)
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	share := limit
+	if size > limit && len(keys) > 0 {
+		share = limit / len(keys)
+	}
+
+	for _, k := range keys {
+		v := logs[k]
+		if len(v) > share {
+			keep := share - len(truncatedMarker)
+			if keep < 0 {
+				keep = 0
+			}
+			v = append([]byte(truncatedMarker), v[len(v)-keep:]...)
+		}
+		cm.Data[k] = string(v)
+	}
+	return cm
+}
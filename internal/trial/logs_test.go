@@ -0,0 +1,67 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"strings"
+	"testing"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewLogsConfigMap(t *testing.T) {
+	tr := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001", Namespace: "default", Labels: map[string]string{redskyv1alpha1.LabelExperiment: "test"}},
+	}
+
+	cases := []struct {
+		desc     string
+		logs     map[string][]byte
+		limit    int
+		expected map[string]string
+	}{
+		{
+			desc:     "under limit",
+			logs:     map[string][]byte{LogsKey("test-001-abc", "load"): []byte("done\n")},
+			limit:    100,
+			expected: map[string]string{"test-001-abc.load.log": "done\n"},
+		},
+		{
+			desc: "over limit",
+			logs: map[string][]byte{
+				LogsKey("test-001-abc", "load"):     []byte(strings.Repeat("x", 50) + "done\n"),
+				LogsKey("test-001-create", "setup"): []byte("ok\n"),
+			},
+			limit: 50,
+			expected: map[string]string{
+				"test-001-abc.load.log":     truncatedMarker + "xxxx" + "done\n",
+				"test-001-create.setup.log": "ok\n",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			cm := NewLogsConfigMap(tr, c.logs, c.limit)
+			assert.Equal(t, "test-001-logs", cm.Name)
+			assert.Equal(t, "default", cm.Namespace)
+			assert.Equal(t, "test-001", cm.Labels[redskyv1alpha1.LabelTrial])
+			assert.Equal(t, c.expected, cm.Data)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultRetryLimit is the number of times assignments are re-run after an infrastructure failure if the trial does
+// not specify a limit
+const DefaultRetryLimit = 2
+
+// infrastructureFailureReasons are the failure reasons attributed to the cluster rather than the assignments, only
+// reasons actually reported by the pod status (see `ready.PodFailure`) or by a fatal event are useful here
+var infrastructureFailureReasons = map[string]bool{
+	"Evicted":          true, // e.g. node pressure or a drained node
+	"ImagePullBackOff": true, // e.g. a registry outage or rate limit
+}
+
+// IsInfrastructureFailureReason checks to see if the supplied failure reason is attributed to the cluster rather than
+// the assignments
+func IsInfrastructureFailureReason(reason string) bool {
+	return infrastructureFailureReasons[reason]
+}
+
+// IsInfrastructureFailure checks to see if the trial failed for a reason attributed to the cluster rather than the
+// assignments, e.g. a pod being evicted; all other failures are considered to be configuration failures
+func IsInfrastructureFailure(t *redskyv1alpha1.Trial) bool {
+	for _, c := range t.Status.Conditions {
+		if c.Type == redskyv1alpha1.TrialFailed && c.Status == corev1.ConditionTrue {
+			return IsInfrastructureFailureReason(c.Reason)
+		}
+	}
+	return false
+}
+
+// Retries returns the number of times the assignments of the trial were previously run
+func Retries(t *redskyv1alpha1.Trial) int {
+	n, _ := strconv.Atoi(t.GetAnnotations()[redskyv1alpha1.AnnotationRetries])
+	return n
+}
+
+// ShouldRetry checks to see if the assignments of a failed trial should be re-run in a new trial instead of reporting
+// the failure
+func ShouldRetry(t *redskyv1alpha1.Trial) bool {
+	limit := DefaultRetryLimit
+	if t.Spec.RetryLimit != nil {
+		limit = int(*t.Spec.RetryLimit)
+	}
+	return IsInfrastructureFailure(t) && Retries(t) < limit
+}
+
+// PopulateRetry copies the identity and assignments of a failed trial into a new trial (which should already be
+// populated from the experiment template); the namespace of the new trial is not changed
+func PopulateRetry(t, retry *redskyv1alpha1.Trial) {
+	n := Retries(t)
+	name := t.Name
+	if n > 0 {
+		name = strings.TrimSuffix(name, fmt.Sprintf("-retry%d", n))
+	}
+
+	retry.Name = fmt.Sprintf("%s-retry%d", name, n+1)
+	retry.GenerateName = ""
+	if retry.Annotations == nil {
+		retry.Annotations = make(map[string]string)
+	}
+	retry.Annotations[redskyv1alpha1.AnnotationReportTrialURL] = t.Annotations[redskyv1alpha1.AnnotationReportTrialURL]
+	retry.Annotations[redskyv1alpha1.AnnotationRetries] = strconv.Itoa(n + 1)
+	retry.Spec.Assignments = append([]redskyv1alpha1.Assignment(nil), t.Spec.Assignments...)
+	retry.Spec.DerivedAssignments = append([]redskyv1alpha1.Assignment(nil), t.Spec.DerivedAssignments...)
+	UpdateStatus(retry)
+}
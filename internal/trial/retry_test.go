@@ -0,0 +1,139 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"testing"
+
+	"github.com/redskyops/redskyops-controller/internal/ready"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestShouldRetry(t *testing.T) {
+	zero := int32(0)
+	cases := []struct {
+		desc     string
+		reason   string
+		retries  string
+		limit    *int32
+		expected bool
+	}{
+		{desc: "evicted", reason: "Evicted", expected: true},
+		{desc: "configuration", reason: "OOMKilled"},
+		{desc: "retried", reason: "Evicted", retries: "1", expected: true},
+		{desc: "exhausted", reason: "Evicted", retries: "2"},
+		{desc: "disabled", reason: "Evicted", limit: &zero},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			tr := &redskyv1alpha1.Trial{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{redskyv1alpha1.AnnotationRetries: c.retries}},
+				Spec:       redskyv1alpha1.TrialSpec{RetryLimit: c.limit},
+				Status: redskyv1alpha1.TrialStatus{
+					Conditions: []redskyv1alpha1.TrialCondition{{Type: redskyv1alpha1.TrialFailed, Status: corev1.ConditionTrue, Reason: c.reason}},
+				},
+			}
+			assert.Equal(t, c.expected, ShouldRetry(tr))
+		})
+	}
+}
+
+func TestIsInfrastructureFailure(t *testing.T) {
+	// The reasons must match what is actually reported for a failed pod
+	evicted := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001-abcde"},
+		Status: corev1.PodStatus{
+			Phase:   corev1.PodFailed,
+			Reason:  "Evicted",
+			Message: "The node was low on resource: memory.",
+		},
+	}
+	imagePull := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001-abcde"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "main",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"}},
+			}},
+		},
+	}
+	oomKilled := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001-abcde"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodFailed,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "main",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+			}},
+		},
+	}
+
+	cases := []struct {
+		desc     string
+		pod      *corev1.Pod
+		expected bool
+	}{
+		{desc: "evicted", pod: evicted, expected: true},
+		{desc: "image pull", pod: imagePull, expected: true},
+		{desc: "oom killed", pod: oomKilled},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			reason, msg := ready.PodFailure(c.pod)
+			tr := &redskyv1alpha1.Trial{}
+			ApplyCondition(&tr.Status, redskyv1alpha1.TrialFailed, corev1.ConditionTrue, reason, msg, nil)
+			assert.Equal(t, c.expected, IsInfrastructureFailure(tr))
+		})
+	}
+
+	// The eviction may also be reported by an event
+	e := &corev1.Event{Type: corev1.EventTypeWarning, Reason: "Evicted", Message: "The node was low on resource: memory."}
+	if assert.True(t, ready.IsFatalEvent(e)) {
+		assert.True(t, IsInfrastructureFailureReason(e.Reason))
+	}
+}
+
+func TestPopulateRetry(t *testing.T) {
+	tr := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-001",
+			Annotations: map[string]string{redskyv1alpha1.AnnotationReportTrialURL: "http://example.com/experiments/test/trials/1"},
+		},
+		Spec: redskyv1alpha1.TrialSpec{
+			Assignments:     []redskyv1alpha1.Assignment{{Name: "cpu", Value: 100}},
+			PatchOperations: []redskyv1alpha1.PatchOperation{{AttemptsRemaining: 0}},
+		},
+	}
+
+	retry := &redskyv1alpha1.Trial{ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"}}
+	PopulateRetry(tr, retry)
+	assert.Equal(t, "test-001-retry1", retry.Name)
+	assert.Equal(t, "", retry.GenerateName)
+	assert.Equal(t, "1", retry.Annotations[redskyv1alpha1.AnnotationRetries])
+	assert.Equal(t, tr.Annotations[redskyv1alpha1.AnnotationReportTrialURL], retry.Annotations[redskyv1alpha1.AnnotationReportTrialURL])
+	assert.Equal(t, tr.Spec.Assignments, retry.Spec.Assignments)
+	assert.Empty(t, retry.Spec.PatchOperations)
+
+	again := &redskyv1alpha1.Trial{}
+	PopulateRetry(retry, again)
+	assert.Equal(t, "test-001-retry2", again.Name)
+	assert.Equal(t, "2", again.Annotations[redskyv1alpha1.AnnotationRetries])
+}
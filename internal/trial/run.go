@@ -0,0 +1,93 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/redskyops/redskyops-controller/internal/meta"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const (
+	// DefaultStartTimePath is the default location of the start time of a trial run
+	DefaultStartTimePath = "{.status.startTime}"
+	// DefaultCompletionTimePath is the default location of the completion time of a trial run
+	DefaultCompletionTimePath = "{.status.completionTime}"
+)
+
+// NewRun returns a new trial run object from the run template on the trial
+func NewRun(t *redskyv1alpha1.Trial) (*unstructured.Unstructured, error) {
+	if t.Spec.RunTemplate == nil {
+		return nil, fmt.Errorf("trial has no run template")
+	}
+
+	// Without completion conditions the trial run would be polled until the trial deadline
+	if len(t.Spec.RunTemplate.CompletedConditionTypes) == 0 {
+		return nil, fmt.Errorf("trial run template has no completion conditions configured")
+	}
+
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(t.Spec.RunTemplate.Object.Raw); err != nil {
+		return nil, fmt.Errorf("invalid trial run template: %v", err)
+	}
+
+	// Apply labels to the run itself
+	meta.AddLabel(u, redskyv1alpha1.LabelExperiment, t.ExperimentNamespacedName().Name)
+	meta.AddLabel(u, redskyv1alpha1.LabelTrial, t.Name)
+	meta.AddLabel(u, redskyv1alpha1.LabelTrialRole, "trialRun")
+
+	// Provide default metadata
+	u.SetNamespace(t.Namespace)
+	if u.GetName() == "" && u.GetGenerateName() == "" {
+		u.SetName(t.Name)
+	}
+
+	return u, nil
+}
+
+// RunTime returns the time found at the supplied JSONPath of the trial run, a nil time is returned if the path does
+// not match anything
+func RunTime(u *unstructured.Unstructured, path string) (*metav1.Time, error) {
+	jp := jsonpath.New("time").AllowMissingKeys(true)
+	if err := jp.Parse(path); err != nil {
+		return nil, err
+	}
+	results, err := jp.FindResults(u.UnstructuredContent())
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		for _, v := range r {
+			s, ok := v.Interface().(string)
+			if !ok || s == "" {
+				continue
+			}
+			tm, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid time at %s: %v", path, err)
+			}
+			mt := metav1.NewTime(tm)
+			return &mt, nil
+		}
+	}
+	return nil, nil
+}
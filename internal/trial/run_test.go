@@ -0,0 +1,80 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"testing"
+	"time"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestNewRun(t *testing.T) {
+	trial := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001", Namespace: "default", Labels: map[string]string{redskyv1alpha1.LabelExperiment: "test"}},
+		Spec: redskyv1alpha1.TrialSpec{
+			RunTemplate: &redskyv1alpha1.TrialRunTemplate{
+				Object:                  runtime.RawExtension{Raw: []byte(`{"apiVersion":"argoproj.io/v1alpha1","kind":"Workflow","metadata":{"namespace":"other"},"spec":{"entrypoint":"load"}}`)},
+				CompletedConditionTypes: []string{"Completed"},
+			},
+		},
+	}
+
+	run, err := NewRun(trial)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Workflow", run.GetKind())
+		assert.Equal(t, "test-001", run.GetName())
+		assert.Equal(t, "default", run.GetNamespace())
+		assert.Equal(t, "trialRun", run.GetLabels()[redskyv1alpha1.LabelTrialRole])
+		assert.Equal(t, "test-001", run.GetLabels()[redskyv1alpha1.LabelTrial])
+	}
+
+	trial.Spec.RunTemplate.Object.Raw = []byte(`{"spec":{}}`)
+	_, err = NewRun(trial)
+	assert.Error(t, err)
+
+	trial.Spec.RunTemplate.Object.Raw = []byte(`{"apiVersion":"argoproj.io/v1alpha1","kind":"Workflow"}`)
+	trial.Spec.RunTemplate.CompletedConditionTypes = nil
+	_, err = NewRun(trial)
+	assert.EqualError(t, err, "trial run template has no completion conditions configured")
+}
+
+func TestRunTime(t *testing.T) {
+	run := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{
+			"startedAt": "2020-05-01T10:00:00Z",
+			"phase":     "Running",
+		},
+	}}
+
+	startedAt, err := RunTime(run, "{.status.startedAt}")
+	if assert.NoError(t, err) && assert.NotNil(t, startedAt) {
+		assert.True(t, startedAt.Time.Equal(time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)))
+	}
+
+	finishedAt, err := RunTime(run, DefaultCompletionTimePath)
+	if assert.NoError(t, err) {
+		assert.Nil(t, finishedAt)
+	}
+
+	_, err = RunTime(run, "{.status.phase}")
+	assert.Error(t, err)
+}
@@ -52,6 +52,7 @@ var (
 		redskyv1alpha1.TrialPatched,
 		redskyv1alpha1.TrialReady,
 		redskyv1alpha1.TrialObserved,
+		redskyv1alpha1.TrialRestored,
 		redskyv1alpha1.TrialComplete,
 		redskyv1alpha1.TrialFailed,
 	}
@@ -43,7 +43,7 @@ func IsAbandoned(t *redskyv1alpha1.Trial) bool {
 	return !IsFinished(t) && !t.GetDeletionTimestamp().IsZero()
 }
 
-// IsActive checks to see if the specified trial and any setup delete tasks are NOT finished
+// IsActive checks to see if the specified trial, any setup delete tasks or the restoration of patched objects are NOT finished
 func IsActive(t *redskyv1alpha1.Trial) bool {
 	// Not finished, definitely active
 	if !IsFinished(t) {
@@ -57,6 +57,13 @@ func IsActive(t *redskyv1alpha1.Trial) bool {
 		}
 	}
 
+	// Check if the patched objects still need to be restored (the TrialRestored status is also optional)
+	for _, c := range t.Status.Conditions {
+		if c.Type == redskyv1alpha1.TrialRestored && c.Status == corev1.ConditionFalse {
+			return true
+		}
+	}
+
 	return false
 }
 
@@ -80,7 +87,7 @@ func IsTrialJobReference(t *redskyv1alpha1.Trial, ref *corev1.ObjectReference) b
 
 	// If the trial job template has name, it must match...
 	if t.Spec.Template != nil && t.Spec.Template.Name != "" {
-		return t.Spec.Template.Name != ref.Name
+		return t.Spec.Template.Name == ref.Name
 	}
 
 	// ...otherwise the trial name must match by prefix
@@ -91,15 +98,95 @@ func IsTrialJobReference(t *redskyv1alpha1.Trial, ref *corev1.ObjectReference) b
 	return true
 }
 
-// AppendAssignmentEnv appends an environment variable for each trial assignment
+// AppendAssignmentEnv appends an environment variable for each trial assignment, including derived assignments
 func AppendAssignmentEnv(t *redskyv1alpha1.Trial, env []corev1.EnvVar) []corev1.EnvVar {
-	for _, a := range t.Spec.Assignments {
-		name := strings.ReplaceAll(strings.ToUpper(a.Name), ".", "_")
-		env = append(env, corev1.EnvVar{Name: name, Value: fmt.Sprintf("%d", a.Value)})
+	for _, assignments := range [][]redskyv1alpha1.Assignment{t.Spec.Assignments, t.Spec.DerivedAssignments} {
+		for _, a := range assignments {
+			name := strings.ReplaceAll(strings.ToUpper(a.Name), ".", "_")
+			env = append(env, corev1.EnvVar{Name: name, Value: fmt.Sprintf("%d", a.Value)})
+		}
 	}
 	return env
 }
 
+// ActiveDeadline returns the time by which the trial must be finished, nil is returned if the trial has no deadline
+func ActiveDeadline(t *redskyv1alpha1.Trial) *metav1.Time {
+	if t.Spec.ActiveDeadlineSeconds == nil || t.CreationTimestamp.IsZero() {
+		return nil
+	}
+	deadline := metav1.NewTime(t.CreationTimestamp.Add(time.Duration(*t.Spec.ActiveDeadlineSeconds) * time.Second))
+	return &deadline
+}
+
+// MeasurementWindow computes the interval of the trial run used to collect metrics from the effective start and
+// completion times of the trial, an error is returned if the measurement window of the trial does not fit
+func MeasurementWindow(t *redskyv1alpha1.Trial) (*metav1.Time, *metav1.Time, error) {
+	if t.Status.StartTime == nil || t.Status.CompletionTime == nil {
+		return nil, nil, fmt.Errorf("trial run has not completed")
+	}
+	start, end := t.Status.StartTime.Time, t.Status.CompletionTime.Time
+
+	if mw := t.Spec.MeasurementWindow; mw != nil {
+		if mw.WarmUp != nil {
+			start = start.Add(mw.WarmUp.Duration)
+		}
+		if mw.CoolDown != nil {
+			end = end.Add(-mw.CoolDown.Duration)
+		}
+		if mw.Duration != nil {
+			if end.Sub(start) < mw.Duration.Duration {
+				return nil, nil, measurementWindowError(t)
+			}
+			if mw.Anchor == redskyv1alpha1.MeasurementWindowEnd {
+				start = end.Add(-mw.Duration.Duration)
+			} else {
+				end = start.Add(mw.Duration.Duration)
+			}
+		}
+	}
+
+	if !start.Before(end) {
+		return nil, nil, measurementWindowError(t)
+	}
+	startTime, completionTime := metav1.NewTime(start), metav1.NewTime(end)
+	return &startTime, &completionTime, nil
+}
+
+// measurementWindowError returns an error describing a measurement window that does not fit in the trial run
+func measurementWindowError(t *redskyv1alpha1.Trial) error {
+	var warmUp, coolDown, duration time.Duration
+	if mw := t.Spec.MeasurementWindow; mw != nil {
+		if mw.WarmUp != nil {
+			warmUp = mw.WarmUp.Duration
+		}
+		if mw.CoolDown != nil {
+			coolDown = mw.CoolDown.Duration
+		}
+		if mw.Duration != nil {
+			duration = mw.Duration.Duration
+		}
+	}
+	return fmt.Errorf("trial run of %s is too short for the measurement window (warm-up %s, cool-down %s, duration %s)",
+		t.Status.CompletionTime.Sub(t.Status.StartTime.Time), warmUp, coolDown, duration)
+}
+
+// MeasurementTimes returns the beginning and end of the interval used to collect metrics, the effective start and
+// completion times of the trial run are used if the measurement window has not been recorded
+func MeasurementTimes(t *redskyv1alpha1.Trial) (time.Time, time.Time) {
+	var start, end time.Time
+	if t.Status.MeasurementStartTime != nil {
+		start = t.Status.MeasurementStartTime.Time
+	} else if t.Status.StartTime != nil {
+		start = t.Status.StartTime.Time
+	}
+	if t.Status.MeasurementCompletionTime != nil {
+		end = t.Status.MeasurementCompletionTime.Time
+	} else if t.Status.CompletionTime != nil {
+		end = t.Status.CompletionTime.Time
+	}
+	return start, end
+}
+
 // NeedsCleanup checks to see if a trial's TTL has expired
 func NeedsCleanup(t *redskyv1alpha1.Trial) bool {
 	// Already deleted or still active, no cleanup necessary
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"testing"
+	"time"
+
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestActiveDeadline(t *testing.T) {
+	created := metav1.NewTime(time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC))
+	seconds := int64(90)
+
+	tr := &redskyv1alpha1.Trial{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: created}}
+	assert.Nil(t, ActiveDeadline(tr))
+
+	tr.Spec.ActiveDeadlineSeconds = &seconds
+	if deadline := ActiveDeadline(tr); assert.NotNil(t, deadline) {
+		assert.True(t, deadline.Time.Equal(created.Add(90*time.Second)))
+	}
+
+	tr.CreationTimestamp = metav1.Time{}
+	assert.Nil(t, ActiveDeadline(tr))
+}
+
+func TestMeasurementWindow(t *testing.T) {
+	started := metav1.NewTime(time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC))
+	completed := metav1.NewTime(started.Add(10 * time.Minute))
+	d := func(d time.Duration) *metav1.Duration { return &metav1.Duration{Duration: d} }
+
+	cases := []struct {
+		desc   string
+		window *redskyv1alpha1.MeasurementWindow
+		start  time.Duration
+		end    time.Duration
+		err    string
+	}{
+		{desc: "default", end: 10 * time.Minute},
+		{desc: "warm-up", window: &redskyv1alpha1.MeasurementWindow{WarmUp: d(time.Minute)}, start: time.Minute, end: 10 * time.Minute},
+		{desc: "cool-down", window: &redskyv1alpha1.MeasurementWindow{WarmUp: d(time.Minute), CoolDown: d(2 * time.Minute)}, start: time.Minute, end: 8 * time.Minute},
+		{desc: "duration start", window: &redskyv1alpha1.MeasurementWindow{WarmUp: d(time.Minute), Duration: d(5 * time.Minute)}, start: time.Minute, end: 6 * time.Minute},
+		{desc: "duration end", window: &redskyv1alpha1.MeasurementWindow{CoolDown: d(time.Minute), Duration: d(5 * time.Minute), Anchor: redskyv1alpha1.MeasurementWindowEnd}, start: 4 * time.Minute, end: 9 * time.Minute},
+		{desc: "duration too long", window: &redskyv1alpha1.MeasurementWindow{WarmUp: d(6 * time.Minute), Duration: d(5 * time.Minute)}, err: "trial run of 10m0s is too short for the measurement window (warm-up 6m0s, cool-down 0s, duration 5m0s)"},
+		{desc: "empty", window: &redskyv1alpha1.MeasurementWindow{WarmUp: d(5 * time.Minute), CoolDown: d(5 * time.Minute)}, err: "trial run of 10m0s is too short for the measurement window (warm-up 5m0s, cool-down 5m0s, duration 0s)"},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			tr := &redskyv1alpha1.Trial{
+				Spec:   redskyv1alpha1.TrialSpec{MeasurementWindow: c.window},
+				Status: redskyv1alpha1.TrialStatus{StartTime: &started, CompletionTime: &completed},
+			}
+			start, end, err := MeasurementWindow(tr)
+			if c.err != "" {
+				assert.EqualError(t, err, c.err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.True(t, start.Time.Equal(started.Add(c.start)), "start %s", start)
+				assert.True(t, end.Time.Equal(started.Add(c.end)), "end %s", end)
+			}
+		})
+	}
+}
+
+func TestIsTrialJobReference(t *testing.T) {
+	named := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001", Namespace: "default"},
+		Spec: redskyv1alpha1.TrialSpec{
+			Template: &batchv1beta1.JobTemplateSpec{ObjectMeta: metav1.ObjectMeta{Name: "load"}},
+		},
+	}
+	unnamed := &redskyv1alpha1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-001", Namespace: "default"},
+	}
+
+	cases := []struct {
+		desc     string
+		trial    *redskyv1alpha1.Trial
+		ref      corev1.ObjectReference
+		expected bool
+	}{
+		{desc: "template name", trial: named, ref: corev1.ObjectReference{Kind: "Job", APIVersion: "batch/v1", Name: "load"}, expected: true},
+		{desc: "other name", trial: named, ref: corev1.ObjectReference{Kind: "Job", APIVersion: "batch/v1", Name: "other"}},
+		{desc: "trial prefix", trial: unnamed, ref: corev1.ObjectReference{Kind: "Job", Name: "test"}, expected: true},
+		{desc: "other prefix", trial: unnamed, ref: corev1.ObjectReference{Kind: "Job", Name: "other"}},
+		{desc: "other namespace", trial: named, ref: corev1.ObjectReference{Kind: "Job", Namespace: "other", Name: "load"}},
+		{desc: "other kind", trial: named, ref: corev1.ObjectReference{Kind: "Deployment", APIVersion: "apps/v1", Name: "load"}},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			assert.Equal(t, c.expected, IsTrialJobReference(c.trial, &c.ref))
+		})
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/redskyops/redskyops-controller/internal/config"
 	"github.com/redskyops/redskyops-controller/internal/version"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	redskyv1alpha2 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha2"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
@@ -42,6 +43,7 @@ func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = redskyv1alpha1.AddToScheme(scheme)
+	_ = redskyv1alpha2.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -51,9 +53,12 @@ func main() {
 
 	var metricsAddr string
 	var enableLeaderElection bool
+	var enableConversionWebhook bool
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&enableConversionWebhook, "enable-conversion-webhook", false,
+		"Enable the conversion webhook for the Red Sky Ops API versions. Requires a serving certificate for the webhook server.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
@@ -67,6 +72,7 @@ func main() {
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
 		LeaderElection:     enableLeaderElection,
+		Port:               9443,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -128,6 +134,24 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "Metric")
 		os.Exit(1)
 	}
+	if err = (&controllers.LogsReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("Logs"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Logs")
+		os.Exit(1)
+	}
+	if enableConversionWebhook {
+		if err = ctrl.NewWebhookManagedBy(mgr).For(&redskyv1alpha2.Experiment{}).Complete(); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Experiment")
+			os.Exit(1)
+		}
+		if err = ctrl.NewWebhookManagedBy(mgr).For(&redskyv1alpha2.Trial{}).Complete(); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Trial")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -41,6 +41,15 @@ type Parameter struct {
 	Max int64 `json:"max,omitempty"`
 }
 
+// DerivedParameter represents a value computed from the assignments of the parameters
+type DerivedParameter struct {
+	// The name of the derived parameter, it must not conflict with the name of a parameter
+	Name string `json:"name"`
+	// A Go Template that evaluates to a number using the trial assignments (and any previously derived values),
+	// e.g. `{{ percent .Values.memory 75 }}`; fractional values are rounded to the nearest integer
+	Expression string `json:"expression"`
+}
+
 // Constraint represents a constraint to the domain of the parameters
 type Constraint struct {
 	// The optional name of the constraint
@@ -83,6 +92,9 @@ type PatchType string
 // MetricType represents the allowable types of metrics
 type MetricType string
 
+// RestorePolicy represents the allowable policies for restoring patched objects
+type RestorePolicy string
+
 const (
 	// Strategic merge patch
 	PatchStrategic PatchType = "strategic"
@@ -90,6 +102,8 @@ const (
 	PatchMerge = "merge"
 	// JSON patch (RFC 6902)
 	PatchJSON = "json"
+	// Server-side apply patch, the patch must be a (partial) object
+	PatchServerSide = "serverSide"
 
 	// Local metrics are Go Templates evaluated against the trial itself. No external service is consulted, primarily
 	// useful for extracting start and completion times.
@@ -104,6 +118,13 @@ const (
 	// JSON path metrics fetch a JSON resource from the matched service. Queries are JSON path expression evaluated against the resource.
 	MetricJSONPath = "jsonpath"
 	// TODO "regex"?
+
+	// Restore patched objects after every trial
+	RestoreAlways RestorePolicy = "always"
+	// Restore patched objects when the experiment is completed or deleted
+	RestoreOnFinish = "onFinish"
+	// Never restore patched objects
+	RestoreNever = "never"
 )
 
 // Metric represents an observable outcome from a trial run
@@ -138,12 +159,15 @@ type PatchReadinessGate struct {
 
 // PatchTemplate defines a target resource and a patch template to apply
 type PatchTemplate struct {
-	// The patch type, one of: json|merge|strategic, default: strategic
+	// The patch type, one of: json|merge|strategic|serverSide, default: strategic
 	Type PatchType `json:"type,omitempty"`
 	// A Go Template that evaluates to valid patch.
 	Patch string `json:"patch"`
 	// Direct reference to the object the patch should be applied to.
 	TargetRef *corev1.ObjectReference `json:"targetRef,omitempty"`
+	// Selector matches the objects the patch should be applied to, mutually exclusive with the target reference
+	// name; the kind and API version of the matched objects are taken from the target reference.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 	// ReadinessGates will be evaluated for patch target readiness. A patch target is ready if all conditions specified
 	// in the readiness gates have a status equal to "True". If no readiness gates are specified, some target types may
 	// have default gates assigned to them. Some condition checks may result in errors, e.g. a condition type of "Ready"
@@ -152,6 +176,12 @@ type PatchTemplate struct {
 	ReadinessGates []PatchReadinessGate `json:"readinessGates,omitempty"`
 }
 
+// TemplatesFromSource is a source of named template definitions
+type TemplatesFromSource struct {
+	// The ConfigMap in the experiment namespace, every value is parsed for template definitions
+	ConfigMap *corev1.LocalObjectReference `json:"configMap,omitempty"`
+}
+
 // NamespaceTemplateSpec is used as a template for creating new namespaces
 type NamespaceTemplateSpec struct {
 	// Standard object metadata
@@ -176,6 +206,8 @@ type ExperimentSpec struct {
 	Optimization []Optimization `json:"optimization,omitempty"`
 	// Parameters defines the search space for the experiment
 	Parameters []Parameter `json:"parameters,omitempty"`
+	// DerivedParameters are computed for each trial from the parameter assignments, they are not part of the search space
+	DerivedParameters []DerivedParameter `json:"derivedParameters,omitempty"`
 	// Constraints defines restrictions on the parameter domain for the experiment
 	Constraints []Constraint `json:"constraints,omitempty"`
 	// Metrics defines the outcomes for the experiment
@@ -183,6 +215,14 @@ type ExperimentSpec struct {
 	// Patches is a sequence of templates written against the experiment parameters that will be used to put the
 	// cluster into the desired state
 	Patches []PatchTemplate `json:"patches,omitempty"`
+	// Templates contains named template definitions (e.g. `{{ define "resources" }}...{{ end }}`) that can be
+	// included by any patch, Helm value or metric query
+	Templates string `json:"templates,omitempty"`
+	// TemplatesFrom references additional named template definitions
+	TemplatesFrom []TemplatesFromSource `json:"templatesFrom,omitempty"`
+	// Restore determines when the original state of patched objects is put back, one of: always|onFinish|never,
+	// default: never
+	Restore RestorePolicy `json:"restore,omitempty"`
 	// NamespaceSelector is used to locate existing namespaces for trials
 	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
 	// NamespaceTemplate can be specified to create new namespaces for trials; if specified created namespaces must be
@@ -207,6 +247,7 @@ type ExperimentStatus struct {
 
 // +genclient
 // +kubebuilder:object:root=true
+// +kubebuilder:storageversion
 
 // Experiment is the Schema for the experiments API
 // +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.phase",description="Experiment status"
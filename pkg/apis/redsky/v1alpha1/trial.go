@@ -45,13 +45,18 @@ func (in *Trial) HasInitializer() bool {
 	return strings.TrimSpace(in.GetAnnotations()[AnnotationInitializer]) != ""
 }
 
-// Returns an assignment value by name
+// Returns an assignment (or derived assignment) value by name
 func (in *Trial) GetAssignment(name string) (int64, bool) {
 	for i := range in.Spec.Assignments {
 		if in.Spec.Assignments[i].Name == name {
 			return in.Spec.Assignments[i].Value, true
 		}
 	}
+	for i := range in.Spec.DerivedAssignments {
+		if in.Spec.DerivedAssignments[i].Name == name {
+			return in.Spec.DerivedAssignments[i].Value, true
+		}
+	}
 	return 0, false
 }
 
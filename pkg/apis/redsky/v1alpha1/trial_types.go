@@ -21,6 +21,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -45,8 +46,10 @@ type TrialReadinessGate struct {
 	APIVersion string `json:"apiVersion,omitempty"`
 	// Selector matches the resources whose condition must be checked, mutually exclusive with "Name"
 	Selector *metav1.LabelSelector `json:"selector,omitempty"`
-	// ConditionTypes are the status conditions that must be "True"
-	ConditionTypes []string `json:"conditionTypes"`
+	// ConditionTypes are the status conditions that must be "True"; a condition type of the form
+	// "redskyops.dev/expression=<expression>" is true when the expression evaluated against the object is true and
+	// "redskyops.dev/http-get" is true when the request described by "HTTPGet" succeeds
+	ConditionTypes []string `json:"conditionTypes,omitempty"`
 	// InitialDelaySeconds is the approximate number of seconds after all of the patches have been applied to start
 	// evaluating this check
 	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
@@ -56,6 +59,34 @@ type TrialReadinessGate struct {
 	// FailureThreshold is number of times that any of the specified ready conditions may be "False";
 	// defaults to 3, minimum value is 1
 	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+	// HTTPGet is the configuration of the "redskyops.dev/http-get" condition type
+	HTTPGet *HTTPGetCondition `json:"httpGet,omitempty"`
+	// Metric is collected after the conditions are satisfied, the gate only passes once the metric value is non-zero
+	// (e.g. a Prometheus query using the "bool" modifier); a zero value counts against the failure threshold
+	Metric *Metric `json:"metric,omitempty"`
+	// StabilizationSeconds is the number of seconds the metric value must remain non-zero before the gate passes
+	StabilizationSeconds int32 `json:"stabilizationSeconds,omitempty"`
+}
+
+// HTTPGetCondition describes an HTTP request made to the service or pods of a readiness target. The condition is
+// "True" when the response has the expected status code (and body match) for the required number of consecutive checks.
+type HTTPGetCondition struct {
+	// Path to access on the HTTP server
+	Path string `json:"path,omitempty"`
+	// Number or name of the port to access on the target service or pods
+	Port intstr.IntOrString `json:"port"`
+	// Scheme to use for connecting to the host, defaults to HTTP
+	Scheme corev1.URIScheme `json:"scheme,omitempty"`
+	// Custom headers to set in the request
+	HTTPHeaders []corev1.HTTPHeader `json:"httpHeaders,omitempty"`
+	// The expected response status code, defaults to 200
+	StatusCode int32 `json:"statusCode,omitempty"`
+	// JSONPath expression (with curly braces) evaluated against the response body which must produce a match
+	JSONPath string `json:"jsonPath,omitempty"`
+	// The expected value of the JSONPath match, ignored unless jsonPath is also set
+	Value string `json:"value,omitempty"`
+	// SuccessThreshold is the number of consecutive successful requests required; defaults to 1
+	SuccessThreshold int32 `json:"successThreshold,omitempty"`
 }
 
 // HelmValue represents a value in a Helm template
@@ -98,6 +129,28 @@ type ConfigMapHelmValuesFromSource struct {
 	corev1.LocalObjectReference `json:",inline"`
 }
 
+// SetupScope represents the allowable scopes of a setup task
+type SetupScope string
+
+const (
+	// The setup task is created before and deleted after every trial
+	SetupScopeTrial SetupScope = "trial"
+	// The setup task is created once per namespace and shared by all of the trials of the experiment in that
+	// namespace, it is deleted when the experiment is finished or the namespace is released
+	SetupScopeExperiment SetupScope = "experiment"
+)
+
+// ManifestsSource represents a source of plain manifests (or a kustomization) to apply as part of a setup task
+type ManifestsSource struct {
+	// The ConfigMap in the experiment namespace whose values are the manifests (or the files of a kustomization), each
+	// value is evaluated as a template using the same rules as patches
+	ConfigMap *corev1.LocalObjectReference `json:"configMap,omitempty"`
+	// The URL of a Git repository containing a kustomization (e.g. "github.com/example/app//deploy?ref=v1.0.0") or of
+	// an OCI artifact containing the manifests (e.g. "oci://registry.example.com/app/manifests:v1.0.0"); manifests
+	// from a URL are applied as is
+	URL string `json:"url,omitempty"`
+}
+
 // SetupTask represents the configuration necessary to apply application state to the cluster
 // prior to each trial run and remove that state after the run concludes
 type SetupTask struct {
@@ -105,6 +158,12 @@ type SetupTask struct {
 	Name string `json:"name"`
 	// Override the default image used for performing setup tasks
 	Image string `json:"image,omitempty"`
+	// The scope of the setup task, either "trial" or "experiment", defaults to "trial"; experiment scoped setup tasks
+	// must not depend on the trial assignments
+	Scope SetupScope `json:"scope,omitempty"`
+	// The names of the setup tasks which must finish before this task is started, when any setup task has
+	// dependencies the tasks are run sequentially (in the reverse order when deleting)
+	DependsOn []string `json:"dependsOn,omitempty"`
 	// Flag to indicate the creation part of the task can be skipped
 	SkipCreate bool `json:"skipCreate,omitempty"`
 	// Flag to indicate the deletion part of the task can be skipped
@@ -119,6 +178,66 @@ type SetupTask struct {
 	HelmValues []HelmValue `json:"helmValues,omitempty"`
 	// The Helm values, ignored unless helmChart is also set
 	HelmValuesFrom []HelmValuesFromSource `json:"helmValuesFrom,omitempty"`
+	// The plain manifests (or kustomization) to apply as part of this task, an alternative to a Helm chart
+	Manifests *ManifestsSource `json:"manifests,omitempty"`
+	// Compute resources of the setup task container, overrides the resources of the setup pod template
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Additional sources of environment variables for the setup task container
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+}
+
+// SetupTaskPhase represents the allowable phases of a setup task
+type SetupTaskPhase string
+
+const (
+	// The setup task has not started
+	SetupTaskPending SetupTaskPhase = "Pending"
+	// The setup task is running
+	SetupTaskRunning SetupTaskPhase = "Running"
+	// The setup task finished successfully
+	SetupTaskSucceeded SetupTaskPhase = "Succeeded"
+	// The setup task failed
+	SetupTaskFailed SetupTaskPhase = "Failed"
+)
+
+// SetupTaskStatus describes the outcome of a single setup task
+type SetupTaskStatus struct {
+	// The name of the setup task
+	Name string `json:"name"`
+	// The mode of the setup job the task was last run in, either "create" or "delete"
+	Mode string `json:"mode"`
+	// The phase of the setup task
+	Phase SetupTaskPhase `json:"phase"`
+	// A human readable message describing the outcome of the setup task, e.g. why it failed
+	Message string `json:"message,omitempty"`
+}
+
+// SetupPodTemplate customizes the pods used to run setup tasks
+type SetupPodTemplate struct {
+	// Labels added to the setup pods
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations added to the setup pods
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Node selector used to schedule the setup pods
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations of the setup pods
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Affinity scheduling rules of the setup pods
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// Priority class name of the setup pods
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// Image pull secrets used to pull setup task images
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Security context of the setup pods, replaces the default which requires a non-root user
+	SecurityContext *corev1.PodSecurityContext `json:"securityContext,omitempty"`
+	// Security context of every setup task container, replaces the default which runs as UID and GID 1000 without
+	// privilege escalation; the default setup tools image requires UID 1000
+	ContainerSecurityContext *corev1.SecurityContext `json:"containerSecurityContext,omitempty"`
+	// Compute resources of every setup task container, individual setup tasks may override this
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Sources of environment variables for every setup task container, e.g. secrets containing Helm repository
+	// credentials
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
 }
 
 // PatchOperation represents a patch used to prepare the cluster for a trial run, includes the evaluated
@@ -133,6 +252,8 @@ type PatchOperation struct {
 	// The number of remaining attempts to apply the patch, will be automatically set
 	// to zero if the patch is successfully applied
 	AttemptsRemaining int `json:"attemptsRemaining,omitempty"`
+	// A patch which restores the patched fields of the target to their state prior to applying this patch
+	RestoreData []byte `json:"restoreData,omitempty"`
 }
 
 // ReadinessCheck represents a check to determine when the patched application is "ready" and it is
@@ -145,7 +266,7 @@ type ReadinessCheck struct {
 	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 	// ConditionTypes are the status conditions that must be "True"; in addition to conditions that appear in the
 	// status of the target object, additional special conditions starting with "redskyops.dev/" can be tested
-	ConditionTypes []string `json:"conditionTypes"`
+	ConditionTypes []string `json:"conditionTypes,omitempty"`
 	// InitialDelaySeconds is the approximate number of seconds after all of the patches have been applied to start
 	// evaluating this check
 	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
@@ -156,6 +277,17 @@ type ReadinessCheck struct {
 	AttemptsRemaining int32 `json:"attemptsRemaining,omitempty"`
 	// LastCheckTime is the timestamp of the last evaluation attempt
 	LastCheckTime *metav1.Time `json:"lastCheckTime,omitempty"`
+	// HTTPGet is the configuration of the "redskyops.dev/http-get" condition type
+	HTTPGet *HTTPGetCondition `json:"httpGet,omitempty"`
+	// ConsecutiveSuccesses is the number of consecutive successful evaluation attempts, only used when the check
+	// requires more then one success
+	ConsecutiveSuccesses int32 `json:"consecutiveSuccesses,omitempty"`
+	// Metric is the stabilization metric which must have a non-zero value
+	Metric *Metric `json:"metric,omitempty"`
+	// StabilizationSeconds is the number of seconds the metric must remain non-zero
+	StabilizationSeconds int32 `json:"stabilizationSeconds,omitempty"`
+	// StableTime is the timestamp of the first evaluation attempt in the current run of non-zero metric values
+	StableTime *metav1.Time `json:"stableTime,omitempty"`
 }
 
 // Value represents an observed metric value after a trial run has completed successfully. Value names
@@ -191,6 +323,8 @@ const (
 	TrialReady TrialConditionType = "redskyops.dev/trial-ready"
 	// Condition that indicates a trial has had metrics collected
 	TrialObserved TrialConditionType = "redskyops.dev/trial-observed"
+	// Condition that indicates the patched objects were restored to their original state
+	TrialRestored TrialConditionType = "redskyops.dev/trial-restored"
 )
 
 // TrialCondition represents an observed condition of a trial
@@ -209,6 +343,49 @@ type TrialCondition struct {
 	Message string `json:"message,omitempty"`
 }
 
+// TrialRunTemplate describes a trial run using an object of any kind, e.g. an Argo Workflow, a Tekton PipelineRun or a
+// plain Pod
+type TrialRunTemplate struct {
+	// Object is the trial run to create, it must include the API version and kind; the name defaults to the trial name
+	// and the namespace is always the trial namespace
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Object runtime.RawExtension `json:"object"`
+	// CompletedConditionTypes are the status conditions that must be "True" for the trial run to be complete, the same
+	// condition types as readiness checks are supported (e.g. "redskyops.dev/expression={.status.phase} == 'Succeeded'")
+	CompletedConditionTypes []string `json:"completedConditionTypes"`
+	// FailedConditionTypes are status conditions which indicate the trial run failed if any of them are "True"
+	FailedConditionTypes []string `json:"failedConditionTypes,omitempty"`
+	// StartTimePath is the JSONPath of the trial run start time, defaults to "{.status.startTime}"
+	StartTimePath string `json:"startTimePath,omitempty"`
+	// CompletionTimePath is the JSONPath of the trial run completion time, defaults to "{.status.completionTime}"; if
+	// the path does not match a time, the time the trial run was observed to be complete is used
+	CompletionTimePath string `json:"completionTimePath,omitempty"`
+}
+
+// MeasurementWindowAnchor represents the allowable anchors of a fixed length measurement window
+type MeasurementWindowAnchor string
+
+const (
+	// The measurement window begins after the warm-up
+	MeasurementWindowStart MeasurementWindowAnchor = "start"
+	// The measurement window ends before the cool-down
+	MeasurementWindowEnd MeasurementWindowAnchor = "end"
+)
+
+// MeasurementWindow restricts the portion of the trial run used to collect metrics
+type MeasurementWindow struct {
+	// WarmUp is the amount of time excluded from the beginning of the trial run (in addition to the start time offset)
+	WarmUp *metav1.Duration `json:"warmUp,omitempty"`
+	// CoolDown is the amount of time excluded from the end of the trial run, e.g. to ignore load generator ramp-down
+	CoolDown *metav1.Duration `json:"coolDown,omitempty"`
+	// Duration is the fixed length of the measurement window, if unset the window extends from the warm-up to the
+	// cool-down
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// Anchor determines if a fixed length window is anchored to the start (after the warm-up) or the end (before the
+	// cool-down) of the trial run, defaults to "start"
+	Anchor MeasurementWindowAnchor `json:"anchor,omitempty"`
+}
+
 // TrialSpec defines the desired state of Trial
 type TrialSpec struct {
 	// ExperimentRef is the reference to the experiment that contains the definitions to use for this trial,
@@ -216,14 +393,26 @@ type TrialSpec struct {
 	ExperimentRef *corev1.ObjectReference `json:"experimentRef,omitempty"`
 	// Assignments are used to patch the cluster state prior to the trial run
 	Assignments []Assignment `json:"assignments,omitempty"`
+	// DerivedAssignments are the values of the experiment derived parameters computed from the assignments
+	DerivedAssignments []Assignment `json:"derivedAssignments,omitempty"`
 	// Selector matches the job representing the trial run
 	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 	// Template is the job template used to create trial run jobs
 	Template *batchv1beta1.JobTemplateSpec `json:"template,omitempty"`
+	// RunTemplate is used to create a trial run of any kind instead of a job, mutually exclusive with "Template"
+	RunTemplate *TrialRunTemplate `json:"runTemplate,omitempty"`
 	// The offset used to adjust the start time to account for spin up of the trial run
 	StartTimeOffset *metav1.Duration `json:"startTimeOffset,omitempty"`
 	// The approximate amount of time the trial run should execute (not inclusive of the start time offset)
 	ApproximateRuntime *metav1.Duration `json:"approximateRuntime,omitempty"`
+	// The portion of the trial run used to collect metrics, defaults to the entire trial run
+	MeasurementWindow *MeasurementWindow `json:"measurementWindow,omitempty"`
+	// The number of seconds after the trial is created that it may be active (including setup, patching, waiting for
+	// stability, running and capturing metrics) before it is failed, the trial run is stopped if it is still running
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+	// The number of times the assignments are re-run in a new trial when the trial fails for reasons attributed to the
+	// infrastructure rather than the assignments (e.g. a pod eviction), defaults to 2
+	RetryLimit *int32 `json:"retryLimit,omitempty"`
 	// The minimum number of seconds before an attempt should be made to clean up the trial, if unset or negative no attempt is made to clean up the trial
 	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
 	// The minimum number of seconds before an attempt should be made to clean up a failed trial, defaults to TTLSecondsAfterFinished
@@ -248,6 +437,8 @@ type TrialSpec struct {
 	SetupDefaultClusterRole string `json:"setupDefaultClusterRole,omitempty"`
 	// Policy rules to be assigned to the setup service account when creating namespaces
 	SetupDefaultRules []rbacv1.PolicyRule `json:"setupDefaultRules,omitempty"`
+	// Customization of the pods used to run setup tasks, e.g. to satisfy cluster policies
+	SetupPodTemplate *SetupPodTemplate `json:"setupPodTemplate,omitempty"`
 }
 
 // TrialStatus defines the observed state of Trial
@@ -262,12 +453,22 @@ type TrialStatus struct {
 	StartTime *metav1.Time `json:"startTime,omitempty"`
 	// CompletionTime is the effective (possibly adjusted) time the trial run job completed
 	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// MeasurementStartTime is the beginning of the measurement window used to collect metrics
+	MeasurementStartTime *metav1.Time `json:"measurementStartTime,omitempty"`
+	// MeasurementCompletionTime is the end of the measurement window used to collect metrics
+	MeasurementCompletionTime *metav1.Time `json:"measurementCompletionTime,omitempty"`
 	// Condition is the current state of the trial
 	Conditions []TrialCondition `json:"conditions,omitempty"`
+	// SetupTasks is the status of the individual setup tasks
+	SetupTasks []SetupTaskStatus `json:"setupTasks,omitempty"`
+	// Warnings describe potential problems with the trial which do not prevent it from running, e.g. patches which
+	// overwrite each other
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 // +genclient
 // +kubebuilder:object:root=true
+// +kubebuilder:storageversion
 
 // Trial is the Schema for the trials API
 // +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.phase",description="Trial status"
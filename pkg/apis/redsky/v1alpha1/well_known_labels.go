@@ -36,6 +36,9 @@ const (
 	// AnnotationInitializer is a comma-delimited list of initializing processes. Similar to a "finalizer", the trial
 	// will not start executing until the initializer is empty.
 	AnnotationInitializer = "redskyops.dev/initializer"
+	// AnnotationRetries is the number of times the trial assignments were previously run and failed for reasons
+	// attributed to the infrastructure
+	AnnotationRetries = "redskyops.dev/retries"
 
 	// LabelTrial contains the name of the trial associated with an object
 	LabelTrial = "redskyops.dev/trial"
@@ -25,7 +25,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -84,6 +84,21 @@ func (in *Constraint) DeepCopy() *Constraint {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DerivedParameter) DeepCopyInto(out *DerivedParameter) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DerivedParameter.
+func (in *DerivedParameter) DeepCopy() *DerivedParameter {
+	if in == nil {
+		return nil
+	}
+	out := new(DerivedParameter)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Experiment) DeepCopyInto(out *Experiment) {
 	*out = *in
@@ -161,6 +176,11 @@ func (in *ExperimentSpec) DeepCopyInto(out *ExperimentSpec) {
 		*out = make([]Parameter, len(*in))
 		copy(*out, *in)
 	}
+	if in.DerivedParameters != nil {
+		in, out := &in.DerivedParameters, &out.DerivedParameters
+		*out = make([]DerivedParameter, len(*in))
+		copy(*out, *in)
+	}
 	if in.Constraints != nil {
 		in, out := &in.Constraints, &out.Constraints
 		*out = make([]Constraint, len(*in))
@@ -182,6 +202,13 @@ func (in *ExperimentSpec) DeepCopyInto(out *ExperimentSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.TemplatesFrom != nil {
+		in, out := &in.TemplatesFrom, &out.TemplatesFrom
+		*out = make([]TemplatesFromSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.NamespaceSelector != nil {
 		in, out := &in.NamespaceSelector, &out.NamespaceSelector
 		*out = new(v1.LabelSelector)
@@ -225,6 +252,27 @@ func (in *ExperimentStatus) DeepCopy() *ExperimentStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPGetCondition) DeepCopyInto(out *HTTPGetCondition) {
+	*out = *in
+	out.Port = in.Port
+	if in.HTTPHeaders != nil {
+		in, out := &in.HTTPHeaders, &out.HTTPHeaders
+		*out = make([]corev1.HTTPHeader, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPGetCondition.
+func (in *HTTPGetCondition) DeepCopy() *HTTPGetCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPGetCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HelmValue) DeepCopyInto(out *HelmValue) {
 	*out = *in
@@ -286,6 +334,56 @@ func (in *HelmValuesFromSource) DeepCopy() *HelmValuesFromSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManifestsSource) DeepCopyInto(out *ManifestsSource) {
+	*out = *in
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManifestsSource.
+func (in *ManifestsSource) DeepCopy() *ManifestsSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestsSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeasurementWindow) DeepCopyInto(out *MeasurementWindow) {
+	*out = *in
+	if in.WarmUp != nil {
+		in, out := &in.WarmUp, &out.WarmUp
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.CoolDown != nil {
+		in, out := &in.CoolDown, &out.CoolDown
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeasurementWindow.
+func (in *MeasurementWindow) DeepCopy() *MeasurementWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(MeasurementWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Metric) DeepCopyInto(out *Metric) {
 	*out = *in
@@ -393,6 +491,11 @@ func (in *PatchOperation) DeepCopyInto(out *PatchOperation) {
 		*out = make([]byte, len(*in))
 		copy(*out, *in)
 	}
+	if in.RestoreData != nil {
+		in, out := &in.RestoreData, &out.RestoreData
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PatchOperation.
@@ -428,6 +531,11 @@ func (in *PatchTemplate) DeepCopyInto(out *PatchTemplate) {
 		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ReadinessGates != nil {
 		in, out := &in.ReadinessGates, &out.ReadinessGates
 		*out = make([]PatchReadinessGate, len(*in))
@@ -463,6 +571,20 @@ func (in *ReadinessCheck) DeepCopyInto(out *ReadinessCheck) {
 		in, out := &in.LastCheckTime, &out.LastCheckTime
 		*out = (*in).DeepCopy()
 	}
+	if in.HTTPGet != nil {
+		in, out := &in.HTTPGet, &out.HTTPGet
+		*out = new(HTTPGetCondition)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Metric != nil {
+		in, out := &in.Metric, &out.Metric
+		*out = new(Metric)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StableTime != nil {
+		in, out := &in.StableTime, &out.StableTime
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReadinessCheck.
@@ -475,9 +597,85 @@ func (in *ReadinessCheck) DeepCopy() *ReadinessCheck {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SetupPodTemplate) DeepCopyInto(out *SetupPodTemplate) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(corev1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]corev1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.SecurityContext != nil {
+		in, out := &in.SecurityContext, &out.SecurityContext
+		*out = new(corev1.PodSecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ContainerSecurityContext != nil {
+		in, out := &in.ContainerSecurityContext, &out.ContainerSecurityContext
+		*out = new(corev1.SecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.EnvFrom != nil {
+		in, out := &in.EnvFrom, &out.EnvFrom
+		*out = make([]corev1.EnvFromSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SetupPodTemplate.
+func (in *SetupPodTemplate) DeepCopy() *SetupPodTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(SetupPodTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SetupTask) DeepCopyInto(out *SetupTask) {
 	*out = *in
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.VolumeMounts != nil {
 		in, out := &in.VolumeMounts, &out.VolumeMounts
 		*out = make([]corev1.VolumeMount, len(*in))
@@ -499,6 +697,23 @@ func (in *SetupTask) DeepCopyInto(out *SetupTask) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Manifests != nil {
+		in, out := &in.Manifests, &out.Manifests
+		*out = new(ManifestsSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.EnvFrom != nil {
+		in, out := &in.EnvFrom, &out.EnvFrom
+		*out = make([]corev1.EnvFromSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SetupTask.
@@ -511,6 +726,21 @@ func (in *SetupTask) DeepCopy() *SetupTask {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SetupTaskStatus) DeepCopyInto(out *SetupTaskStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SetupTaskStatus.
+func (in *SetupTaskStatus) DeepCopy() *SetupTaskStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SetupTaskStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SumConstraint) DeepCopyInto(out *SumConstraint) {
 	*out = *in
@@ -550,6 +780,26 @@ func (in *SumConstraintParameter) DeepCopy() *SumConstraintParameter {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TemplatesFromSource) DeepCopyInto(out *TemplatesFromSource) {
+	*out = *in
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TemplatesFromSource.
+func (in *TemplatesFromSource) DeepCopy() *TemplatesFromSource {
+	if in == nil {
+		return nil
+	}
+	out := new(TemplatesFromSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Trial) DeepCopyInto(out *Trial) {
 	*out = *in
@@ -639,6 +889,16 @@ func (in *TrialReadinessGate) DeepCopyInto(out *TrialReadinessGate) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.HTTPGet != nil {
+		in, out := &in.HTTPGet, &out.HTTPGet
+		*out = new(HTTPGetCondition)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Metric != nil {
+		in, out := &in.Metric, &out.Metric
+		*out = new(Metric)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrialReadinessGate.
@@ -651,6 +911,32 @@ func (in *TrialReadinessGate) DeepCopy() *TrialReadinessGate {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrialRunTemplate) DeepCopyInto(out *TrialRunTemplate) {
+	*out = *in
+	in.Object.DeepCopyInto(&out.Object)
+	if in.CompletedConditionTypes != nil {
+		in, out := &in.CompletedConditionTypes, &out.CompletedConditionTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.FailedConditionTypes != nil {
+		in, out := &in.FailedConditionTypes, &out.FailedConditionTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrialRunTemplate.
+func (in *TrialRunTemplate) DeepCopy() *TrialRunTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(TrialRunTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TrialSpec) DeepCopyInto(out *TrialSpec) {
 	*out = *in
@@ -664,6 +950,11 @@ func (in *TrialSpec) DeepCopyInto(out *TrialSpec) {
 		*out = make([]Assignment, len(*in))
 		copy(*out, *in)
 	}
+	if in.DerivedAssignments != nil {
+		in, out := &in.DerivedAssignments, &out.DerivedAssignments
+		*out = make([]Assignment, len(*in))
+		copy(*out, *in)
+	}
 	if in.Selector != nil {
 		in, out := &in.Selector, &out.Selector
 		*out = new(v1.LabelSelector)
@@ -674,6 +965,11 @@ func (in *TrialSpec) DeepCopyInto(out *TrialSpec) {
 		*out = new(v1beta1.JobTemplateSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.RunTemplate != nil {
+		in, out := &in.RunTemplate, &out.RunTemplate
+		*out = new(TrialRunTemplate)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.StartTimeOffset != nil {
 		in, out := &in.StartTimeOffset, &out.StartTimeOffset
 		*out = new(v1.Duration)
@@ -684,6 +980,21 @@ func (in *TrialSpec) DeepCopyInto(out *TrialSpec) {
 		*out = new(v1.Duration)
 		**out = **in
 	}
+	if in.MeasurementWindow != nil {
+		in, out := &in.MeasurementWindow, &out.MeasurementWindow
+		*out = new(MeasurementWindow)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ActiveDeadlineSeconds != nil {
+		in, out := &in.ActiveDeadlineSeconds, &out.ActiveDeadlineSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.RetryLimit != nil {
+		in, out := &in.RetryLimit, &out.RetryLimit
+		*out = new(int32)
+		**out = **in
+	}
 	if in.TTLSecondsAfterFinished != nil {
 		in, out := &in.TTLSecondsAfterFinished, &out.TTLSecondsAfterFinished
 		*out = new(int32)
@@ -741,6 +1052,11 @@ func (in *TrialSpec) DeepCopyInto(out *TrialSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.SetupPodTemplate != nil {
+		in, out := &in.SetupPodTemplate, &out.SetupPodTemplate
+		*out = new(SetupPodTemplate)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrialSpec.
@@ -764,6 +1080,14 @@ func (in *TrialStatus) DeepCopyInto(out *TrialStatus) {
 		in, out := &in.CompletionTime, &out.CompletionTime
 		*out = (*in).DeepCopy()
 	}
+	if in.MeasurementStartTime != nil {
+		in, out := &in.MeasurementStartTime, &out.MeasurementStartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.MeasurementCompletionTime != nil {
+		in, out := &in.MeasurementCompletionTime, &out.MeasurementCompletionTime
+		*out = (*in).DeepCopy()
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]TrialCondition, len(*in))
@@ -771,6 +1095,16 @@ func (in *TrialStatus) DeepCopyInto(out *TrialStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.SetupTasks != nil {
+		in, out := &in.SetupTasks, &out.SetupTasks
+		*out = make([]SetupTaskStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Warnings != nil {
+		in, out := &in.Warnings, &out.Warnings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrialStatus.
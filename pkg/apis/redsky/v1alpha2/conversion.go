@@ -0,0 +1,90 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// NOTE: The only difference between v1alpha1 and v1alpha2 is the Go type used for the trial job template, the
+// serialized form of every type is identical so conversion is just a matter of re-encoding the object
+
+// ConvertTo converts this experiment to the hub version
+func (in *Experiment) ConvertTo(hub conversion.Hub) error {
+	dst, ok := hub.(*v1alpha1.Experiment)
+	if !ok {
+		return fmt.Errorf("unexpected conversion hub: %T", hub)
+	}
+	if err := convert(in, dst); err != nil {
+		return err
+	}
+	dst.SetGroupVersionKind(v1alpha1.GroupVersion.WithKind("Experiment"))
+	return nil
+}
+
+// ConvertFrom converts the hub version to this experiment
+func (in *Experiment) ConvertFrom(hub conversion.Hub) error {
+	src, ok := hub.(*v1alpha1.Experiment)
+	if !ok {
+		return fmt.Errorf("unexpected conversion hub: %T", hub)
+	}
+	if err := convert(src, in); err != nil {
+		return err
+	}
+	in.SetGroupVersionKind(GroupVersion.WithKind("Experiment"))
+	return nil
+}
+
+// ConvertTo converts this trial to the hub version
+func (in *Trial) ConvertTo(hub conversion.Hub) error {
+	dst, ok := hub.(*v1alpha1.Trial)
+	if !ok {
+		return fmt.Errorf("unexpected conversion hub: %T", hub)
+	}
+	if err := convert(in, dst); err != nil {
+		return err
+	}
+	dst.SetGroupVersionKind(v1alpha1.GroupVersion.WithKind("Trial"))
+	return nil
+}
+
+// ConvertFrom converts the hub version to this trial
+func (in *Trial) ConvertFrom(hub conversion.Hub) error {
+	src, ok := hub.(*v1alpha1.Trial)
+	if !ok {
+		return fmt.Errorf("unexpected conversion hub: %T", hub)
+	}
+	if err := convert(src, in); err != nil {
+		return err
+	}
+	in.SetGroupVersionKind(GroupVersion.WithKind("Trial"))
+	return nil
+}
+
+// convert copies the source object into the destination object using their serialized form
+func convert(src, dst runtime.Object) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
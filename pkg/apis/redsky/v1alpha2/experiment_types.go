@@ -0,0 +1,276 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Optimization is a configuration setting for the optimizer
+type Optimization struct {
+	// Name is the name of the optimization configuration to set
+	Name string `json:"name"`
+	// Value is string representation of the optimization configuration
+	Value string `json:"value"`
+}
+
+// Parameter represents the domain of a single component of the experiment search space
+type Parameter struct {
+	// The name of the parameter
+	Name string `json:"name"`
+	// The inclusive minimum value of the parameter
+	Min int64 `json:"min,omitempty"`
+	// The inclusive maximum value of the parameter
+	Max int64 `json:"max,omitempty"`
+}
+
+// DerivedParameter represents a value computed from the assignments of the parameters
+type DerivedParameter struct {
+	// The name of the derived parameter, it must not conflict with the name of a parameter
+	Name string `json:"name"`
+	// A Go Template that evaluates to a number using the trial assignments (and any previously derived values),
+	// e.g. `{{ percent .Values.memory 75 }}`; fractional values are rounded to the nearest integer
+	Expression string `json:"expression"`
+}
+
+// Constraint represents a constraint to the domain of the parameters
+type Constraint struct {
+	// The optional name of the constraint
+	Name string `json:"name,omitempty"`
+	// The ordering constraint to impose
+	Order *OrderConstraint `json:"order,omitempty"`
+	// The sum constraint to impose
+	Sum *SumConstraint `json:"sum,omitempty"`
+}
+
+// OrderConstraint defines a constraint between the ordering of two parameters in the experiment
+type OrderConstraint struct {
+	// LowerParameter is the name of the parameter that must be the smaller of two parameters
+	LowerParameter string `json:"lowerParameter"`
+	// UpperParameter is the name of the parameter that must be the larger of two parameters
+	UpperParameter string `json:"upperParameter"`
+}
+
+// SumConstraintParameter is a weighted parameter specification in a sum constraint
+type SumConstraintParameter struct {
+	// Name of the parameter
+	Name string `json:"name"`
+	// Weight of the parameter
+	Weight resource.Quantity `json:"weight"`
+}
+
+// SumConstraint defines a constraint between the sum of a collection of parameters
+type SumConstraint struct {
+	// Bound for the sum of the listed parameters
+	Bound resource.Quantity `json:"bound"`
+	// IsUpperBound determines if the bound values is an upper or lower bound on the sum
+	IsUpperBound bool `json:"isUpperBound,omitempty"`
+	// Parameters that should be summed
+	Parameters []SumConstraintParameter `json:"parameters"`
+}
+
+// PatchType represents the allowable types of patches
+type PatchType string
+
+// MetricType represents the allowable types of metrics
+type MetricType string
+
+// RestorePolicy represents the allowable policies for restoring patched objects
+type RestorePolicy string
+
+const (
+	// Strategic merge patch
+	PatchStrategic PatchType = "strategic"
+	// Merge patch
+	PatchMerge = "merge"
+	// JSON patch (RFC 6902)
+	PatchJSON = "json"
+	// Server-side apply patch, the patch must be a (partial) object
+	PatchServerSide = "serverSide"
+
+	// Local metrics are Go Templates evaluated against the trial itself. No external service is consulted, primarily
+	// useful for extracting start and completion times.
+	MetricLocal MetricType = "local"
+	// Pod metrics are similar to local metrics, however the list of pods in the trial namespace matched by the selector
+	// is also available.
+	MetricPods = "pods"
+	// Prometheus metrics issue PromQL queries to a matched service. Queries MUST evaluate to a scalar value.
+	MetricPrometheus = "prometheus"
+	// Datadog metrics issue queries to the Datadog service. Requires API and application key configuration.
+	MetricDatadog = "datadog"
+	// JSON path metrics fetch a JSON resource from the matched service. Queries are JSON path expression evaluated against the resource.
+	MetricJSONPath = "jsonpath"
+	// TODO "regex"?
+
+	// Restore patched objects after every trial
+	RestoreAlways RestorePolicy = "always"
+	// Restore patched objects when the experiment is completed or deleted
+	RestoreOnFinish = "onFinish"
+	// Never restore patched objects
+	RestoreNever = "never"
+)
+
+// Metric represents an observable outcome from a trial run
+type Metric struct {
+	// The name of the metric
+	Name string `json:"name"`
+	// Indicator that the goal of the experiment is to minimize the value of this metric
+	Minimize bool `json:"minimize,omitempty"`
+
+	// The metric collection type, one of: local|prometheus|datadog|jsonpath, default: local
+	Type MetricType `json:"type,omitempty"`
+	// Collection type specific query, e.g. Go template for "local", PromQL for "prometheus" or a JSON pointer expression (with curly braces) for "jsonpath"
+	Query string `json:"query"`
+	// Collection type specific query for the error associated with collected metric value
+	ErrorQuery string `json:"errorQuery,omitempty"`
+
+	// The scheme to use when collecting metrics
+	Scheme string `json:"scheme,omitempty"`
+	// Selector matching services to collect this metric from, only the first matched service to provide a value is used
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// The port number or name on the matched service to collect the metric value from
+	Port intstr.IntOrString `json:"port,omitempty"`
+	// URL path component used to collect the metric value from an endpoint (used as a prefix for the Prometheus API)
+	Path string `json:"path,omitempty"`
+}
+
+// PatchReadinessGate contains a reference to a condition
+type PatchReadinessGate struct {
+	// ConditionType refers to a condition in the patched target's condition list
+	ConditionType string `json:"conditionType"`
+}
+
+// PatchTemplate defines a target resource and a patch template to apply
+type PatchTemplate struct {
+	// The patch type, one of: json|merge|strategic|serverSide, default: strategic
+	Type PatchType `json:"type,omitempty"`
+	// A Go Template that evaluates to valid patch.
+	Patch string `json:"patch"`
+	// Direct reference to the object the patch should be applied to.
+	TargetRef *corev1.ObjectReference `json:"targetRef,omitempty"`
+	// Selector matches the objects the patch should be applied to, mutually exclusive with the target reference
+	// name; the kind and API version of the matched objects are taken from the target reference.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// ReadinessGates will be evaluated for patch target readiness. A patch target is ready if all conditions specified
+	// in the readiness gates have a status equal to "True". If no readiness gates are specified, some target types may
+	// have default gates assigned to them. Some condition checks may result in errors, e.g. a condition type of "Ready"
+	// is not allowed for a ConfigMap. Condition types starting with "redskyops.dev/" may not appear in the patched
+	// target's condition list, but are still evaluated against the resource's state.
+	ReadinessGates []PatchReadinessGate `json:"readinessGates,omitempty"`
+}
+
+// TemplatesFromSource is a source of named template definitions
+type TemplatesFromSource struct {
+	// The ConfigMap in the experiment namespace, every value is parsed for template definitions
+	ConfigMap *corev1.LocalObjectReference `json:"configMap,omitempty"`
+}
+
+// NamespaceTemplateSpec is used as a template for creating new namespaces
+type NamespaceTemplateSpec struct {
+	// Standard object metadata
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	// Specification of the namespace
+	Spec corev1.NamespaceSpec `json:"spec,omitempty"`
+}
+
+// TrialTemplateSpec is used as a template for creating new trials
+type TrialTemplateSpec struct {
+	// Standard object metadata
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	// Specification of the desired behavior for the trial
+	Spec TrialSpec `json:"spec,omitempty"`
+}
+
+// ExperimentSpec defines the desired state of Experiment
+type ExperimentSpec struct {
+	// Replicas is the number of trials to execute concurrently, defaults to 1
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Optimization defines additional configuration for the optimization
+	Optimization []Optimization `json:"optimization,omitempty"`
+	// Parameters defines the search space for the experiment
+	Parameters []Parameter `json:"parameters,omitempty"`
+	// DerivedParameters are computed for each trial from the parameter assignments, they are not part of the search space
+	DerivedParameters []DerivedParameter `json:"derivedParameters,omitempty"`
+	// Constraints defines restrictions on the parameter domain for the experiment
+	Constraints []Constraint `json:"constraints,omitempty"`
+	// Metrics defines the outcomes for the experiment
+	Metrics []Metric `json:"metrics,omitempty"`
+	// Patches is a sequence of templates written against the experiment parameters that will be used to put the
+	// cluster into the desired state
+	Patches []PatchTemplate `json:"patches,omitempty"`
+	// Templates contains named template definitions (e.g. `{{ define "resources" }}...{{ end }}`) that can be
+	// included by any patch, Helm value or metric query
+	Templates string `json:"templates,omitempty"`
+	// TemplatesFrom references additional named template definitions
+	TemplatesFrom []TemplatesFromSource `json:"templatesFrom,omitempty"`
+	// Restore determines when the original state of patched objects is put back, one of: always|onFinish|never,
+	// default: never
+	Restore RestorePolicy `json:"restore,omitempty"`
+	// NamespaceSelector is used to locate existing namespaces for trials
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// NamespaceTemplate can be specified to create new namespaces for trials; if specified created namespaces must be
+	// matched by the namespace selector
+	NamespaceTemplate *NamespaceTemplateSpec `json:"namespaceTemplate,omitempty"`
+	// Selector locates trial resources that are part of this experiment
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Template for creating a new trial. The resulting trial must be matched by Selector. The template can provide an
+	// initial namespace, however other namespaces (matched by NamespaceSelector) will be used if the effective
+	// replica count is more then one
+	Template TrialTemplateSpec `json:"template"`
+}
+
+// ExperimentStatus defines the observed state of Experiment
+type ExperimentStatus struct {
+	// Phase is a brief human readable description of the experiment status
+	Phase string `json:"phase"`
+	// ActiveTrials is the observed number of running trials
+	ActiveTrials int32 `json:"activeTrials"`
+	// TODO Number of trials: Succeeded, Failed int32 (this would need to be fetch remotely, falling back to the in cluster count)
+}
+
+// +genclient
+// +kubebuilder:object:root=true
+
+// Experiment is the Schema for the experiments API
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.phase",description="Experiment status"
+type Experiment struct {
+	metav1.TypeMeta `json:",inline"`
+	// Standard object metadata
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	// Specification of the desired behavior for an experiment
+	Spec ExperimentSpec `json:"spec,omitempty"`
+	// Current status of an experiment
+	Status ExperimentStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ExperimentList contains a list of Experiment
+type ExperimentList struct {
+	metav1.TypeMeta `json:",inline"`
+	// Standard list metadata
+	metav1.ListMeta `json:"metadata,omitempty"`
+	// The list of experiments
+	Items []Experiment `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Experiment{}, &ExperimentList{})
+}
@@ -21,6 +21,10 @@ import (
 	"io/ioutil"
 	"strings"
 
+	"github.com/redskyops/redskyops-controller/internal/experiment"
+	"github.com/redskyops/redskyops-controller/internal/patch"
+	"github.com/redskyops/redskyops-controller/internal/ready"
+	"github.com/redskyops/redskyops-controller/internal/setup"
 	"github.com/redskyops/redskyops-controller/internal/template"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commander"
@@ -28,9 +32,10 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/batch/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/scheme"
-	"sigs.k8s.io/yaml"
+	"k8s.io/client-go/util/jsonpath"
 )
 
 // ExperimentOptions are the options for checking an experiment manifest
@@ -46,7 +51,7 @@ func NewExperimentCommand(o *ExperimentOptions) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "experiment",
 		Short: "Check an experiment",
-		Long:  "Check an experiment manifest",
+		Long:  "Check an experiment manifest\n\nPatches that set the same field of the same object are reported as overwriting each other. List elements are identified by name in strategic merge and apply patches but by index in JSON patches, so a JSON patch and a strategic merge patch changing the same list element are not reported.",
 
 		PreRun: commander.StreamsPreRun(&o.IOStreams),
 		RunE:   commander.WithoutArgsE(o.checkExperiment),
@@ -74,14 +79,14 @@ func (o *ExperimentOptions) checkExperiment() error {
 	}
 
 	// Unmarshal the experiment
-	experiment := &redskyv1alpha1.Experiment{}
-	if err = yaml.Unmarshal(data, experiment); err != nil {
+	exp := &redskyv1alpha1.Experiment{}
+	if err = experiment.UnmarshalManifest(data, exp); err != nil {
 		return err
 	}
 
 	// Check that everything looks right
 	linter := &AllTheLint{}
-	checkExperiment(linter.For("experiment"), experiment)
+	checkExperiment(linter.For("experiment"), exp)
 
 	// Share the results
 	// TODO Filter/sort?
@@ -99,9 +104,12 @@ func checkExperiment(lint Linter, experiment *redskyv1alpha1.Experiment) {
 	}
 
 	checkParameters(lint.For("spec", "parameters"), experiment.Spec.Parameters)
-	checkMetrics(lint.For("spec", "metrics"), experiment.Spec.Metrics)
-	checkPatches(lint.For("spec", "patches"), experiment.Spec.Patches)
-	checkTrialTemplate(lint.For("spec", "template"), &experiment.Spec.Template)
+	checkDerivedParameters(lint.For("spec", "derivedParameters"), experiment)
+	te := checkTemplates(lint.For("spec", "templates"), experiment)
+	checkMetrics(lint.For("spec", "metrics"), te, experiment.Spec.Metrics)
+	checkPatches(lint.For("spec", "patches"), te, experiment.Spec.Patches)
+	checkRestore(lint.For("spec"), experiment.Spec.Restore)
+	checkTrialTemplate(lint.For("spec", "template"), te, &experiment.Spec.Template)
 
 	// TODO Some checks are higher level and need a combination of pieces: e.g. selector/template matching
 
@@ -140,19 +148,61 @@ func checkParameter(lint Linter, parameter *redskyv1alpha1.Parameter) {
 
 }
 
-func checkMetrics(lint Linter, metrics []redskyv1alpha1.Metric) {
+func checkDerivedParameters(lint Linter, exp *redskyv1alpha1.Experiment) {
+
+	names := make(map[string]bool, len(exp.Spec.Parameters)+len(exp.Spec.DerivedParameters))
+	for i := range exp.Spec.Parameters {
+		names[exp.Spec.Parameters[i].Name] = true
+	}
+	for i := range exp.Spec.DerivedParameters {
+		dp := &exp.Spec.DerivedParameters[i]
+		if dp.Name == "" {
+			lint.For(i).Error().Missing("name")
+		} else if names[dp.Name] {
+			lint.For(i).Error().Failed("name", fmt.Errorf("'%s' is already defined", dp.Name))
+		}
+		names[dp.Name] = true
+	}
+
+	// Evaluate the derived parameters using the minimum parameter values
+	t := &redskyv1alpha1.Trial{}
+	for i := range exp.Spec.Parameters {
+		t.Spec.Assignments = append(t.Spec.Assignments, redskyv1alpha1.Assignment{Name: exp.Spec.Parameters[i].Name, Value: exp.Spec.Parameters[i].Min})
+	}
+	if err := experiment.PopulateDerivedAssignments(exp, t); err != nil {
+		lint.For(len(t.Spec.DerivedAssignments)).Error().Failed("expression", err)
+	}
+
+}
+
+// checkTemplates returns the template engine used to check the remaining templates, if the experiment uses templates
+// that are not available for linting a nil engine is returned
+func checkTemplates(lint Linter, experiment *redskyv1alpha1.Experiment) *template.Engine {
+	te := template.New()
+	if err := te.DefineExperimentTemplates(experiment); err != nil {
+		lint.Error().Failed("templates", err)
+		return nil
+	}
+
+	if len(experiment.Spec.TemplatesFrom) > 0 {
+		return nil
+	}
+	return te
+}
+
+func checkMetrics(lint Linter, te *template.Engine, metrics []redskyv1alpha1.Metric) {
 
 	if len(metrics) == 0 {
 		lint.Error().Missing("metrics")
 	}
 
 	for i := range metrics {
-		checkMetric(lint.For(i), &metrics[i])
+		checkMetric(lint.For(i), te, &metrics[i])
 	}
 
 }
 
-func checkMetric(lint Linter, metric *redskyv1alpha1.Metric) {
+func checkMetric(lint Linter, te *template.Engine, metric *redskyv1alpha1.Metric) {
 
 	if metric.Query == "" {
 		lint.Error().Missing("query")
@@ -173,57 +223,245 @@ func checkMetric(lint Linter, metric *redskyv1alpha1.Metric) {
 		lint.Error().Invalid("scheme", metric.Scheme, "http", "https")
 	}
 
-	if _, _, err := template.New().RenderMetricQueries(metric, &redskyv1alpha1.Trial{}, nil); err != nil {
-		lint.Error().Failed("query", err)
+	if te != nil {
+		if _, _, err := te.RenderMetricQueries(metric, &redskyv1alpha1.Trial{}, nil); err != nil {
+			lint.Error().Failed("query", err)
+		}
 	}
 
 }
 
-func checkPatches(lint Linter, patches []redskyv1alpha1.PatchTemplate) {
+func checkPatches(lint Linter, te *template.Engine, patches []redskyv1alpha1.PatchTemplate) {
 
 	if len(patches) == 0 {
 		lint.Error().Missing("patches")
 	}
 
 	for i := range patches {
-		checkPatch(lint.For(i), &patches[i])
+		checkPatch(lint.For(i), te, &patches[i])
+	}
+
+	if te != nil {
+		checkPatchOverlaps(lint, te, patches)
 	}
 
 }
 
-func checkPatch(lint Linter, patch *redskyv1alpha1.PatchTemplate) {
+func checkPatch(lint Linter, te *template.Engine, patch *redskyv1alpha1.PatchTemplate) {
 
-	if patch.TargetRef.APIVersion == "" {
+	switch patch.Type {
+	case redskyv1alpha1.PatchStrategic, redskyv1alpha1.PatchMerge, redskyv1alpha1.PatchJSON, redskyv1alpha1.PatchServerSide, "":
+	default:
+		lint.Error().Invalid("type", patch.Type, redskyv1alpha1.PatchStrategic, redskyv1alpha1.PatchMerge, redskyv1alpha1.PatchJSON, redskyv1alpha1.PatchServerSide)
+	}
+
+	if patch.TargetRef != nil && patch.TargetRef.APIVersion == "" {
 		// TODO Is is OK to skip this for the core kinds or should we still require "v1"?
-		if !isCoreKind(patch.TargetRef.Kind) {
+		// Server-side apply patches always need the API version to identify the object
+		if !isCoreKind(patch.TargetRef.Kind) || patch.Type == redskyv1alpha1.PatchServerSide {
 			lint.Error().Missing("API version")
 		}
 	}
 
-	if patch.TargetRef.Kind == "" {
+	if patch.TargetRef != nil && patch.TargetRef.Kind == "" {
 		lint.Error().Missing("kind")
 	}
 
-	if _, err := template.New().RenderPatch(patch, &redskyv1alpha1.Trial{}); err != nil {
-		lint.Error().Failed("patch", err)
+	if patch.Selector != nil {
+		if patch.TargetRef == nil {
+			lint.Error().Missing("targetRef")
+		} else if patch.TargetRef.Name != "" {
+			lint.Error().Failed("targetRef", fmt.Errorf("name is mutually exclusive with selector"))
+		}
+		if _, err := metav1.LabelSelectorAsSelector(patch.Selector); err != nil {
+			lint.Error().Failed("selector", err)
+		}
+	}
+
+	if te != nil {
+		if _, err := te.RenderPatch(patch, &redskyv1alpha1.Trial{}, nil, nil); err != nil {
+			lint.Error().Failed("patch", err)
+		}
+	}
+
+	for i := range patch.ReadinessGates {
+		checkConditionType(lint.For("readinessGates", i), patch.ReadinessGates[i].ConditionType)
 	}
 
 }
 
-func checkTrialTemplate(lint Linter, template *redskyv1alpha1.TrialTemplateSpec) {
-	checkTrial(lint.For("spec"), &template.Spec)
+// checkPatchOverlaps reports patches which set the same fields on the same object
+func checkPatchOverlaps(lint Linter, te *template.Engine, patches []redskyv1alpha1.PatchTemplate) {
+	t := &redskyv1alpha1.Trial{}
+	var pos []redskyv1alpha1.PatchOperation
+	var index []int
+	for i := range patches {
+		// Ignore patches that cannot be rendered (they are reported elsewhere) or that match by selector
+		ref, data, err := patch.RenderTemplate(te, nil, t, &patches[i], nil)
+		if err != nil || ref.Name == "" {
+			continue
+		}
+		po, err := patch.CreatePatchOperation(t, &patches[i], ref, data)
+		if err != nil || po == nil {
+			continue
+		}
+		pos = append(pos, *po)
+		index = append(index, i)
+	}
+
+	overlaps, err := patch.FindOverlaps(pos)
+	if err != nil {
+		return
+	}
+	for _, o := range overlaps {
+		lint.For(index[o.Second]).Error().Failed("patch", fmt.Errorf("overwrites %s from patch %d", o.Path, index[o.First]))
+	}
 }
 
-func checkTrial(lint Linter, trial *redskyv1alpha1.TrialSpec) {
+func checkRestore(lint Linter, restore redskyv1alpha1.RestorePolicy) {
+	switch restore {
+	case redskyv1alpha1.RestoreAlways, redskyv1alpha1.RestoreOnFinish, redskyv1alpha1.RestoreNever, "":
+	default:
+		lint.Error().Invalid("restore", restore, redskyv1alpha1.RestoreAlways, redskyv1alpha1.RestoreOnFinish, redskyv1alpha1.RestoreNever)
+	}
+}
+
+func checkTrialTemplate(lint Linter, te *template.Engine, template *redskyv1alpha1.TrialTemplateSpec) {
+	checkTrial(lint.For("spec"), te, &template.Spec)
+}
+
+func checkTrial(lint Linter, te *template.Engine, trial *redskyv1alpha1.TrialSpec) {
 	if trial.Template != nil {
 		checkJobTemplate(lint.For("template"), trial.Template)
 	}
+
+	if trial.ActiveDeadlineSeconds != nil && *trial.ActiveDeadlineSeconds <= 0 {
+		lint.Error().Failed("activeDeadlineSeconds", fmt.Errorf("must be a positive number of seconds"))
+	}
+
+	if trial.RetryLimit != nil && *trial.RetryLimit < 0 {
+		lint.Error().Failed("retryLimit", fmt.Errorf("must not be negative"))
+	}
+
+	if mw := trial.MeasurementWindow; mw != nil {
+		checkMeasurementWindow(lint.For("measurementWindow"), mw)
+	}
+
+	if trial.RunTemplate != nil {
+		if trial.Template != nil {
+			lint.Error().Failed("runTemplate", fmt.Errorf("cannot be used with a job template"))
+		}
+		checkRunTemplate(lint.For("runTemplate"), trial.RunTemplate)
+	}
+
+	if _, err := setup.OrderTasks(trial.SetupTasks); err != nil {
+		lint.Error().Failed("setupTasks", err)
+	}
+
+	for i := range trial.SetupTasks {
+		if trial.SetupTasks[i].Manifests != nil {
+			checkManifests(lint.For("setupTasks", i), &trial.SetupTasks[i])
+		}
+		checkSetupScope(lint.For("setupTasks", i), te, trial.SetupTasks, &trial.SetupTasks[i])
+	}
+
+	for i := range trial.ReadinessGates {
+		for j, c := range trial.ReadinessGates[i].ConditionTypes {
+			checkConditionType(lint.For("readinessGates", i, "conditionTypes", j), c)
+			if c == ready.ConditionTypeHTTPGet && trial.ReadinessGates[i].HTTPGet == nil {
+				lint.For("readinessGates", i).Error().Missing("httpGet")
+			}
+		}
+		if trial.ReadinessGates[i].Metric != nil {
+			checkMetric(lint.For("readinessGates", i, "metric"), te, trial.ReadinessGates[i].Metric)
+		}
+	}
+}
+
+func checkSetupScope(lint Linter, te *template.Engine, tasks []redskyv1alpha1.SetupTask, task *redskyv1alpha1.SetupTask) {
+	switch task.Scope {
+	case redskyv1alpha1.SetupScopeTrial, "":
+	case redskyv1alpha1.SetupScopeExperiment:
+		// Experiment scoped tasks run before any trial scoped tasks
+		for j, d := range task.DependsOn {
+			for k := range tasks {
+				if tasks[k].Name == d && setup.TaskScope(&tasks[k]) != redskyv1alpha1.SetupScopeExperiment {
+					lint.For("dependsOn", j).Error().Failed("dependency", fmt.Errorf("experiment scoped setup task cannot depend on trial scoped setup task '%s'", d))
+				}
+			}
+		}
+
+		// Experiment scoped tasks are shared by all the trials so they cannot use the trial assignments
+		// NOTE: Manifests from a config map are not available here, they are checked when the setup job is created
+		if te == nil {
+			te = template.New()
+		}
+		for j := range task.HelmValues {
+			hv := &task.HelmValues[j]
+			if hv.ValueFrom != nil && hv.ValueFrom.ParameterRef != nil {
+				lint.For("helmValues", j).Error().Failed("valueFrom", fmt.Errorf("experiment scoped setup task cannot use parameter '%s'", hv.ValueFrom.ParameterRef.Name))
+			} else if ok, err := te.ReferencesValues(hv.Name, hv.Value.String()); err != nil {
+				lint.For("helmValues", j).Error().Failed("value", err)
+			} else if ok {
+				lint.For("helmValues", j).Error().Failed("value", fmt.Errorf("experiment scoped setup task cannot use parameters"))
+			}
+		}
+	default:
+		lint.Error().Invalid("scope", task.Scope, redskyv1alpha1.SetupScopeTrial, redskyv1alpha1.SetupScopeExperiment)
+	}
+}
+
+func checkManifests(lint Linter, task *redskyv1alpha1.SetupTask) {
+	if task.HelmChart != "" {
+		lint.Error().Failed("manifests", fmt.Errorf("cannot be used with a Helm chart"))
+	}
+
+	m := task.Manifests
+	switch {
+	case m.ConfigMap != nil && m.URL != "":
+		lint.For("manifests").Error().Failed("url", fmt.Errorf("cannot be used with a config map"))
+	case m.ConfigMap == nil && m.URL == "":
+		lint.For("manifests").Error().Missing("configMap or url")
+	}
+}
+
+func checkConditionType(lint Linter, conditionType string) {
+	if strings.HasPrefix(conditionType, ready.ConditionTypeExpressionPrefix) {
+		if _, err := ready.ParseExpression(strings.TrimPrefix(conditionType, ready.ConditionTypeExpressionPrefix)); err != nil {
+			lint.Error().Failed("condition type", err)
+		}
+	}
 }
 
 func checkJobTemplate(lint Linter, template *v1beta1.JobTemplateSpec) {
 	checkJob(lint.For("spec"), &template.Spec)
 }
 
+func checkRunTemplate(lint Linter, template *redskyv1alpha1.TrialRunTemplate) {
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(template.Object.Raw); err != nil {
+		lint.Error().Failed("object", err)
+	}
+
+	if len(template.CompletedConditionTypes) == 0 {
+		lint.Error().Missing("completedConditionTypes")
+	}
+	for i, c := range template.CompletedConditionTypes {
+		checkConditionType(lint.For("completedConditionTypes", i), c)
+	}
+	for i, c := range template.FailedConditionTypes {
+		checkConditionType(lint.For("failedConditionTypes", i), c)
+	}
+
+	for _, path := range []struct{ name, text string }{{"startTimePath", template.StartTimePath}, {"completionTimePath", template.CompletionTimePath}} {
+		if path.text != "" {
+			if err := jsonpath.New(path.name).Parse(path.text); err != nil {
+				lint.Error().Failed(path.name, err)
+			}
+		}
+	}
+}
+
 func checkJob(lint Linter, job *batchv1.JobSpec) {
 	if job.BackoffLimit != nil && *job.BackoffLimit != 0 {
 		// TODO Instead of "Invalid" can we have "Suggested"?
@@ -240,3 +478,24 @@ func isCoreKind(kind string) bool {
 	}
 	return false
 }
+
+func checkMeasurementWindow(lint Linter, mw *redskyv1alpha1.MeasurementWindow) {
+	if mw.WarmUp != nil && mw.WarmUp.Duration < 0 {
+		lint.Error().Failed("warmUp", fmt.Errorf("must not be negative"))
+	}
+	if mw.CoolDown != nil && mw.CoolDown.Duration < 0 {
+		lint.Error().Failed("coolDown", fmt.Errorf("must not be negative"))
+	}
+	if mw.Duration != nil && mw.Duration.Duration <= 0 {
+		lint.Error().Failed("duration", fmt.Errorf("must be positive"))
+	}
+
+	switch mw.Anchor {
+	case redskyv1alpha1.MeasurementWindowStart, redskyv1alpha1.MeasurementWindowEnd, "":
+		if mw.Anchor != "" && mw.Duration == nil {
+			lint.Error().Failed("anchor", fmt.Errorf("requires a duration"))
+		}
+	default:
+		lint.Error().Invalid("anchor", mw.Anchor, redskyv1alpha1.MeasurementWindowStart, redskyv1alpha1.MeasurementWindowEnd)
+	}
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/initialize"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/kustomize"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/login"
+	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/logs"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/reset"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/results"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/revoke"
@@ -70,13 +71,13 @@ func NewRedskyctlCommand() *cobra.Command {
 	rootCmd.AddCommand(initialize.NewCommand(&initialize.Options{GeneratorOptions: initialize.GeneratorOptions{Config: cfg}, IncludeBootstrapRole: true}))
 	rootCmd.AddCommand(kustomize.NewCommand())
 	rootCmd.AddCommand(login.NewCommand(&login.Options{Config: cfg}))
+	rootCmd.AddCommand(logs.NewCommand(&logs.Options{Config: cfg}))
 	rootCmd.AddCommand(reset.NewCommand(&reset.Options{Config: cfg}))
 	rootCmd.AddCommand(results.NewCommand(&results.Options{Config: cfg}))
 	rootCmd.AddCommand(revoke.NewCommand(&revoke.Options{Config: cfg}))
 	rootCmd.AddCommand(version.NewCommand(&version.Options{Config: cfg}))
 
 	// TODO Add 'backup' and 'restore' maintenance commands ('maint' subcommands?)
-	// TODO We need helpers for doing a "dry run" on patches to make configuration easier
 	// TODO Add a "trial cleanup" command to run setup tasks (perhaps remove labels from standard setupJob)
 	// TODO Some kind of debug tool to evaluate metric queries
 	// TODO The "get" functionality needs to support templating so you can extract assignments for downstream use
@@ -22,12 +22,12 @@ import (
 	"io/ioutil"
 
 	"github.com/redskyops/redskyops-controller/internal/config"
+	"github.com/redskyops/redskyops-controller/internal/experiment"
 	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/authorize_cluster"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/grant_permissions"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/initialize"
 	"github.com/spf13/cobra"
-	"sigs.k8s.io/yaml"
 )
 
 // Options includes the configuration for the subcommands
@@ -46,6 +46,7 @@ func NewCommand(o *Options) *cobra.Command {
 
 	cmd.AddCommand(NewRBACCommand(&RBACOptions{}))
 	cmd.AddCommand(NewTrialCommand(&TrialOptions{}))
+	cmd.AddCommand(NewPatchesCommand(&PatchesOptions{Config: o.Config}))
 
 	// Also include plumbing generators used by other commands
 	cmd.AddCommand(authorize_cluster.NewGeneratorCommand(&authorize_cluster.GeneratorOptions{Config: o.Config}))
@@ -58,7 +59,7 @@ func NewCommand(o *Options) *cobra.Command {
 // TODO This should read all of the experiments from a stream of documents and return a list
 
 // readExperiment unmarshals experiment data
-func readExperiment(filename string, defaultReader io.Reader, experiment *redskyv1alpha1.Experiment) error {
+func readExperiment(filename string, defaultReader io.Reader, exp *redskyv1alpha1.Experiment) error {
 	if filename == "" {
 		return nil
 	}
@@ -76,11 +77,11 @@ func readExperiment(filename string, defaultReader io.Reader, experiment *redsky
 
 	// TODO Split on "---\n" ?
 
-	if err = yaml.Unmarshal(data, experiment); err != nil {
+	if err = experiment.UnmarshalManifest(data, exp); err != nil {
 		return err
 	}
-	if experiment.GroupVersionKind().GroupVersion() != redskyv1alpha1.GroupVersion || experiment.Kind != "Experiment" {
-		return fmt.Errorf("expected experiment, got: %s", experiment.GroupVersionKind())
+	if exp.GroupVersionKind().GroupVersion() != redskyv1alpha1.GroupVersion || exp.Kind != "Experiment" {
+		return fmt.Errorf("expected experiment, got: %s", exp.GroupVersionKind())
 	}
 	return nil
 }
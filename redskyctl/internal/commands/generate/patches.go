@@ -0,0 +1,301 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/redskyops/redskyops-controller/internal/config"
+	"github.com/redskyops/redskyops-controller/internal/experiment"
+	"github.com/redskyops/redskyops-controller/internal/patch"
+	"github.com/redskyops/redskyops-controller/internal/server"
+	"github.com/redskyops/redskyops-controller/internal/template"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/redskyops/redskyops-controller/redskyctl/internal/commander"
+	"github.com/redskyops/redskyops-controller/redskyctl/internal/commands/experiments"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// PatchesOptions are the options for rendering the patches of an experiment
+type PatchesOptions struct {
+	experiments.SuggestOptions
+
+	// Config is the Red Sky Configuration used to fetch the current state of the patch targets
+	Config *config.RedSkyConfig
+
+	Filename string
+	Diff     bool
+}
+
+// NewPatchesCommand returns a new command for rendering the patches of an experiment
+func NewPatchesCommand(o *PatchesOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "patches",
+		Short: "Generate experiment patches",
+		Long:  "Render the patches from an experiment manifest without running a trial",
+
+		PreRun: commander.StreamsPreRun(&o.IOStreams),
+		RunE:   commander.WithoutArgsE(o.generate),
+	}
+
+	cmd.Flags().StringVarP(&o.Filename, "filename", "f", o.Filename, "File that contains the experiment to generate patches for.")
+	cmd.Flags().StringToStringVarP(&o.Assignments, "assign", "A", nil, "Assign an explicit value to a parameter.")
+	cmd.Flags().BoolVar(&o.AllowInteractive, "interactive", o.AllowInteractive, "Allow interactive prompts for unspecified parameter assignments.")
+	cmd.Flags().StringVar(&o.DefaultBehavior, "default", "", "Select the behavior for default values; one of: none|min|max|rand.")
+	cmd.Flags().BoolVar(&o.Diff, "diff", o.Diff, "Fetch the current state of the patch targets and display the differences.")
+
+	_ = cmd.MarkFlagFilename("filename", "yml", "yaml")
+
+	commander.ExitOnError(cmd)
+	return cmd
+}
+
+func (o *PatchesOptions) generate() error {
+	ctx := context.Background()
+
+	exp := &redskyv1alpha1.Experiment{}
+	if err := readExperiment(o.Filename, o.In, exp); err != nil {
+		return err
+	}
+
+	// Build a trial using the requested assignments
+	_, serverExperiment := server.FromCluster(exp)
+	sug, err := o.SuggestAssignments(serverExperiment)
+	if err != nil {
+		return err
+	}
+	t := &redskyv1alpha1.Trial{}
+	experiment.PopulateTrialFromTemplate(exp, t)
+	server.ToClusterTrial(t, sug)
+	if err := experiment.PopulateDerivedAssignments(exp, t); err != nil {
+		return err
+	}
+
+	// Render each of the patches the same way the controller would
+	te := template.New()
+	if o.Diff || len(exp.Spec.TemplatesFrom) > 0 {
+		te.ConfigMaps = o.configMapLookup(ctx)
+	}
+	if err := te.DefineExperimentTemplates(exp); err != nil {
+		return err
+	}
+	for i := range exp.Spec.Patches {
+		p := &exp.Spec.Patches[i]
+
+		// The current state of the targets is only needed for a diff, otherwise a selector is displayed instead of
+		// the individual targets
+		targets := []*unstructured.Unstructured{nil}
+		if o.Diff && p.TargetRef != nil {
+			if targets, err = o.targets(ctx, t, p); err != nil {
+				return err
+			}
+		}
+
+		for _, target := range targets {
+			ref, data, err := patch.RenderTemplate(te, exp, t, p, target)
+			if err != nil {
+				return err
+			}
+
+			po, err := patch.CreatePatchOperation(t, p, ref, data)
+			if err != nil {
+				return err
+			} else if po == nil {
+				continue
+			}
+
+			if o.Diff {
+				err = o.printDiff(ctx, po, target)
+			} else {
+				err = o.printPatch(po, p.Selector)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// printPatch writes the rendered patch data as YAML
+func (o *PatchesOptions) printPatch(po *redskyv1alpha1.PatchOperation, selector *metav1.LabelSelector) error {
+	data, err := yaml.JSONToYAML(po.Data)
+	if err != nil {
+		return err
+	}
+
+	name := refName(&po.TargetRef)
+	if selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return err
+		}
+		name = fmt.Sprintf("%s[%s]", strings.TrimSuffix(name, "/"), s.String())
+	}
+
+	_, _ = fmt.Fprintf(o.Out, "---\n# %s (%s)\n", name, po.PatchType)
+	_, err = o.Out.Write(data)
+	return err
+}
+
+// printDiff writes a unified diff of the patched result, the current state of the patch target is fetched if necessary
+func (o *PatchesOptions) printDiff(ctx context.Context, po *redskyv1alpha1.PatchOperation, target *unstructured.Unstructured) error {
+	var original []byte
+	var err error
+	if target != nil {
+		original, err = target.MarshalJSON()
+	} else {
+		original, err = o.get(ctx, &po.TargetRef)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Strategic merge patches require the Go type, which is only available for built-in kinds
+	var dataStruct interface{}
+	if obj, err := scheme.Scheme.New(po.TargetRef.GroupVersionKind()); err == nil {
+		dataStruct = obj
+	}
+
+	patched, err := patch.Apply(original, po, dataStruct)
+	if err != nil {
+		return fmt.Errorf("unable to patch %s: %v", refName(&po.TargetRef), err)
+	}
+
+	a, err := yaml.JSONToYAML(original)
+	if err != nil {
+		return err
+	}
+	b, err := yaml.JSONToYAML(patched)
+	if err != nil {
+		return err
+	}
+
+	return difflib.WriteUnifiedDiff(o.Out, difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: "live/" + refName(&po.TargetRef),
+		ToFile:   "patched/" + refName(&po.TargetRef),
+		Context:  3,
+	})
+}
+
+// get returns the JSON representation of the current state of the referenced object
+func (o *PatchesOptions) get(ctx context.Context, ref *corev1.ObjectReference) ([]byte, error) {
+	return o.kubectlGet(ctx, ref, ref.Name)
+}
+
+// targets returns the current state of the objects referenced by the patch template
+func (o *PatchesOptions) targets(ctx context.Context, t *redskyv1alpha1.Trial, p *redskyv1alpha1.PatchTemplate) ([]*unstructured.Unstructured, error) {
+	// Default the namespace to the trial namespace, the same as the patch controller
+	ref := p.TargetRef.DeepCopy()
+	if ref.Namespace == "" {
+		ref.Namespace = t.Namespace
+	}
+
+	if p.Selector == nil {
+		data, err := o.get(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		u := &unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(data); err != nil {
+			return nil, err
+		}
+		return []*unstructured.Unstructured{u}, nil
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(p.Selector)
+	if err != nil {
+		return nil, err
+	}
+	data, err := o.kubectlGet(ctx, ref, "--selector", s.String())
+	if err != nil {
+		return nil, err
+	}
+	ul := &unstructured.UnstructuredList{}
+	if err := ul.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+
+	targets := make([]*unstructured.Unstructured, 0, len(ul.Items))
+	for i := range ul.Items {
+		targets = append(targets, &ul.Items[i])
+	}
+	return targets, nil
+}
+
+// configMapLookup returns a function for looking up config map data from patch templates
+func (o *PatchesOptions) configMapLookup(ctx context.Context) template.ConfigMapLookup {
+	return func(namespace, name string) (map[string]string, error) {
+		data, err := o.get(ctx, &corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: namespace, Name: name})
+		if err != nil {
+			return nil, err
+		}
+		cm := &corev1.ConfigMap{}
+		if err := json.Unmarshal(data, cm); err != nil {
+			return nil, err
+		}
+		return cm.Data, nil
+	}
+}
+
+// kubectlGet returns the JSON output of `kubectl get` for the kind of the referenced object
+func (o *PatchesOptions) kubectlGet(ctx context.Context, ref *corev1.ObjectReference, getArgs ...string) ([]byte, error) {
+	gvk := ref.GroupVersionKind()
+	resource := gvk.Kind
+	if gvk.Group != "" {
+		resource = strings.Join([]string{gvk.Kind, gvk.Version, gvk.Group}, ".")
+	}
+
+	args := append([]string{"get", "--output", "json", resource}, getArgs...)
+	if ref.Namespace != "" {
+		args = append(args, "--namespace", ref.Namespace)
+	}
+
+	kubectlGet, err := o.Config.Kubectl(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	var stdout bytes.Buffer
+	kubectlGet.Stdout = &stdout
+	kubectlGet.Stderr = o.ErrOut
+	if err := kubectlGet.Run(); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// refName returns a display name for an object reference
+func refName(ref *corev1.ObjectReference) string {
+	name := strings.ToLower(ref.Kind) + "/" + ref.Name
+	if ref.Namespace != "" {
+		name = ref.Namespace + "/" + name
+	}
+	return name
+}
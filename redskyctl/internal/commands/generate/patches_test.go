@@ -0,0 +1,138 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/redskyops/redskyops-controller/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPatchesCommand(t *testing.T) {
+	in := strings.NewReader(`apiVersion: redskyops.dev/v1alpha1
+kind: Experiment
+metadata:
+  name: test
+  namespace: default
+spec:
+  parameters:
+  - name: replicas
+    min: 1
+    max: 5
+  patches:
+  - targetRef:
+      apiVersion: apps/v1
+      kind: Deployment
+      name: app
+    patch: |
+      spec:
+        replicas: {{ .Values.replicas }}
+  - targetRef:
+      apiVersion: apps/v1
+      kind: Deployment
+    selector:
+      matchLabels:
+        component: worker
+    patch: |
+      spec:
+        replicas: {{ mul .Values.replicas 2 }}
+`)
+	var out bytes.Buffer
+
+	cmd := NewPatchesCommand(&PatchesOptions{})
+	cmd.SetIn(in)
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"--filename", "-", "--assign", "replicas=3"})
+	if assert.NoError(t, cmd.Execute()) {
+		assert.Equal(t, `---
+# default/deployment/app (application/strategic-merge-patch+json)
+spec:
+  replicas: 3
+---
+# default/deployment[component=worker] (application/strategic-merge-patch+json)
+spec:
+  replicas: 6
+`, out.String())
+	}
+}
+
+func TestNewPatchesCommand_Diff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "patches")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	// Use a fake kubectl that records its arguments and returns a deployment
+	kubectl := filepath.Join(dir, "kubectl")
+	args := filepath.Join(dir, "args")
+	script := `#!/bin/sh
+echo "$@" > ` + args + `
+echo '{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"app","namespace":"test"},"spec":{"replicas":1}}'
+`
+	if !assert.NoError(t, ioutil.WriteFile(kubectl, []byte(script), 0700)) {
+		return
+	}
+	cfg := &config.RedSkyConfig{Filename: filepath.Join(dir, "config")}
+	if !assert.NoError(t, ioutil.WriteFile(cfg.Filename, []byte("clusters:\n- name: default\n  cluster:\n    bin: "+kubectl+"\n"), 0600)) {
+		return
+	}
+	if !assert.NoError(t, cfg.Load()) {
+		return
+	}
+
+	in := strings.NewReader(`apiVersion: redskyops.dev/v1alpha1
+kind: Experiment
+metadata:
+  name: test
+  namespace: test
+spec:
+  parameters:
+  - name: replicas
+    min: 1
+    max: 5
+  patches:
+  - targetRef:
+      apiVersion: apps/v1
+      kind: Deployment
+      name: app
+    patch: |
+      spec:
+        replicas: {{ .Values.replicas }}
+`)
+	var out bytes.Buffer
+
+	cmd := NewPatchesCommand(&PatchesOptions{Config: cfg})
+	cmd.SetIn(in)
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"--filename", "-", "--assign", "replicas=3", "--diff"})
+	if assert.NoError(t, cmd.Execute()) {
+		// The target is fetched from the experiment namespace, not the current namespace of kubectl
+		data, err := ioutil.ReadFile(args)
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(data), "--namespace test")
+		}
+		assert.Contains(t, out.String(), "--- live/test/deployment/app\n+++ patched/test/deployment/app\n")
+		assert.Contains(t, out.String(), "-  replicas: 1\n+  replicas: 3\n")
+	}
+}
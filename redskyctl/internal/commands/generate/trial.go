@@ -83,6 +83,9 @@ func (o *TrialOptions) generate() error {
 	t := &redskyv1alpha1.Trial{}
 	experiment.PopulateTrialFromTemplate(exp, t)
 	server.ToClusterTrial(t, sug)
+	if err := experiment.PopulateDerivedAssignments(exp, t); err != nil {
+		return err
+	}
 
 	// TODO Explicitly complete "generateName" and clear it out?
 
@@ -34,6 +34,9 @@ nameReference:
   - path: spec/template/spec/setupTasks/helmValuesFrom/configMap/name
     group: redskyops.dev
     kind: Experiment
+  - path: spec/template/spec/setupTasks/manifests/configMap/name
+    group: redskyops.dev
+    kind: Experiment
   - path: spec/template/spec/template/spec/template/spec/volumes/configMap/name
     group: redskyops.dev
     kind: Experiment
@@ -52,6 +55,12 @@ nameReference:
   - path: spec/template/spec/template/spec/template/spec/volumes/projected/sources/configMap/name
     group: redskyops.dev
     kind: Experiment
+  - path: spec/template/spec/setupPodTemplate/envFrom/configMapRef/name
+    group: redskyops.dev
+    kind: Experiment
+  - path: spec/template/spec/setupTasks/envFrom/configMapRef/name
+    group: redskyops.dev
+    kind: Experiment
 
 - kind: Secret
   version: v1
@@ -77,6 +86,18 @@ nameReference:
   - path: spec/template/spec/template/spec/template/spec/volumes/projected/sources/secret/name
     group: redskyops.dev
     kind: Experiment
+  - path: spec/template/spec/setupVolumes/secret/secretName
+    group: redskyops.dev
+    kind: Experiment
+  - path: spec/template/spec/setupPodTemplate/envFrom/secretRef/name
+    group: redskyops.dev
+    kind: Experiment
+  - path: spec/template/spec/setupTasks/envFrom/secretRef/name
+    group: redskyops.dev
+    kind: Experiment
+  - path: spec/template/spec/setupPodTemplate/imagePullSecrets/name
+    group: redskyops.dev
+    kind: Experiment
 
 - kind: ServiceAccount
   version: v1
@@ -0,0 +1,121 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/redskyops/redskyops-controller/internal/config"
+	"github.com/redskyops/redskyops-controller/internal/trial"
+	redskyv1alpha1 "github.com/redskyops/redskyops-controller/pkg/apis/redsky/v1alpha1"
+	"github.com/redskyops/redskyops-controller/redskyctl/internal/commander"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Options is the configuration for retrieving archived logs
+type Options struct {
+	// Config is the Red Sky Configuration used to access the cluster
+	Config *config.RedSkyConfig
+	// IOStreams are used to access the standard process streams
+	commander.IOStreams
+
+	// Namespace is the namespace of the trial
+	Namespace string
+	// Name is the name of the trial
+	Name string
+}
+
+// NewCommand creates a new command for retrieving archived logs
+func NewCommand(o *Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs trial NAME",
+		Short: "Print archived trial logs",
+		Long:  "Print the logs of the trial run and setup pods archived by the controller when the trial finished",
+
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("expected a type and a name, got %d arguments", len(args))
+			}
+			if args[0] != "trial" {
+				return fmt.Errorf("cannot get logs for %s", args[0])
+			}
+			o.Name = args[1]
+			return nil
+		},
+		ValidArgs: []string{"trial"},
+
+		PreRun: commander.StreamsPreRun(&o.IOStreams),
+		RunE:   commander.WithContextE(o.logs),
+	}
+
+	cmd.Flags().StringVarP(&o.Namespace, "namespace", "n", "", "Namespace of the trial.")
+
+	commander.ExitOnError(cmd)
+	return cmd
+}
+
+func (o *Options) logs(ctx context.Context) error {
+	t := &redskyv1alpha1.Trial{}
+	t.Name = o.Name
+
+	args := []string{"get", "--output", "json", "configmap", trial.LogsConfigMapName(t)}
+	if o.Namespace != "" {
+		args = append(args, "--namespace", o.Namespace)
+	}
+	kubectlGet, err := o.Config.Kubectl(ctx, args...)
+	if err != nil {
+		return err
+	}
+	var stdout bytes.Buffer
+	kubectlGet.Stdout = &stdout
+	kubectlGet.Stderr = o.ErrOut
+	if err := kubectlGet.Run(); err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := json.Unmarshal(stdout.Bytes(), cm); err != nil {
+		return err
+	}
+	return printLogs(o, cm)
+}
+
+// printLogs writes each archived log preceded by a header identifying the pod and container
+func printLogs(o *Options, cm *corev1.ConfigMap) error {
+	keys := make([]string, 0, len(cm.Data))
+	for k := range cm.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		if i > 0 {
+			if _, err := fmt.Fprintln(o.Out); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(o.Out, "==> %s <==\n%s", k, cm.Data[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
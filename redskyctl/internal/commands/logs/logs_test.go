@@ -0,0 +1,74 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/redskyops/redskyops-controller/redskyctl/internal/commander"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNewCommand_Args(t *testing.T) {
+	cases := []struct {
+		desc string
+		args []string
+		name string
+		err  string
+	}{
+		{desc: "trial", args: []string{"trial", "test-001"}, name: "test-001"},
+		{desc: "missing name", args: []string{"trial"}, err: "expected a type and a name, got 1 arguments"},
+		{desc: "too many", args: []string{"trial", "test-001", "test-002"}, err: "expected a type and a name, got 3 arguments"},
+		{desc: "unknown type", args: []string{"experiment", "test"}, err: "cannot get logs for experiment"},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			o := &Options{}
+			cmd := NewCommand(o)
+			err := cmd.Args(cmd, c.args)
+			if c.err != "" {
+				assert.EqualError(t, err, c.err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.name, o.Name)
+			}
+		})
+	}
+}
+
+func TestPrintLogs(t *testing.T) {
+	var out bytes.Buffer
+	o := &Options{IOStreams: commander.IOStreams{Out: &out}}
+	cm := &corev1.ConfigMap{
+		Data: map[string]string{
+			"test-001-create-abcde.test-001-create-app.log": "created\n",
+			"test-001-abcde.default-trial-run.log":          "Sleeping for 2m0s...\nDone.\n",
+		},
+	}
+
+	if assert.NoError(t, printLogs(o, cm)) {
+		assert.Equal(t, "==> test-001-abcde.default-trial-run.log <==\nSleeping for 2m0s...\nDone.\n\n==> test-001-create-abcde.test-001-create-app.log <==\ncreated\n", out.String())
+	}
+
+	out.Reset()
+	if assert.NoError(t, printLogs(o, &corev1.ConfigMap{})) {
+		assert.Empty(t, out.String())
+	}
+}